@@ -0,0 +1,392 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	async
+	Abstract:	Asynchronous bleating.  In async mode the bleater's target is replaced
+				by a writer which queues each message on a bounded ring; a go routine
+				writes the queued messages to the real target so that a slow target
+				(e.g. a log on NFS) doesn't hold up the callers of Baa().  When the
+				ring is full the overflow policy decides whether the new message is
+				dropped, the oldest queued message is dropped, or the caller waits.
+				A count of dropped messages is written now and again.
+
+				Children are given the queue as their target, so they bleat through
+				it too.  Setting a new target (e.g. when the herder rolls the log)
+				changes where the queue is written; messages already queued are
+				written to the new target.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package bleater
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	ASYNC_DROP_NEWEST	int = iota		// when full, the message being bleated is dropped
+	ASYNC_DROP_OLDEST					// when full, the oldest queued message is dropped
+	ASYNC_BLOCK							// when full, the bleater waits for room
+
+	def_drop_report		time.Duration = 60 * time.Second
+)
+
+type async_writer struct {
+	mtx			sync.Mutex			// protects the ring and counters
+	ring		[]*Record
+	head		int
+	count		int
+	policy		int
+	busy		bool				// writer has messages taken from the ring and not yet written
+	closed		bool
+	dropped		uint64				// since the last report
+	tdropped	uint64				// total
+	not_full	*sync.Cond
+	idle		*sync.Cond
+
+	wmtx		sync.Mutex			// held while writing; protects target
+	target		io.Writer
+
+	wake		chan bool
+	stop		chan bool
+	done		chan bool
+	report		time.Duration
+
+	format		int					// used to format the drop report; taken from the bleater
+	tsfmt		string
+	pfx			string
+}
+
+/*
+	Create the writer which queues for target. The caller starts the writer go routine.
+*/
+func mk_async( target io.Writer, size int, policy int, report time.Duration ) ( a *async_writer ) {
+	if size < 1 {
+		size = 1
+	}
+	if report <= 0 {
+		report = def_drop_report
+	}
+
+	a = &async_writer{
+		ring:	make( []*Record, size ),
+		policy:	policy,
+		target:	target,
+		wake:	make( chan bool, 1 ),
+		stop:	make( chan bool ),
+		done:	make( chan bool ),
+		report:	report,
+	}
+	a.not_full = sync.NewCond( &a.mtx )
+	a.idle = sync.NewCond( &a.mtx )
+
+	return a
+}
+
+/*
+	Queue a copy of the message.
+*/
+func (a *async_writer) Write( p []byte ) ( int, error ) {
+	line := make( []byte, len( p ) )					// caller may reuse the buffer
+	copy( line, p )
+	a.queue( &Record{ Line: line, plain: true } )
+
+	return len( p ), nil
+}
+
+/*
+	Queue the record; the bleater makes a new one for each message so it isn't copied.
+*/
+func (a *async_writer) Write_record( r *Record ) ( error ) {
+	a.queue( r )
+	return nil
+}
+
+/*
+	Add the record to the ring, or deal with it according to the policy if the ring is
+	full. Once closed, records are written straight through.
+*/
+func (a *async_writer) queue( r *Record ) {
+	a.mtx.Lock()
+
+	for a.count == len( a.ring ) && ! a.closed {
+		switch a.policy {
+			case ASYNC_DROP_OLDEST:
+				a.ring[a.head] = nil
+				a.head = (a.head + 1) % len( a.ring )
+				a.count--
+				a.dropped++
+				a.tdropped++
+
+			case ASYNC_BLOCK:
+				a.not_full.Wait()
+
+			default:
+				a.dropped++
+				a.tdropped++
+				a.mtx.Unlock()
+				return
+		}
+	}
+
+	if a.closed {
+		a.mtx.Unlock()
+		a.write( r )
+		return
+	}
+
+	a.ring[(a.head + a.count) % len( a.ring )] = r
+	a.count++
+	a.mtx.Unlock()
+
+	select {
+		case a.wake <- true:
+		default:									// writer already has a wake up pending
+	}
+}
+
+/*
+	Write one message to the real target.
+*/
+func (a *async_writer) write( r *Record ) {
+	a.wmtx.Lock()
+	defer a.wmtx.Unlock()
+
+	if rt, ok := a.target.( Record_target ); ok && ! r.plain {
+		rt.Write_record( r )
+	} else {
+		a.target.Write( r.Line )
+	}
+}
+
+/*
+	Change the real target. Waits for a write in progress to finish so the caller can
+	safely close the old target.
+*/
+func (a *async_writer) set_target( target io.Writer ) {
+	a.wmtx.Lock()
+	a.target = target
+	a.wmtx.Unlock()
+}
+
+/*
+	Write everything queued, including things queued while writing.
+*/
+func (a *async_writer) drain( ) {
+	for {
+		a.mtx.Lock()
+		if a.count == 0 {
+			a.busy = false
+			a.idle.Broadcast()
+			a.mtx.Unlock()
+			return
+		}
+
+		batch := make( []*Record, a.count )
+		for i := range batch {
+			idx := (a.head + i) % len( a.ring )
+			batch[i] = a.ring[idx]
+			a.ring[idx] = nil
+		}
+		a.head = 0
+		a.count = 0
+		a.busy = true
+		a.not_full.Broadcast()
+		a.mtx.Unlock()
+
+		for _, r := range batch {
+			a.write( r )
+		}
+	}
+}
+
+/*
+	Write the count of messages dropped since the last report, if any.
+*/
+func (a *async_writer) drop_report( ) {
+	a.mtx.Lock()
+	n := a.dropped
+	total := a.tdropped
+	a.dropped = 0
+	a.mtx.Unlock()
+
+	if n > 0 {
+		r := &Record{ Time: time.Now(), Level: WARN, Prefix: a.pfx,
+			Msg: fmt.Sprintf( "async bleat queue was full: %d messages dropped (%d total)", n, total ) }
+		r.Line = format_line( a.format, r.Time, a.tsfmt, r.Prefix, r.Level, r.Msg, nil )
+		a.write( r )
+	}
+}
+
+/*
+	The go routine which writes the queued messages.
+*/
+func (a *async_writer) writer( ) {
+	ticker := time.NewTicker( a.report )
+	defer ticker.Stop()
+
+	for {
+		select {
+			case <-a.wake:
+				a.drain()
+
+			case <-ticker.C:
+				a.drop_report()
+
+			case <-a.stop:
+				a.drain()
+				a.drop_report()
+				close( a.done )
+				return
+		}
+	}
+}
+
+/*
+	Wait until everything queued before the call has been written.
+*/
+func (a *async_writer) flush( ) {
+	select {
+		case a.wake <- true:
+		default:
+	}
+
+	a.mtx.Lock()
+	for a.count > 0 || a.busy {
+		a.idle.Wait()
+	}
+	a.mtx.Unlock()
+}
+
+/*
+	Drain the queue and stop the writer. Anything written afterwards goes straight
+	through to the target.
+*/
+func (a *async_writer) close( ) {
+	a.mtx.Lock()
+	if a.closed {
+		a.mtx.Unlock()
+		return
+	}
+	a.closed = true										// new messages now go straight through rather than being lost
+	a.not_full.Broadcast()								// as do those from anyone blocked waiting for room
+	a.mtx.Unlock()
+
+	close( a.stop )
+	<-a.done
+}
+
+// --------------- public -------------------------------------------------------------------------------------
+
+/*
+	Set_async puts the bleater, and its children, into async mode. Messages are queued
+	on a ring of size messages and written by a go routine.  Policy (ASYNC_DROP_NEWEST,
+	ASYNC_DROP_OLDEST or ASYNC_BLOCK) says what happens when the ring is full.  When
+	messages have been dropped a count is written every report period (a minute if
+	report is zero) using the bleater's format and prefix as they are when this is
+	called.
+
+	Flush() waits for the queue to be written, and Close() drains it and stops the
+	go routine; one of them should be called before the programme exits.  An error is
+	returned if the bleater is already in async mode.
+*/
+func ( b *Bleater ) Set_async( size int, policy int, report time.Duration ) ( error ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.async != nil {
+		return fmt.Errorf( "bleater is already in async mode" )
+	}
+
+	a := mk_async( b.target, size, policy, report )
+	a.format = b.format
+	a.tsfmt = b.tsfmt
+	a.pfx = b.pfx
+	b.async = a
+	b.target = a
+	for i := 0; i < b.cidx; i++ {
+		b.children[i].Set_target( a, false )
+	}
+
+	go a.writer()
+	return nil
+}
+
+/*
+	Async_dropped returns the total number of messages dropped because the async queue
+	was full. Zero is returned if the bleater isn't in async mode.
+*/
+func ( b *Bleater ) Async_dropped( ) ( uint64 ) {
+	b.mtx.Lock()
+	a := b.async
+	b.mtx.Unlock()
+
+	if a == nil {
+		return 0
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.tdropped
+}
+
+/*
+	Flush waits until all messages queued in async mode have been written. It does
+	nothing when not in async mode.
+*/
+func ( b *Bleater ) Flush( ) {
+	b.mtx.Lock()
+	a := b.async
+	b.mtx.Unlock()
+
+	if a != nil {
+		a.flush()
+	}
+}
+
+/*
+	Close drains the async queue, if in async mode, and stops the go routine which
+	writes it, then closes the target if it was opened by the bleater (Create_target(),
+	Append_target() or the herder).  Messages bleated after Close are written straight
+	through to the target, if it is still open.
+*/
+func ( b *Bleater ) Close( ) {
+	b.mtx.Lock()
+	a := b.async
+	b.mtx.Unlock()
+
+	if a != nil {
+		a.close()
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.tfile != nil {
+		b.tfile.Close()
+		b.tfile = nil
+	}
+}
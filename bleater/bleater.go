@@ -26,6 +26,13 @@
 	Author:		E. Scott Daniels
 
 	Mods:		30 Apr 2014 (sd) : Added ability to change the target.
+				18 Oct 2026 (agent) : Added key/value bleats, bound fields and the
+					logfmt and json output formats.
+				18 Oct 2026 (agent) : Level may be written by name (FMT_TEXT_NAMED).
+				18 Oct 2026 (agent) : Set_target() closes the old target after the switch
+					and passes the target to the async queue when in async mode.
+				18 Oct 2026 (agent) : Messages are also written to a capture ring when
+					one is set, whatever the level (see ring.go).
 */
 
 
@@ -53,6 +60,15 @@
 	a 'mask' if a different layout is desired.  Masks are as described in
 	the Golang time package.  Bleat messages are automatically terminated
 	with a newline, so including one in the message is not needed.
+
+	Baa_kv() writes a message followed by key/value fields (e.g. user=fred
+	tries=3) and With() binds fields to the bleater so that they are added to
+	every message it writes.  Set_format() selects the output format: the text
+	format described above (fields are added as k=v after the message), the
+	text format with the level name (ERR, WARN, INFO, DEBUG, TRACE; see levels.go)
+	in place of the number, logfmt, or JSON with one object per line.  A child added with Add_child() inherits
+	the parent's format and bound fields in the same way that it inherits the
+	target.
 */
 package bleater
 
@@ -76,6 +92,19 @@ type Bleater struct {
 	pfx		string
 	tsfmt	string
 	bleat_some	map[string]int	// counter for each bleat_some class
+	format	int				// FMT_ constant
+	fields	[]interface{}	// key/value pairs bound with With()
+	pfields	[]interface{}	// bound fields pushed from the parent
+	herd	*herd			// log rolling settings (see sheep_herder.go)
+	async	*async_writer	// queue when in async mode (see async.go)
+	caller	bool			// add caller location and goroutine id (see caller.go)
+	stack	bool			// add a stack trace to messages at or below stack_level
+	stack_level	uint
+	limits	map[string]*bucket	// rate limits by class (see limit.go)
+	derived	map[string]*bucket	// buckets for classes limited by the default (class "") limit
+	dedup	*dedup			// collapses repeated messages
+	ring	*Ring			// captures messages at or below clevel (see ring.go)
+	clevel	uint
 }
 
 // --------------- private -------------------------------------------------------------------------------------
@@ -89,6 +118,110 @@ func ( b *Bleater ) set_plevel( l uint ) {
 	b.plevel = l
 }
 
+/*
+	Set the format and the fields bound by the parent (and its ancestors) and push them
+	along to our children. Called only by the parent.
+*/
+func ( b *Bleater ) set_inherited( format int, pfields []interface{} ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.format = format
+	b.pfields = pfields
+	b.push_inherited( )
+}
+
+/*
+	Push our format and all fields (inherited and our own) to the children.
+	Caller must hold the lock.
+*/
+func ( b *Bleater ) push_inherited( ) {
+	all := b.bound_fields( nil )
+	for i := 0; i < b.cidx; i++ {
+		b.children[i].set_inherited( b.format, all )
+	}
+}
+
+/*
+	Return a new list with the parent's fields, our fields and the extra fields given.
+	Caller must hold the lock.
+*/
+func ( b *Bleater ) bound_fields( extra []interface{} ) ( []interface{} ) {
+	if len( b.pfields ) + len( b.fields ) + len( extra ) == 0 {
+		return nil
+	}
+
+	all := make( []interface{}, 0, len( b.pfields ) + len( b.fields ) + len( extra ) )
+	all = append( all, b.pfields... )
+	all = append( all, b.fields... )
+	return append( all, extra... )
+}
+
+/*
+	Returns true if a message at the level is written to the target (not just to the
+	capture ring).  Rate limits, de-duplication and caller information apply only to
+	these messages. Caller must hold the lock.
+*/
+func ( b *Bleater ) to_target( when uint ) ( bool ) {
+	return when <= b.level || when <= b.plevel
+}
+
+/*
+	Format and write the message. The level has already been checked. Depth is the
+	number of bleater functions between the user's call and this one (including the
+	caller of emit) and is used to find the user's call when adding caller information.
+*/
+func ( b *Bleater ) emit( depth int, when uint, msg string, kv []interface{} ) {
+	if len( kv ) > 0 {
+		kv = kv_pairs( kv )
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if ! b.to_target( when ) {
+		if b.ring != nil && when <= b.clevel {						// only captured; written as is
+			b.write( time.Now(), when, msg, b.bound_fields( kv ) )
+		}
+		return														// else level lowered since the caller checked
+	}
+
+	now := time.Now()
+	if b.dedup != nil && b.dedup.collapse( b, now, when, msg, kv ) {		// repeat of the last message
+		return
+	}
+
+	if b.caller || (b.stack && when <= b.stack_level) {			// nothing more than this check unless asked for
+		kv = append( kv, b.caller_fields( depth + 1, when )... )
+	}
+	b.write( now, when, msg, b.bound_fields( kv ) )
+}
+
+/*
+	Write the message with all of its fields to the target if the level allows, and to
+	the capture ring if it wants it. Caller must hold the lock.
+*/
+func ( b *Bleater ) write( now time.Time, when uint, msg string, fields []interface{} ) {
+	to_target := b.to_target( when )
+	to_ring := b.ring != nil && when <= b.clevel
+	if ! to_target && ! to_ring {
+		return
+	}
+
+	r := &Record{ Time: now, Level: when, Prefix: b.pfx, Msg: msg, Fields: fields, Line: format_line( b.format, now, b.tsfmt, b.pfx, when, msg, fields ) }
+	if to_target {
+		if rt, ok := b.target.( Record_target ); ok {
+			rt.Write_record( r )
+		} else {
+			b.target.Write( r.Line )
+		}
+	}
+
+	if to_ring {
+		b.ring.Write_record( r )
+	}
+}
+
 // --------------- public -------------------------------------------------------------------------------------
 
 /*
@@ -120,39 +253,90 @@ func ( b *Bleater ) Baa( when uint, uformat string, va ...interface{} ) {
 		return
 	}
 
-	if when <= b.level || when <= b.plevel {	// bleat when our level is set high or when parent (master) level is set high
-		b.mtx.Lock(); 							// yes we check before the lock so the call isn't expensive when level is low
-		defer b.mtx.Unlock()
+	if when <= b.level || when <= b.plevel || when <= b.clevel {	// bleat when our level is set high or when parent (master) level is set high, or to capture
+		b.emit( 1, when, fmt.Sprintf( uformat, va... ), nil )		// yes we check before the lock so the call isn't expensive when level is low
+	}
+}
+
+/*
+	Baa_kv writes the message followed by the key/value pairs given as alternating keys
+	and values:
+		b.Baa_kv( 1, "login failed", "user", uname, "tries", n )
+
+	Fields bound with With() are written before those given. The level is treated as it
+	is by Baa().
+*/
+func ( b *Bleater ) Baa_kv( when uint, msg string, kv ...interface{} ) {
+	if b == nil {
+		return
+	}
 
-		n := time.Now()
-		//fmt.Fprintf( b.target, "%d %s %10s [%d] %s\n", n.Unix(), n.Format( b.tsfmt ), b.pfx, when, fmt.Sprintf( uformat, va... ) )
-		fmt.Fprintf( b.target, "%d %s %10s [%d] %s\n", n.Unix(), n.UTC().Format( b.tsfmt ), b.pfx, when, fmt.Sprintf( uformat, va... ) )
+	if when <= b.level || when <= b.plevel || when <= b.clevel {
+		b.emit( 1, when, msg, kv )
 	}
 }
 
+/*
+	With binds the key/value pairs (alternating keys and values) to the bleater so that
+	they are written with every message it, and its children, write. The bleater is
+	returned so that the call can be chained:
+		b := bleater.Mk_bleater( 1, os.Stderr ).With( "host", hname )
+*/
+func ( b *Bleater ) With( kv ...interface{} ) ( *Bleater ) {
+	kv = kv_pairs( kv )
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.fields = append( b.fields, kv... )
+	b.push_inherited( )
+	return b
+}
+
+/*
+	Set_format selects the output format (FMT_TEXT, FMT_TEXT_NAMED, FMT_LOGFMT or FMT_JSON)
+	and pushes it to any child bleaters.
+*/
+func ( b *Bleater ) Set_format( format int ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.format = format
+	b.push_inherited( )
+}
+
 /*
 	Allows a caller to bleat messages belonging to a 'class' less often than every time called.
 	The Baa_some function accepts additional parameters (class name, and frequency) and will
 	bleat the message on the first call, and then every frequency of calls there after.
 	Frequency is not saved with the class, so it is possible to change the frequency at any time.
+	Counts never decay, so a class which is quiet for a long time still waits for freq calls.
 
 	The class counter is incremented only if the message would otherwise be written with respect
-	to the value of when.  Thus, a class poised to write a message on the next invocation
+	to the value of when; a message which only goes to the capture ring is always captured and
+	isn't counted.  Thus, a class poised to write a message on the next invocation
 	will write that message as soon as the level is appropriate, and does not run the risk of
 	always skipping if levels fluxuate.
+
+	Deprecated: Baa_some is kept as it was for existing callers; use Baa_limit(), which
+	limits a class to n messages an interval and reports how many were suppressed.
 */
 func ( b *Bleater ) Baa_some( class string, freq int, when uint, uformat string, va ...interface{} ) {
 	if b == nil {
 		return
 	}
-	if !( when <= b.level || when <= b.plevel) {		// wouldn't bleat, don't bump the counter
+	if !( when <= b.level || when <= b.plevel || when <= b.clevel ) {		// wouldn't bleat, don't bump the counter
+		return
+	}
+	if !( when <= b.level || when <= b.plevel ) {					// only captured; the counter is for the target
+		b.emit( 1, when, fmt.Sprintf( uformat, va... ), nil )
 		return
 	}
 
 	c, ok := b.bleat_some[class]
 	if ! ok || c >= freq {						// c could be > if freq was lowered
 		b.bleat_some[class] = 1
-		b.Baa( when, uformat, va... )			// write if level is ok
+		b.emit( 1, when, fmt.Sprintf( uformat, va... ), nil )		// level checked above
 	} else {
 		b.bleat_some[class]++
 	}
@@ -190,6 +374,8 @@ func ( b *Bleater ) Add_child( cb *Bleater ) {
 	b.cidx++;	
 	cb.set_plevel( b.level )
 	cb.Set_target( b.target, true )
+	cb.set_inherited( b.format, b.bound_fields( nil ) )
+	cb.set_capture( b.ring, b.clevel )
 }
 
 /*
@@ -211,15 +397,22 @@ func ( b *Bleater ) Set_target( new_target io.Writer, close_old bool ) {
 	b.mtx.Lock()
 	defer b.mtx.Unlock()
 
-	if close_old  && b.tfile != nil {
-		b.tfile.Close( )
-	}
+	old := b.tfile
 	b.tfile = nil
 
+	if b.async != nil && new_target != io.Writer( b.async ) {		// in async mode the queue is written to the new target
+		b.async.set_target( new_target )
+		new_target = b.async
+	}
+
 	b.target = new_target
 	for i := 0; i < b.cidx; i++ {
 		b.children[i].Set_target( new_target, false )			// propigate the target, but we might've closed it so they shouldn't
 	}
+
+	if close_old && old != nil {								// close only after no one can be writing to it
+		old.Close( )
+	}
 }
 
 /*
@@ -21,9 +21,21 @@
 package bleater_test
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"testing"
 	"os"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/att/gopkgs/bleater"
 )
@@ -121,3 +133,680 @@ func TestBleater( t *testing.T ) {
 }
 
 
+
+/*
+	Key/value bleats in each of the formats, and bound fields inherited by a child.
+*/
+func TestKv( t *testing.T ) {
+	buf := &bytes.Buffer{}
+	parent := bleater.Mk_bleater( 1, buf )
+	parent.Set_prefix( "parent" )
+	parent.With( "host", "sheepfold" )
+
+	kid := bleater.Mk_bleater( 1, os.Stderr )
+	kid.Set_prefix( "kid" )
+	kid.With( "pen", 3 )
+	parent.Add_child( kid )
+
+	kid.Baa_kv( 1, "text message", "user", "fred", "msg", "has spaces" )
+	line := buf.String()
+	if ! strings.Contains( line, "[1] text message host=sheepfold pen=3 user=fred msg=\"has spaces\"" ) {
+		fmt.Fprintf( os.Stderr, "text kv line not right: %s", line )
+		t.Fail( )
+	}
+
+	buf.Reset()
+	parent.Set_format( bleater.FMT_LOGFMT )					// child should pick this up
+	kid.Baa_kv( 1, "logfmt message", "odd" )
+	line = buf.String()
+	if ! strings.HasPrefix( line, "ts=" ) || ! strings.Contains( line, " level=WARN prefix=kid msg=\"logfmt message\" host=sheepfold pen=3 odd=!MISSING" ) {
+		fmt.Fprintf( os.Stderr, "logfmt line not right: %s", line )
+		t.Fail( )
+	}
+
+	buf.Reset()
+	parent.Set_format( bleater.FMT_JSON )
+	kid.Baa_kv( 1, "json message", "pen", 4, "err", fmt.Errorf( "gate open" ) )
+	kid.Baa( 2, "should not show" )
+	line = buf.String()
+	rec := struct {
+		Ts		string
+		Level	string
+		Prefix	string
+		Msg		string
+		Fields	map[string]interface{}
+	} {}
+	if err := json.Unmarshal( buf.Bytes(), &rec ); err != nil {
+		fmt.Fprintf( os.Stderr, "json line didn't parse: %s: %s", err, line )
+		t.Fail( )
+	}
+	if rec.Ts == "" || rec.Level != "WARN" || rec.Prefix != "kid" || rec.Msg != "json message" ||
+		rec.Fields["host"] != "sheepfold" || rec.Fields["pen"] != float64( 4 ) || rec.Fields["err"] != "gate open" {
+		fmt.Fprintf( os.Stderr, "json record not right: %s", line )
+		t.Fail( )
+	}
+	fmt.Fprintf( os.Stderr, "json: %s", line )
+
+	var nil_err *nil_error												// typed nil in an interface; its methods panic
+	var nil_str *nil_stringer
+	for _, format := range []int{ bleater.FMT_TEXT, bleater.FMT_LOGFMT, bleater.FMT_JSON } {
+		buf.Reset()
+		parent.Set_format( format )
+		kid.Baa_kv( 1, "nil values", "err", error( nil_err ), "who", fmt.Stringer( nil_str ) )
+		if line = buf.String(); ! strings.Contains( line, "nil values" ) || strings.Count( strings.Replace( line, `\u003cnil\u003e`, "<nil>", -1 ), "<nil>" ) != 2 {	// json escapes <>
+			fmt.Fprintf( os.Stderr, "typed nil fields not written as <nil> (format %d): %s", format, line )
+			t.Fail( )
+		}
+	}
+}
+
+type nil_error struct { msg string }
+func (e *nil_error) Error( ) ( string ) { return e.msg }
+
+type nil_stringer struct { name string }
+func (s *nil_stringer) String( ) ( string ) { return s.name }
+
+/*
+	Named levels and setting levels of registered bleaters by name.
+*/
+func TestLevels( t *testing.T ) {
+	for _, s := range []string{ "err", "WARN", "Info", "debug", "TRACE", "TRACE+2", "3" } {
+		l, err := bleater.Parse_level( s )
+		if err != nil {
+			fmt.Fprintf( os.Stderr, "parse of %s failed: %s\n", s, err )
+			t.Fail( )
+			continue
+		}
+		fmt.Fprintf( os.Stderr, "level %s = %d = %s\n", s, l, bleater.Level_name( l ) )
+	}
+	if l, _ := bleater.Parse_level( "TRACE+2" ); l != 6 || bleater.Level_name( l ) != "TRACE+2" {
+		fmt.Fprintf( os.Stderr, "TRACE+2 didn't round trip: %d\n", l )
+		t.Fail( )
+	}
+	if _, err := bleater.Parse_level( "loud" ); err == nil {
+		fmt.Fprintf( os.Stderr, "parse of bad level didn't fail\n" )
+		t.Fail( )
+	}
+
+	buf := &bytes.Buffer{}
+	master := bleater.Mk_bleater( bleater.WARN, buf )
+	master.Set_format( bleater.FMT_TEXT_NAMED )				// level names are only written when asked for
+	ostack := bleater.Mk_bleater( bleater.WARN, os.Stderr )
+	broker := bleater.Mk_bleater( bleater.WARN, os.Stderr )
+	master.Add_named_child( "ostack", ostack )
+	master.Add_named_child( "ssh_broker", broker )
+	bleater.Register( "master", master )
+
+	ostack.Baa( bleater.DEBUG, "should not show" )
+	if err := bleater.Set_levels( "ostack=3, ssh_broker=ERR" ); err != nil {
+		fmt.Fprintf( os.Stderr, "set levels failed: %s\n", err )
+		t.Fail( )
+	}
+	ostack.Baa( bleater.DEBUG, "debug from ostack" )
+	broker.Baa( bleater.INFO, "should not show" )
+	if ! strings.Contains( buf.String(), "ostack [DEBUG] debug from ostack" ) || strings.Contains( buf.String(), "should not" ) {
+		fmt.Fprintf( os.Stderr, "unexpected output after set levels: %s", buf.String() )
+		t.Fail( )
+	}
+
+	buf.Reset()
+	bleater.Set_levels( "master=info" )					// pushed to children as the parent level
+	broker.Baa( bleater.INFO, "info from broker" )
+	if ! strings.Contains( buf.String(), "[INFO] info from broker" ) {
+		fmt.Fprintf( os.Stderr, "master level not pushed to child: %s", buf.String() )
+		t.Fail( )
+	}
+
+	if err := bleater.Set_levels( "ostack=2,nosuch=1,ssh_broker=loud" ); err == nil {
+		fmt.Fprintf( os.Stderr, "set levels with bad pairs didn't fail\n" )
+		t.Fail( )
+	} else {
+		fmt.Fprintf( os.Stderr, "expected error: %s\n", err )
+	}
+	if ostack.Get_level() != bleater.INFO {
+		fmt.Fprintf( os.Stderr, "good pair wasn't applied when others were bad: %d\n", ostack.Get_level() )
+		t.Fail( )
+	}
+
+	if names := bleater.Registered(); strings.Join( names, "," ) != "master,ostack,ssh_broker" {
+		fmt.Fprintf( os.Stderr, "unexpected registered names: %v\n", names )
+		t.Fail( )
+	}
+	for _, name := range bleater.Registered() {
+		bleater.Unregister( name )
+	}
+}
+
+/*
+	Rolling the log on size with compression and retention of the rolled files.
+*/
+func TestHerder( t *testing.T ) {
+	ldir, err := os.MkdirTemp( "", "bleater_herd" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( ldir )
+
+	sheep := bleater.Mk_bleater( 1, os.Stderr )
+	sheep.Set_prefix( "herd" )
+	kid := bleater.Mk_bleater( 0, os.Stderr )
+	sheep.Add_child( kid )
+	if err = sheep.Append_target( ldir + "/herd.log.start", true ); err != nil {
+		t.Fatal( err )
+	}
+
+	sheep.Set_roll_size( 512 )
+	sheep.Set_roll_compress( true )
+	sheep.Set_roll_retention( 3, 1 )
+	go sheep.Sheep_herder( &ldir, 86400 )
+
+	for i := 0; i < 100; i++ {
+		kid.Baa( 1, "child message %d should be counted toward the size of the log", i )
+		time.Sleep( 2 * time.Millisecond )
+	}
+
+	var gz []string
+	var plain []string
+	for i := 0; i < 100; i++ {						// compression and pruning are in the background, give them a bit
+		time.Sleep( 20 * time.Millisecond )
+		gz, _ = filepath.Glob( ldir + "/herd.log.*.gz" )
+		all, _ := filepath.Glob( ldir + "/herd.log.*" )
+		plain = plain[:0]
+		for _, name := range all {
+			if ! strings.HasSuffix( name, ".gz" ) {
+				plain = append( plain, name )
+			}
+		}
+		if len( gz ) == 3 && len( plain ) == 1 {
+			break
+		}
+	}
+
+	fmt.Fprintf( os.Stderr, "herd: compressed=%v current=%v\n", gz, plain )
+	if len( gz ) != 3 || len( plain ) != 1 {
+		fmt.Fprintf( os.Stderr, "expected 3 compressed files and the current log\n" )
+		t.Fail( )
+	}
+
+	for _, name := range gz {
+		f, err := os.Open( name )
+		if err != nil {
+			t.Fatal( err )
+		}
+		zr, err := gzip.NewReader( f )
+		if err == nil {
+			var data []byte
+			data, err = io.ReadAll( zr )
+			if err == nil && ! strings.Contains( string( data ), "child message" ) {
+				err = fmt.Errorf( "no child messages in the rolled file" )
+			}
+		}
+		f.Close()
+		if err != nil {
+			fmt.Fprintf( os.Stderr, "bad rolled file %s: %s\n", name, err )
+			t.Fail( )
+		}
+	}
+}
+
+/*
+	A target which is slow to write, and safe to read while being written.
+*/
+type slow_target struct {
+	mtx		sync.Mutex
+	buf		bytes.Buffer
+}
+
+func ( st *slow_target ) Write( p []byte ) ( int, error ) {
+	time.Sleep( 5 * time.Millisecond )
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return st.buf.Write( p )
+}
+
+func ( st *slow_target ) String( ) ( string ) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return st.buf.String()
+}
+
+/*
+	Async mode with each of the overflow policies.
+*/
+func TestAsync( t *testing.T ) {
+	for _, policy := range []int{ bleater.ASYNC_BLOCK, bleater.ASYNC_DROP_NEWEST, bleater.ASYNC_DROP_OLDEST } {
+		st := &slow_target{}
+		sheep := bleater.Mk_bleater( 1, st )
+		kid := bleater.Mk_bleater( 0, os.Stderr )
+		sheep.Add_child( kid )
+		if err := sheep.Set_async( 4, policy, 20 * time.Millisecond ); err != nil {
+			t.Fatal( err )
+		}
+		if err := sheep.Set_async( 4, policy, 0 ); err == nil {
+			fmt.Fprintf( os.Stderr, "second Set_async didn't fail\n" )
+			t.Fail( )
+		}
+
+		start := time.Now()
+		for i := 0; i < 20; i++ {
+			kid.Baa( 1, "message %02d", i )
+		}
+		elapsed := time.Since( start )
+		sheep.Flush( )
+		time.Sleep( 50 * time.Millisecond )			// let the drop report be written
+		sheep.Baa( 1, "after flush" )
+		sheep.Close( )
+
+		out := st.String()
+		n := strings.Count( out, "message " )
+		dropped := sheep.Async_dropped()
+		fmt.Fprintf( os.Stderr, "policy %d: %d written, %d dropped, bleating took %s\n", policy, n, dropped, elapsed )
+
+		switch policy {
+			case bleater.ASYNC_BLOCK:
+				if n != 20 || dropped != 0 {
+					fmt.Fprintf( os.Stderr, "block policy lost messages: %s", out )
+					t.Fail( )
+				}
+
+			case bleater.ASYNC_DROP_NEWEST:
+				if ! strings.Contains( out, "message 00" ) || strings.Contains( out, "message 19" ) || dropped == 0 {
+					fmt.Fprintf( os.Stderr, "drop newest didn't drop the newest: %s", out )
+					t.Fail( )
+				}
+
+			case bleater.ASYNC_DROP_OLDEST:
+				if ! strings.Contains( out, "message 19" ) || dropped == 0 {
+					fmt.Fprintf( os.Stderr, "drop oldest didn't keep the newest: %s", out )
+					t.Fail( )
+				}
+		}
+
+		if policy != bleater.ASYNC_BLOCK {
+			if uint64( n ) + dropped != 20 || ! strings.Contains( out, fmt.Sprintf( "%d messages dropped", dropped ) ) {
+				fmt.Fprintf( os.Stderr, "drop count not reported or doesn't add up: %s", out )
+				t.Fail( )
+			}
+			if elapsed > 50 * time.Millisecond {
+				fmt.Fprintf( os.Stderr, "bleating was held up by the slow target\n" )
+				t.Fail( )
+			}
+		}
+		if ! strings.HasSuffix( out, "after flush\n" ) {
+			fmt.Fprintf( os.Stderr, "message after flush not last: %s", out )
+			t.Fail( )
+		}
+	}
+}
+
+/*
+	Syslog over udp and tcp, and the journal protocol, using local listeners in place
+	of the servers.
+*/
+func TestSyslog( t *testing.T ) {
+	uconn, err := net.ListenPacket( "udp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer uconn.Close()
+
+	st, err := bleater.Mk_syslog_target( "udp", uconn.LocalAddr().String(), "bleat_test", bleater.SYSLOG_DAEMON )
+	if err != nil {
+		t.Fatal( err )
+	}
+	sheep := bleater.Mk_bleater( bleater.INFO, os.Stderr )
+	kid := bleater.Mk_bleater( bleater.ERR, os.Stderr )
+	kid.Set_prefix( "ostack" )
+	sheep.Add_child( kid )
+	sheep.Set_target( st, false )
+
+	kid.Baa_kv( bleater.WARN, "gate open", "pen", 3, "note", `has "quotes" ]` )
+	buf := make( []byte, 2048 )
+	uconn.SetReadDeadline( time.Now().Add( 2 * time.Second ) )
+	n, _, err := uconn.ReadFrom( buf )
+	if err != nil {
+		t.Fatal( err )
+	}
+	msg := string( buf[:n] )
+	fmt.Fprintf( os.Stderr, "udp syslog: %s\n", msg )
+	if ! strings.HasPrefix( msg, "<28>1 " ) || ! strings.Contains( msg, fmt.Sprintf( " bleat_test %d ostack [fields@32473 pen=\"3\" note=\"has \\\"quotes\\\" \\]\"] gate open", os.Getpid() ) ) {
+		fmt.Fprintf( os.Stderr, "udp syslog message not right\n" )
+		t.Fail( )
+	}
+	st.Close()
+
+	lsn, err := net.Listen( "tcp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer lsn.Close()
+	st, err = bleater.Mk_syslog_target( "tcp", lsn.Addr().String(), "bleat_test", bleater.SYSLOG_LOCAL0 + 2 )
+	if err != nil {
+		t.Fatal( err )
+	}
+	sheep.Set_target( st, false )
+	sheep.Baa( bleater.ERR, "first" )
+	sheep.Baa( bleater.INFO, "second" )
+
+	conn, err := lsn.Accept()
+	if err != nil {
+		t.Fatal( err )
+	}
+	conn.SetReadDeadline( time.Now().Add( 2 * time.Second ) )
+	br := bufio.NewReader( conn )
+	for _, expect := range [][]string{ { "<147>1 ", " - - first" }, { "<150>1 ", " - - second" } } {
+		var mlen int
+		if _, err := fmt.Fscanf( br, "%d ", &mlen ); err != nil {
+			t.Fatal( err )
+		}
+		mbuf := make( []byte, mlen )
+		if _, err := io.ReadFull( br, mbuf ); err != nil {
+			t.Fatal( err )
+		}
+		fmt.Fprintf( os.Stderr, "tcp syslog: %s\n", mbuf )
+		if ! strings.HasPrefix( string( mbuf ), expect[0] ) || ! strings.HasSuffix( string( mbuf ), expect[1] ) {
+			fmt.Fprintf( os.Stderr, "tcp syslog message not right; expected %s ... %s\n", expect[0], expect[1] )
+			t.Fail( )
+		}
+	}
+	conn.Close()
+	st.Close()
+
+	jdir, err := os.MkdirTemp( "", "bleater_journal" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( jdir )
+	jconn, err := net.ListenUnixgram( "unixgram", &net.UnixAddr{ Name: jdir + "/socket", Net: "unixgram" } )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer jconn.Close()
+
+	jt, err := bleater.Mk_journal_target( jdir + "/socket", "bleat_test" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	sheep.Set_target( jt, false )
+	kid.Baa_kv( bleater.ERR, "two\nlines", "_pen", 3 )
+
+	jconn.SetReadDeadline( time.Now().Add( 2 * time.Second ) )
+	n, err = jconn.Read( buf )
+	if err != nil {
+		t.Fatal( err )
+	}
+	expect := "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\nPRIORITY=3\nSYSLOG_IDENTIFIER=bleat_test\nBLEATER_PREFIX=ostack\nBLEATER_LEVEL=ERR\nPEN=3\n"
+	if string( buf[:n] ) != expect {
+		fmt.Fprintf( os.Stderr, "journal datagram not right: %q\n", buf[:n] )
+		t.Fail( )
+	}
+	jt.Close()
+}
+
+/*
+	Caller information and stack traces.
+*/
+func TestCaller( t *testing.T ) {
+	buf := &bytes.Buffer{}
+	sheep := bleater.Mk_bleater( bleater.DEBUG, buf )
+
+	sheep.Baa( bleater.ERR, "no caller info" )
+	if strings.Contains( buf.String(), "caller=" ) || strings.Contains( buf.String(), "goroutine" ) {
+		fmt.Fprintf( os.Stderr, "caller info added when not asked for: %s", buf.String() )
+		t.Fail( )
+	}
+
+	buf.Reset()
+	sheep.Set_caller( true )
+	sheep.Set_stack_trace( true, bleater.ERR )
+	sheep.Baa( bleater.INFO, "with caller" )
+	sheep.Baa_some( "class", 5, bleater.INFO, "with caller from baa some" )
+	sheep.Baa_kv( bleater.ERR, "with stack" )
+
+	out := buf.String()
+	fmt.Fprintf( os.Stderr, "%s", out )
+	lines := strings.Split( out, "\n" )
+	for i := 0; i < 3; i++ {
+		if ! strings.Contains( lines[i], " caller=bleater_test.go:" ) || ! strings.Contains( lines[i], " func=bleater_test.TestCaller goroutine=" ) {
+			fmt.Fprintf( os.Stderr, "caller info not right: %s\n", lines[i] )
+			t.Fail( )
+		}
+	}
+	if strings.Contains( lines[3], "goroutine " ) && strings.Contains( lines[4], "bleater_test.TestCaller(" ) {
+		if strings.Contains( out, "bleater.(*Bleater)" ) {
+			fmt.Fprintf( os.Stderr, "stack includes bleater frames\n" )
+			t.Fail( )
+		}
+	} else {
+		fmt.Fprintf( os.Stderr, "stack trace not after the error message\n" )
+		t.Fail( )
+	}
+	if strings.Count( out, "[running]" ) != 1 {
+		fmt.Fprintf( os.Stderr, "expected exactly one stack trace\n" )
+		t.Fail( )
+	}
+
+	buf.Reset()
+	sheep.Set_format( bleater.FMT_JSON )
+	sheep.Baa( bleater.ERR, "json with stack" )
+	rec := struct {
+		Fields	map[string]interface{}
+	} {}
+	if err := json.Unmarshal( buf.Bytes(), &rec ); err != nil || ! strings.Contains( fmt.Sprint( rec.Fields["stack"] ), "TestCaller" ) {
+		fmt.Fprintf( os.Stderr, "json stack not right: %v: %s", err, buf.String() )
+		t.Fail( )
+	}
+}
+
+/*
+	A buffer which can be written by a go routine (e.g. a timer) while being read.
+*/
+type safe_buffer struct {
+	mtx		sync.Mutex
+	buf		bytes.Buffer
+}
+
+func ( sb *safe_buffer ) Write( p []byte ) ( int, error ) {
+	sb.mtx.Lock()
+	defer sb.mtx.Unlock()
+	return sb.buf.Write( p )
+}
+
+func ( sb *safe_buffer ) String( ) ( string ) {
+	sb.mtx.Lock()
+	defer sb.mtx.Unlock()
+	return sb.buf.String()
+}
+
+func ( sb *safe_buffer ) Reset( ) {
+	sb.mtx.Lock()
+	defer sb.mtx.Unlock()
+	sb.buf.Reset()
+}
+
+/*
+	Rate limiting by class and collapsing of repeated messages.
+*/
+func TestLimits( t *testing.T ) {
+	buf := &safe_buffer{}
+	sheep := bleater.Mk_bleater( bleater.INFO, buf )
+	sheep.Set_rate_limit( "noisy", 3, 100 * time.Millisecond )
+	sheep.Set_rate_limit( "", 5, time.Hour )
+
+	for i := 0; i < 10; i++ {
+		sheep.Baa_limit( "noisy", bleater.INFO, "noisy %d", i )
+		sheep.Baa_limit( "other", bleater.INFO, "other %d", i )
+		sheep.Baa_limit( "quiet", bleater.DEBUG, "not bleated so not counted %d", i )
+	}
+	time.Sleep( 120 * time.Millisecond )						// bucket refills
+	sheep.Baa_limit( "noisy", bleater.INFO, "noisy after wait" )
+
+	out := buf.String()
+	counts := sheep.Rate_suppressed()
+	fmt.Fprintf( os.Stderr, "%ssuppressed: %v\n", out, counts )
+	if strings.Count( out, "noisy " ) != 4 || strings.Count( out, "other " ) != 5 || counts["noisy"] != 7 || counts["other"] != 5 || counts["quiet"] != 0 {
+		fmt.Fprintf( os.Stderr, "rate limits not right\n" )
+		t.Fail( )
+	}
+	if ! strings.Contains( out, "noisy after wait suppressed=7" ) {
+		fmt.Fprintf( os.Stderr, "suppressed count not added to the next message\n" )
+		t.Fail( )
+	}
+
+	buf.Reset()
+	sheep.Set_rate_limit( "", 2, 50 * time.Millisecond )		// classes using the default must follow a change
+	time.Sleep( 60 * time.Millisecond )
+	for i := 0; i < 10; i++ {
+		sheep.Baa_limit( "other", bleater.INFO, "changed default %d", i )
+	}
+	sheep.Set_rate_limit( "", 0, 0 )							// and be unlimited once it is removed
+	for i := 0; i < 10; i++ {
+		sheep.Baa_limit( "other", bleater.INFO, "no default %d", i )
+		sheep.Baa_limit( "noisy", bleater.INFO, "still limited %d", i )
+	}
+	out = buf.String()
+	if strings.Count( out, "changed default " ) != 2 || strings.Count( out, "no default " ) != 10 || strings.Count( out, "still limited " ) != 3 {
+		fmt.Fprintf( os.Stderr, "classes using the default limit did not follow its change and removal:\n%s", out )
+		t.Fail( )
+	}
+
+	buf.Reset()
+	kid := bleater.Mk_bleater( bleater.INFO, os.Stderr )	// dedup set only on the child
+	sheep.Add_child( kid )
+	kid.Set_dedup( 100 * time.Millisecond )
+	for i := 0; i < 5; i++ {
+		kid.Baa( bleater.INFO, "same thing" )
+		sheep.Baa( bleater.INFO, "parent not collapsed" )
+	}
+	kid.Baa( bleater.INFO, "different thing" )
+	for i := 0; i < 3; i++ {
+		kid.Baa( bleater.INFO, "different thing" )
+	}
+	time.Sleep( 200 * time.Millisecond )						// window ends, repeat message written
+
+	out = buf.String()
+	fmt.Fprintf( os.Stderr, "%s", out )
+	if strings.Count( out, "same thing" ) != 1 || strings.Count( out, "parent not collapsed" ) != 5 ||
+		! strings.Contains( out, "last message repeated 4 times" ) || ! strings.Contains( out, "last message repeated 3 times" ) {
+		fmt.Fprintf( os.Stderr, "dedup not right\n" )
+		t.Fail( )
+	}
+	if n := kid.Dedup_suppressed(); n != 7 {
+		fmt.Fprintf( os.Stderr, "dedup suppressed count wrong: %d\n", n )
+		t.Fail( )
+	}
+}
+
+/*
+	Capture ring with the http handlers for it and for levels.
+*/
+func TestRing( t *testing.T ) {
+	buf := &bytes.Buffer{}
+	sheep := bleater.Mk_bleater( bleater.WARN, buf )
+	kid := bleater.Mk_bleater( bleater.ERR, os.Stderr )
+	kid.Set_prefix( "ostack" )
+	sheep.Add_named_child( "ostack", kid )
+	defer bleater.Unregister( "ostack" )
+
+	ring := bleater.Mk_ring( 4 )
+	sheep.Set_capture( ring, bleater.DEBUG )
+
+	sheep.Baa( bleater.TRACE, "trace not captured" )
+	for i := 0; i < 3; i++ {
+		sheep.Baa( bleater.INFO, "info %d captured only", i )
+	}
+	kid.Baa( bleater.DEBUG, "debug from kid" )
+	kid.Baa( bleater.WARN, "warning from kid" )
+
+	if strings.Contains( buf.String(), "captured only" ) || strings.Count( buf.String(), "\n" ) != 1 {
+		fmt.Fprintf( os.Stderr, "captured messages written to the target: %s", buf.String() )
+		t.Fail( )
+	}
+
+	get := func( h http.Handler, method string, url string ) ( int, string ) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP( w, httptest.NewRequest( method, url, nil ) )
+		return w.Code, w.Body.String()
+	}
+
+	code, body := get( ring, "GET", "/bleats" )				// ring holds 4; oldest info message pushed out
+	fmt.Fprintf( os.Stderr, "ring:\n%s", body )
+	if code != 200 || strings.Count( body, "\n" ) != 4 || strings.Contains( body, "info 0" ) || ! strings.Contains( body, "warning from kid" ) {
+		fmt.Fprintf( os.Stderr, "ring contents not right\n" )
+		t.Fail( )
+	}
+
+	for _, tc := range []struct {
+		url		string
+		count	int
+		has		string
+	} {
+		{ "/bleats?prefix=ostack", 2, "debug from kid" },
+		{ "/bleats?level=info", 3, "info 2" },
+		{ "/bleats?match=kid&level=warn", 1, "warning from kid" },
+		{ "/bleats?n=1", 1, "warning from kid" },
+		{ "/bleats?prefix=", 2, "info 1" },
+	} {
+		code, body = get( ring, "GET", tc.url )
+		if code != 200 || strings.Count( body, "\n" ) != tc.count || ! strings.Contains( body, tc.has ) {
+			fmt.Fprintf( os.Stderr, "filter %s not right: %d %s\n", tc.url, code, body )
+			t.Fail( )
+		}
+	}
+	if code, _ = get( ring, "GET", "/bleats?level=loud" ); code != http.StatusBadRequest {
+		fmt.Fprintf( os.Stderr, "bad level not rejected: %d\n", code )
+		t.Fail( )
+	}
+
+	lh := bleater.Level_handler()
+	code, body = get( lh, "POST", "/levels?levels=ostack=debug" )
+	if code != 200 || ! strings.Contains( body, "ostack=DEBUG\n" ) || kid.Get_level() != bleater.DEBUG {
+		fmt.Fprintf( os.Stderr, "setting level over http failed: %d %s\n", code, body )
+		t.Fail( )
+	}
+	kid.Baa( bleater.DEBUG, "debug now written" )
+	if ! strings.Contains( buf.String(), "debug now written" ) {
+		fmt.Fprintf( os.Stderr, "level change didn't take effect\n" )
+		t.Fail( )
+	}
+	if code, body = get( lh, "POST", "/levels?levels=nosuch=1" ); code != http.StatusBadRequest {
+		fmt.Fprintf( os.Stderr, "unknown bleater not rejected: %d %s\n", code, body )
+		t.Fail( )
+	}
+	if code, _ = get( lh, "DELETE", "/levels" ); code != http.StatusMethodNotAllowed {
+		fmt.Fprintf( os.Stderr, "delete not rejected: %d\n", code )
+		t.Fail( )
+	}
+
+	sheep.Set_capture( nil, 0 )
+	kid.Baa( bleater.TRACE, "not captured after capture stopped" )
+	if _, body = get( ring, "GET", "/bleats?match=stopped" ); body != "" {
+		fmt.Fprintf( os.Stderr, "captured after capture stopped: %s\n", body )
+		t.Fail( )
+	}
+
+	buf.Reset()														// limits, dedup and caller info apply only to the target
+	ring = bleater.Mk_ring( 50 )
+	sheep.Set_capture( ring, bleater.DEBUG )
+	sheep.Set_rate_limit( "", 1, time.Hour )
+	sheep.Set_dedup( time.Hour )
+	sheep.Set_caller( true )
+	sheep.Baa_some( "some", 3, bleater.WARN, "some to target" )
+	for i := 0; i < 5; i++ {
+		sheep.Baa( bleater.WARN, "repeated warning" )
+		sheep.Baa_limit( "limited", bleater.INFO, "limited but captured %d", i )
+		sheep.Baa_some( "some", 3, bleater.INFO, "some captured %d", i )
+	}
+	sheep.Baa_some( "some", 3, bleater.WARN, "some to target" )
+	counts := sheep.Rate_suppressed()
+	sheep.Set_dedup( 0 )
+	_, body = get( ring, "GET", "/bleats" )
+	fmt.Fprintf( os.Stderr, "ring:\n%s", body )
+	if strings.Count( body, "limited but captured" ) != 5 || strings.Count( body, "some captured" ) != 5 || counts["limited"] != 0 ||
+		strings.Count( buf.String(), "some to target" ) != 1 || strings.Count( buf.String(), "repeated warning" ) != 1 ||
+		! strings.Contains( buf.String(), "last message repeated 4 times" ) || strings.Contains( body, "captured 0 caller=" ) {
+		fmt.Fprintf( os.Stderr, "captured only messages affected limits, dedup or caller info: %v\n%s", counts, buf.String() )
+		t.Fail( )
+	}
+	sheep.Set_capture( nil, 0 )
+}
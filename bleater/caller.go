@@ -0,0 +1,156 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	caller
+	Abstract:	Optional information about where a bleat came from.  When turned on
+				with Set_caller() the fields caller (file:line), func and goroutine
+				are added to each message.  Set_stack_trace() adds the stack of the
+				bleating goroutine, as the field stack, to messages at or below a
+				level.  In the text format the stack is written on the lines after
+				the message, each indented with a tab.
+
+				Neither costs anything beyond a flag check when turned off.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package bleater
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	max_stack	int = 1024 * 1024			// largest stack trace captured
+)
+
+/*
+	A stack trace value; the text format writes it after the message rather than as k=v.
+*/
+type stack_trace string
+
+/*
+	Return the current goroutine's stack. If all is false, only the header line with the
+	goroutine id is needed and the buffer is kept small.
+*/
+func get_stack( all bool ) ( []byte ) {
+	size := 64
+	if all {
+		size = 4096
+	}
+
+	for {
+		buf := make( []byte, size )
+		n := runtime.Stack( buf, false )
+		if n < size || ! all || size >= max_stack {
+			return buf[:n]
+		}
+		size *= 2
+	}
+}
+
+/*
+	Return the goroutine id from the header line of a stack trace:
+		goroutine 17 [running]:
+*/
+func stack_goid( stack []byte ) ( int ) {
+	hdr := strings.TrimPrefix( string( stack ), "goroutine " )
+	if i := strings.IndexByte( hdr, ' ' ); i > 0 {
+		id, _ := strconv.Atoi( hdr[:i] )
+		return id
+	}
+
+	return 0
+}
+
+/*
+	Remove the first n frames (two lines each) following the header line of the stack.
+*/
+func drop_frames( stack []byte, n int ) ( []byte ) {
+	lines := bytes.SplitAfter( stack, []byte( "\n" ) )
+	if len( lines ) < 1 + n * 2 {
+		return stack
+	}
+
+	return bytes.Join( append( lines[:1:1], lines[1 + n * 2:]... ), nil )
+}
+
+/*
+	Return the caller and stack fields for a message. Skip is the number of frames
+	between the caller of this function and the user's call (1 would be the caller of
+	our caller). Caller must hold the lock.
+*/
+func ( b *Bleater ) caller_fields( skip int, when uint ) ( fields []interface{} ) {
+	want_stack := b.stack && when <= b.stack_level
+	stack := get_stack( want_stack )
+
+	if b.caller {
+		file := "?"
+		fname := "?"
+		pc, path, line, ok := runtime.Caller( skip + 1 )
+		if ok {
+			file = fmt.Sprintf( "%s:%d", filepath.Base( path ), line )
+			if f := runtime.FuncForPC( pc ); f != nil {
+				fname = f.Name()
+				if i := strings.LastIndexByte( fname, '/' ); i >= 0 {		// package path isn't needed
+					fname = fname[i+1:]
+				}
+			}
+		}
+
+		fields = append( fields, "caller", file, "func", fname, "goroutine", stack_goid( stack ) )
+	}
+
+	if want_stack {												// drop our frames: get_stack, this one and those skipped
+		fields = append( fields, "stack", stack_trace( strings.TrimRight( string( drop_frames( stack, skip + 2 ) ), "\n" ) ) )
+	}
+
+	return fields
+}
+
+// --------------- public -------------------------------------------------------------------------------------
+
+/*
+	Set_caller turns on (or off) adding the caller's file:line, function name and
+	goroutine id to each message written by the bleater.
+*/
+func ( b *Bleater ) Set_caller( on bool ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.caller = on
+}
+
+/*
+	Set_stack_trace turns on (or off) adding the stack of the bleating goroutine to
+	messages whose level is at or below level (e.g. ERR to get a stack with errors).
+*/
+func ( b *Bleater ) Set_stack_trace( on bool, level uint ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.stack = on
+	b.stack_level = level
+}
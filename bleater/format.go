@@ -0,0 +1,226 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	format
+	Abstract:	Formats a bleat message, with any key/value fields, in one of the
+				supported output formats: the original text format (optionally with
+				the level name rather than number), logfmt, or one JSON object per
+				line.  Also defines the record given to targets
+				which want the parts of the message rather than just the line.
+	Date:		18 October 2026
+	Author:		agent
+
+	Mods:		18 Oct 2026 - A field whose Error() or String() panics is formatted
+					as fmt would rather than panicking.
+*/
+
+package bleater
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FMT_TEXT	int = iota		// <unix> <timestamp> <prefix> [<level>] message k=v...
+	FMT_LOGFMT					// ts=... level=... prefix=... msg="..." k=v...
+	FMT_JSON					// {"ts":...,"level":...,"prefix":...,"msg":...,"fields":{...}}
+	FMT_TEXT_NAMED				// as FMT_TEXT with the level name: [<level-name>]
+)
+
+const (
+	ts_machine	string = "2006-01-02T15:04:05.000Z07:00"		// timestamp format for logfmt and json
+)
+
+/*
+	A message as given to a Record_target.
+*/
+type Record struct {
+	Time	time.Time
+	Level	uint
+	Prefix	string				// prefix of the bleater which bleated it
+	Msg		string
+	Fields	[]interface{}		// alternating keys (strings) and values; bound fields first
+	Line	[]byte				// the message formatted for the bleater's format, newline terminated
+
+	plain	bool				// only Line is known (given to Write() rather than Write_record())
+}
+
+/*
+	A target which needs more than the formatted line (e.g. the level to map to a syslog
+	severity) implements this, and the bleater calls Write_record() instead of Write().
+	Write() is still used for anything written by other means.
+*/
+type Record_target interface {
+	io.Writer
+	Write_record( r *Record ) ( error )
+}
+
+/*
+	Convert a list of alternating keys and values into a new list of key/value pairs. A
+	key which isn't a string is converted with fmt; a key without a value is given the
+	value !MISSING so that the mistake is visible in the log.
+*/
+func kv_pairs( kv []interface{} ) ( pairs []interface{} ) {
+	pairs = make( []interface{}, len( kv ), len( kv ) + 1 )
+	copy( pairs, kv )
+	if len( pairs ) % 2 != 0 {
+		pairs = append( pairs, "!MISSING" )
+	}
+
+	for i := 0; i < len( pairs ); i += 2 {
+		if _, ok := pairs[i].( string ); ! ok {
+			pairs[i] = fmt.Sprint( pairs[i] )
+		}
+	}
+
+	return pairs
+}
+
+/*
+	Return the text from the Error() or String() method of the value; ok is false if
+	it has neither.  If the method panics (e.g. a nil pointer in an error interface)
+	the value is formatted as fmt does it, which copes with the panic.
+*/
+func method_string( v interface{} ) ( s string, ok bool ) {
+	defer func( ) {
+		if recover( ) != nil {
+			s, ok = fmt.Sprint( v ), true
+		}
+	}( )
+
+	switch tv := v.( type ) {
+		case error:
+			return tv.Error(), true
+
+		case fmt.Stringer:
+			return tv.String(), true
+	}
+
+	return "", false
+}
+
+/*
+	Return the value as a string for text and logfmt output.
+*/
+func kv_string( v interface{} ) ( string ) {
+	if s, ok := v.( string ); ok {
+		return s
+	}
+	if s, ok := method_string( v ); ok {
+		return s
+	}
+
+	return fmt.Sprint( v )
+}
+
+/*
+	Quote a logfmt value if it is empty or contains a space, quote or equal sign.
+*/
+func logfmt_quote( s string ) ( string ) {
+	if s == "" || strings.ContainsAny( s, " \t\r\n\"=" ) {
+		return strconv.Quote( s )
+	}
+
+	return s
+}
+
+/*
+	Return the value encoded as json. Errors and Stringers are written as strings, and
+	a value that can't be encoded is written as the string fmt produces for it.
+*/
+func json_value( v interface{} ) ( []byte ) {
+	if s, ok := method_string( v ); ok {
+		v = s
+	}
+
+	jv, err := json.Marshal( v )
+	if err != nil {
+		jv, _ = json.Marshal( fmt.Sprint( v ) )
+	}
+
+	return jv
+}
+
+/*
+	Build the complete line (newline terminated) for a message. Fields is a list of
+	alternating keys and values already made sane by kv_pairs().
+*/
+func format_line( format int, now time.Time, tsfmt string, pfx string, when uint, msg string, fields []interface{} ) ( []byte ) {
+	sb := &strings.Builder{}
+
+	switch format {
+		case FMT_LOGFMT:
+			fmt.Fprintf( sb, "ts=%s level=%s", now.UTC().Format( ts_machine ), Level_name( when ) )
+			if pfx != "" {
+				fmt.Fprintf( sb, " prefix=%s", logfmt_quote( pfx ) )
+			}
+			fmt.Fprintf( sb, " msg=%s", logfmt_quote( msg ) )
+			for i := 0; i < len( fields ); i += 2 {
+				fmt.Fprintf( sb, " %s=%s", logfmt_quote( fields[i].( string ) ), logfmt_quote( kv_string( fields[i+1] ) ) )
+			}
+
+		case FMT_JSON:
+			fmt.Fprintf( sb, `{"ts":%s,"level":%s,"prefix":%s,"msg":%s`, json_value( now.UTC().Format( ts_machine ) ), json_value( Level_name( when ) ), json_value( pfx ), json_value( msg ) )
+			if len( fields ) > 0 {
+				sb.WriteString( `,"fields":{` )
+				last := make( map[string]int, len( fields ) / 2 )		// last one wins if a key is repeated (e.g. a call overriding a bound field)
+				for i := 0; i < len( fields ); i += 2 {
+					last[fields[i].( string )] = i
+				}
+				sep := ""
+				for i := 0; i < len( fields ); i += 2 {
+					k := fields[i].( string )
+					if last[k] != i {
+						continue
+					}
+					fmt.Fprintf( sb, "%s%s:%s", sep, json_value( k ), json_value( fields[i+1] ) )
+					sep = ","
+				}
+				sb.WriteString( "}" )
+			}
+			sb.WriteString( "}" )
+
+		default:
+			level := strconv.FormatUint( uint64( when ), 10 )
+			if format == FMT_TEXT_NAMED {
+				level = Level_name( when )
+			}
+			fmt.Fprintf( sb, "%d %s %10s [%s] %s", now.Unix(), now.UTC().Format( tsfmt ), pfx, level, msg )
+			stack := ""
+			for i := 0; i < len( fields ); i += 2 {
+				if st, ok := fields[i+1].( stack_trace ); ok {				// written on the following lines
+					stack = "\n\t" + strings.Replace( string( st ), "\n", "\n\t", -1 )
+					continue
+				}
+				fmt.Fprintf( sb, " %s=%s", fields[i].( string ), logfmt_quote( kv_string( fields[i+1] ) ) )
+			}
+			sb.WriteString( stack )
+	}
+
+	sb.WriteString( "\n" )
+	return []byte( sb.String() )
+}
@@ -0,0 +1,223 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	levels
+	Abstract:	Named levels and a registry of named bleaters.
+
+				The names map onto the existing uint scale so that they can be mixed
+				with bare numbers:
+					0 ERR, 1 WARN, 2 INFO, 3 DEBUG, 4 TRACE
+				Levels beyond TRACE are shown as TRACE+n.
+				The text format writes the level number, as it always has, unless
+				FMT_TEXT_NAMED is selected; logfmt and json write the name.
+
+				Bleaters (usually the children of the application's main bleater,
+				one per subsystem) are registered by name so that their levels can
+				be set at run time with a string such as "ostack=3,ssh_broker=DEBUG".
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package bleater
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ERR		uint = iota		// always written (level can't be lower)
+	WARN
+	INFO
+	DEBUG
+	TRACE
+)
+
+var (
+	level_names = []string{ "ERR", "WARN", "INFO", "DEBUG", "TRACE" }
+
+	reg_mtx		sync.Mutex
+	registry	= make( map[string]*Bleater )
+)
+
+/*
+	Level_name returns the name for the level (e.g. DEBUG for 3). Levels above TRACE
+	are returned as TRACE+n.
+*/
+func Level_name( l uint ) ( string ) {
+	if l < uint( len( level_names ) ) {
+		return level_names[l]
+	}
+
+	return fmt.Sprintf( "TRACE+%d", l - TRACE )
+}
+
+/*
+	Parse_level converts a level name (any case), TRACE+n, or a number to the level.
+*/
+func Parse_level( s string ) ( uint, error ) {
+	s = strings.ToUpper( strings.TrimSpace( s ) )
+
+	for i, name := range level_names {
+		if s == name {
+			return uint( i ), nil
+		}
+	}
+
+	if strings.HasPrefix( s, "TRACE+" ) {
+		n, err := strconv.ParseUint( s[6:], 10, 32 )
+		if err != nil {
+			return 0, fmt.Errorf( "bad level: %s", s )
+		}
+		return TRACE + uint( n ), nil
+	}
+
+	n, err := strconv.ParseUint( s, 10, 32 )
+	if err != nil {
+		return 0, fmt.Errorf( "bad level: %s", s )
+	}
+	return uint( n ), nil
+}
+
+/*
+	Register adds the bleater to the registry with the name so that its level can be
+	set with Set_levels(). A bleater already registered with the name is replaced.
+*/
+func Register( name string, b *Bleater ) {
+	reg_mtx.Lock()
+	defer reg_mtx.Unlock()
+
+	registry[name] = b
+}
+
+/*
+	Unregister removes the name from the registry.
+*/
+func Unregister( name string ) {
+	reg_mtx.Lock()
+	defer reg_mtx.Unlock()
+
+	delete( registry, name )
+}
+
+/*
+	Lookup returns the bleater registered with the name, or nil.
+*/
+func Lookup( name string ) ( *Bleater ) {
+	reg_mtx.Lock()
+	defer reg_mtx.Unlock()
+
+	return registry[name]
+}
+
+/*
+	Registered returns the registered names in order.
+*/
+func Registered( ) ( names []string ) {
+	reg_mtx.Lock()
+	defer reg_mtx.Unlock()
+
+	names = make( []string, 0, len( registry ) )
+	for name := range registry {
+		names = append( names, name )
+	}
+	sort.Strings( names )
+
+	return names
+}
+
+/*
+	Set_levels sets the levels of registered bleaters from a comma separated list of
+	name=level pairs where level is a number or name (see Parse_level()):
+		ostack=3,ssh_broker=WARN
+
+	Each level is set with Set_level() so it is pushed to the bleater's children as
+	usual.  Every pair that can be is applied; if any name isn't registered or any
+	level can't be parsed an error listing them is returned.
+*/
+func Set_levels( spec string ) ( error ) {
+	bad := make( []string, 0 )
+
+	for _, pair := range strings.Split( spec, "," ) {
+		pair = strings.TrimSpace( pair )
+		if pair == "" {
+			continue
+		}
+
+		tokens := strings.SplitN( pair, "=", 2 )
+		if len( tokens ) != 2 {
+			bad = append( bad, pair + ": missing level" )
+			continue
+		}
+
+		l, err := Parse_level( tokens[1] )
+		if err != nil {
+			bad = append( bad, pair + ": " + err.Error() )
+			continue
+		}
+
+		b := Lookup( strings.TrimSpace( tokens[0] ) )
+		if b == nil {
+			bad = append( bad, pair + ": no bleater with that name" )
+			continue
+		}
+
+		b.Set_level( l )
+	}
+
+	if len( bad ) > 0 {
+		return fmt.Errorf( "unable to set levels: %s", strings.Join( bad, "; " ) )
+	}
+
+	return nil
+}
+
+/*
+	Add_named_child adds the bleater as a child (see Add_child()) and registers it with
+	the name. If the child has no prefix the name is used.
+*/
+func ( b *Bleater ) Add_named_child( name string, cb *Bleater ) {
+	cb.mtx.Lock()
+	if cb.pfx == "" {
+		cb.pfx = name
+	}
+	cb.mtx.Unlock()
+
+	b.Add_child( cb )
+	Register( name, cb )
+}
+
+/*
+	Set_level_name sets the level from a name or number (see Parse_level()).
+*/
+func ( b *Bleater ) Set_level_name( name string ) ( error ) {
+	l, err := Parse_level( name )
+	if err != nil {
+		return err
+	}
+
+	b.Set_level( l )
+	return nil
+}
@@ -0,0 +1,322 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	limit
+	Abstract:	Rate limiting and de-duplication of bleats; both are set for each
+				bleater (children are not affected by their parent's settings).
+
+				Rate limiting uses a token bucket for each class: Set_rate_limit()
+				allows n messages per interval, with bursts of up to n, and
+				Baa_limit() bleats a message in a class if the bucket isn't empty.
+				When messages have been suppressed, the next one written has the
+				field suppressed=<count> added.  A class without a limit of its own
+				uses the default limit (class ""), with a bucket of its own which
+				follows the default as it is changed or removed.  Baa_limit()
+				replaces the older Baa_some(), which counts calls rather than time.
+
+				De-duplication (Set_dedup()) collapses a message repeated within a
+				window into the first one and a single "last message repeated n
+				times" message, written when a different message is bleated or
+				when the window ends.
+
+				Both apply only to messages written to the target; those which
+				only go to the capture ring (see ring.go) are captured as they are.
+
+				The number of messages suppressed is available with
+				Rate_suppressed() and Dedup_suppressed() for metrics.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package bleater
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	def_class	string = ""				// class whose limit applies to classes without their own
+)
+
+// ---- rate limiting ---------------------------------------------------------------------
+
+/*
+	Token bucket for a class.
+*/
+type bucket struct {
+	n			float64			// capacity and tokens added per interval
+	interval	time.Duration
+	tokens		float64
+	last		time.Time		// when tokens were last added
+	pending		uint64			// suppressed since the last message written
+	total		uint64			// suppressed over all
+}
+
+func mk_bucket( n int, interval time.Duration ) ( *bucket ) {
+	return &bucket{ n: float64( n ), interval: interval, tokens: float64( n ), last: time.Now() }
+}
+
+/*
+	Take a token if there is one. If not, the message is counted as suppressed.
+*/
+func (bk *bucket) take( now time.Time ) ( bool ) {
+	if elapsed := now.Sub( bk.last ); elapsed > 0 {
+		bk.tokens += bk.n * float64( elapsed ) / float64( bk.interval )
+		if bk.tokens > bk.n {
+			bk.tokens = bk.n
+		}
+		bk.last = now
+	}
+
+	if bk.tokens < 1 {
+		bk.pending++
+		bk.total++
+		return false
+	}
+
+	bk.tokens--
+	return true
+}
+
+/*
+	Return the bucket for the class. A class without a limit of its own gets a bucket
+	derived from the default limit; these are kept apart from the explicit ones and
+	take up the current default each time. Nil is returned if the class isn't limited.
+	Caller must hold the lock.
+*/
+func ( b *Bleater ) get_bucket( class string ) ( *bucket ) {
+	if bk, ok := b.limits[class]; ok {
+		return bk
+	}
+
+	dbk, ok := b.limits[def_class]
+	if ! ok {
+		return nil
+	}
+
+	if bk, ok := b.derived[class]; ok {
+		bk.n = dbk.n								// default may have changed since it was made
+		bk.interval = dbk.interval
+		return bk
+	}
+
+	if b.derived == nil {
+		b.derived = make( map[string]*bucket )
+	}
+	bk := mk_bucket( int( dbk.n ), dbk.interval )
+	b.derived[class] = bk
+	return bk
+}
+
+/*
+	Set_rate_limit limits messages bleated with Baa_limit() in the class to n every
+	interval. A class of "" sets the default limit used for classes which don't have
+	one (each class still has its own bucket); changing the default changes it for all
+	of those classes, and removing it leaves them unlimited.  An n of zero or less
+	removes the limit.
+*/
+func ( b *Bleater ) Set_rate_limit( class string, n int, interval time.Duration ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if n <= 0 || interval <= 0 {
+		delete( b.limits, class )
+		if class == def_class {
+			b.derived = nil							// nothing left to derive from
+		}
+		return
+	}
+
+	if b.limits == nil {
+		b.limits = make( map[string]*bucket )
+	}
+	if bk, ok := b.limits[class]; ok {						// keep the counts
+		bk.n = float64( n )
+		bk.interval = interval
+		return
+	}
+	if bk, ok := b.derived[class]; ok && class != def_class {		// class had the default; keep its counts
+		delete( b.derived, class )
+		bk.n = float64( n )
+		bk.interval = interval
+		b.limits[class] = bk
+		return
+	}
+	b.limits[class] = mk_bucket( n, interval )
+}
+
+/*
+	Baa_limit bleats as Baa() does if the rate limit for the class allows. Classes
+	without a limit (and no default limit) are not limited.  A message which only
+	goes to the capture ring is always captured and doesn't use a token.
+*/
+func ( b *Bleater ) Baa_limit( class string, when uint, uformat string, va ...interface{} ) {
+	if b == nil {
+		return
+	}
+	if !( when <= b.level || when <= b.plevel || when <= b.clevel ) {		// wouldn't bleat; don't use a token
+		return
+	}
+
+	var kv []interface{}
+	b.mtx.Lock()
+	if ! b.to_target( when ) {
+		b.mtx.Unlock()
+		b.emit( 1, when, fmt.Sprintf( uformat, va... ), nil )
+		return
+	}
+	if bk := b.get_bucket( class ); bk != nil {
+		if ! bk.take( time.Now() ) {
+			b.mtx.Unlock()
+			return
+		}
+		if bk.pending > 0 {
+			kv = []interface{}{ "suppressed", bk.pending }
+			bk.pending = 0
+		}
+	}
+	b.mtx.Unlock()
+
+	b.emit( 1, when, fmt.Sprintf( uformat, va... ), kv )
+}
+
+/*
+	Rate_suppressed returns, by class, the number of messages suppressed by rate
+	limiting since the limit for the class (or the default limit) was set.
+*/
+func ( b *Bleater ) Rate_suppressed( ) ( counts map[string]uint64 ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	counts = make( map[string]uint64, len( b.limits ) + len( b.derived ) )
+	for class, bk := range b.derived {
+		counts[class] = bk.total
+	}
+	for class, bk := range b.limits {
+		counts[class] = bk.total
+	}
+
+	return counts
+}
+
+// ---- de-duplication --------------------------------------------------------------------
+
+type dedup struct {
+	window	time.Duration
+	key		string				// the last message written
+	level	uint
+	first	time.Time			// when it was written
+	count	int					// times it was repeated since
+	total	uint64				// repeats suppressed over all
+	timer	*time.Timer			// writes the repeated message when the window ends
+}
+
+/*
+	If the message is a repeat of the last one within the window it is counted and true
+	is returned; the caller doesn't write it.  Otherwise any pending repeated message is
+	written and the message becomes the one that others are compared to.  Caller must
+	hold the lock.
+*/
+func (d *dedup) collapse( b *Bleater, now time.Time, when uint, msg string, kv []interface{} ) ( bool ) {
+	key := msg
+	if len( kv ) > 0 {
+		key += "\x00" + fmt.Sprint( kv )
+	}
+
+	if key == d.key && when == d.level && now.Sub( d.first ) < d.window {
+		d.count++
+		d.total++
+		if d.timer == nil {
+			var tm *time.Timer
+			tm = time.AfterFunc( d.window - now.Sub( d.first ), func() {
+				b.mtx.Lock()
+				defer b.mtx.Unlock()
+				if b.dedup == d && d.timer == tm {
+					d.flush( b, time.Now() )
+					d.key = ""
+				}
+			} )
+			d.timer = tm
+		}
+		return true
+	}
+
+	d.flush( b, now )
+	d.key = key
+	d.level = when
+	d.first = now
+	return false
+}
+
+/*
+	Write the repeated message if there were repeats. Caller must hold the lock.
+*/
+func (d *dedup) flush( b *Bleater, now time.Time ) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+
+	if d.count > 0 {
+		b.write( now, d.level, fmt.Sprintf( "last message repeated %d times", d.count ), b.bound_fields( nil ) )
+		d.count = 0
+	}
+}
+
+/*
+	Set_dedup collapses a message which is repeated (same level, message and fields)
+	within the window into one "last message repeated n times" message. A window of
+	zero turns it off, writing any pending repeated message.
+*/
+func ( b *Bleater ) Set_dedup( window time.Duration ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.dedup != nil {
+		b.dedup.flush( b, time.Now() )
+		if window <= 0 {
+			b.dedup = nil
+			return
+		}
+		b.dedup.window = window
+		return
+	}
+
+	if window > 0 {
+		b.dedup = &dedup{ window: window }
+	}
+}
+
+/*
+	Dedup_suppressed returns the number of repeated messages collapsed since
+	de-duplication was turned on.
+*/
+func ( b *Bleater ) Dedup_suppressed( ) ( uint64 ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.dedup == nil {
+		return 0
+	}
+	return b.dedup.total
+}
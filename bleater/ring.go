@@ -0,0 +1,243 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	ring
+	Abstract:	An in memory ring of the most recent messages, and http handlers to
+				look at it and to change levels while the programme runs.
+
+				A ring is given to a bleater with Set_capture() along with a capture
+				level; messages at or below that level are kept in the ring even if
+				the bleater's level means that they aren't written to the target.
+				The ring and level are pushed to children as the level is.  The ring
+				can also be used as an ordinary target.
+
+				The ring is an http.Handler which writes the messages, oldest first,
+				as text.  These query parameters filter what is written:
+					level=<name|n>		only messages at or below the level
+					prefix=<pfx>		only messages from bleaters with the prefix
+					match=<string>		only messages containing the string
+					n=<count>			only the last count messages (after filtering)
+
+				Level_handler() returns a handler which lists the levels of the
+				registered (named) bleaters on GET and sets them on POST or PUT
+				with the form value levels=name=level[,name=level...] (see
+				Set_levels()).
+
+				Example:
+					ring := bleater.Mk_ring( 5000 )
+					sheep.Set_capture( ring, bleater.DEBUG )
+					http.Handle( "/debug/bleats", ring )
+					http.Handle( "/debug/levels", bleater.Level_handler() )
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package bleater
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	A fixed size ring of the most recent messages.
+*/
+type Ring struct {
+	mtx		sync.Mutex
+	recs	[]*Record
+	next	int				// where the next record goes
+	count	int
+}
+
+/*
+	Mk_ring creates a ring which keeps the most recent size messages.
+*/
+func Mk_ring( size int ) ( *Ring ) {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Ring{ recs: make( []*Record, size ) }
+}
+
+/*
+	Write_record adds the bleat to the ring, pushing out the oldest when full.
+*/
+func (r *Ring) Write_record( rec *Record ) ( error ) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.recs[r.next] = rec
+	r.next = (r.next + 1) % len( r.recs )
+	if r.count < len( r.recs ) {
+		r.count++
+	}
+
+	return nil
+}
+
+/*
+	Write adds text written by something other than a bleater; it is kept at the INFO level.
+*/
+func (r *Ring) Write( p []byte ) ( int, error ) {
+	line := make( []byte, len( p ) )
+	copy( line, p )
+	r.Write_record( &Record{ Time: time.Now(), Level: INFO, Msg: strings.TrimRight( string( p ), "\n" ), Line: line, plain: true } )
+
+	return len( p ), nil
+}
+
+/*
+	Records returns the messages in the ring, oldest first.
+*/
+func (r *Ring) Records( ) ( recs []*Record ) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	recs = make( []*Record, 0, r.count )
+	start := (r.next - r.count + len( r.recs )) % len( r.recs )
+	for i := 0; i < r.count; i++ {
+		recs = append( recs, r.recs[(start + i) % len( r.recs )] )
+	}
+
+	return recs
+}
+
+/*
+	ServeHTTP writes the messages in the ring, filtered by the query parameters level,
+	prefix, match and n, as plain text.
+*/
+func (r *Ring) ServeHTTP( w http.ResponseWriter, req *http.Request ) {
+	q := req.URL.Query()
+
+	var err error
+	max_level := ^uint( 0 )
+	if s := q.Get( "level" ); s != "" {
+		if max_level, err = Parse_level( s ); err != nil {
+			http.Error( w, err.Error(), http.StatusBadRequest )
+			return
+		}
+	}
+
+	n := -1
+	if s := q.Get( "n" ); s != "" {
+		if n, err = strconv.Atoi( s ); err != nil || n < 0 {
+			http.Error( w, "bad count: " + s, http.StatusBadRequest )
+			return
+		}
+	}
+
+	_, by_prefix := q["prefix"]
+	prefix := q.Get( "prefix" )
+	match := q.Get( "match" )
+
+	recs := r.Records()
+	keep := recs[:0]
+	for _, rec := range recs {
+		if rec.Level > max_level || (by_prefix && rec.Prefix != prefix) || (match != "" && ! strings.Contains( string( rec.Line ), match )) {
+			continue
+		}
+		keep = append( keep, rec )
+	}
+	if n >= 0 && n < len( keep ) {
+		keep = keep[len( keep ) - n:]
+	}
+
+	w.Header().Set( "Content-Type", "text/plain; charset=utf-8" )
+	for _, rec := range keep {
+		w.Write( rec.Line )
+	}
+}
+
+// --------------- bleater -----------------------------------------------------------------------------------
+
+/*
+	Set the capture ring and level and push them to our children. Called by the parent,
+	and by Set_capture().
+*/
+func ( b *Bleater ) set_capture( ring *Ring, level uint ) {
+	if ring == nil {
+		level = 0					// so that the level checks in Baa() don't let anything extra through
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.ring = ring
+	b.clevel = level
+	for i := 0; i < b.cidx; i++ {
+		b.children[i].set_capture( ring, level )
+	}
+}
+
+/*
+	Set_capture causes messages at or below level, bleated by this bleater and its
+	children, to be kept in the ring whether or not they are written to the target.
+	A nil ring stops capturing.
+*/
+func ( b *Bleater ) Set_capture( ring *Ring, level uint ) {
+	b.set_capture( ring, level )
+}
+
+// --------------- levels over http --------------------------------------------------------------------------
+
+/*
+	Level_handler returns a handler which writes name=level for each registered bleater on
+	GET, and on POST or PUT sets levels from the form value levels, in the form accepted
+	by Set_levels() (e.g. levels=ostack=DEBUG,ssh_broker=1).
+*/
+func Level_handler( ) ( http.Handler ) {
+	return http.HandlerFunc( serve_levels )
+}
+
+func serve_levels( w http.ResponseWriter, req *http.Request ) {
+	switch req.Method {
+		case http.MethodGet, http.MethodHead:
+
+		case http.MethodPost, http.MethodPut:
+			spec := req.FormValue( "levels" )
+			if spec == "" {
+				http.Error( w, "no levels given", http.StatusBadRequest )
+				return
+			}
+			if err := Set_levels( spec ); err != nil {
+				http.Error( w, err.Error(), http.StatusBadRequest )
+				return
+			}
+
+		default:
+			w.Header().Set( "Allow", "GET, HEAD, POST, PUT" )
+			http.Error( w, "method not allowed", http.StatusMethodNotAllowed )
+			return
+	}
+
+	w.Header().Set( "Content-Type", "text/plain; charset=utf-8" )
+	for _, name := range Registered() {
+		if b := Lookup( name ); b != nil {
+			fmt.Fprintf( w, "%s=%s\n", name, Level_name( b.Get_level() ) )
+		}
+	}
+}
@@ -22,12 +22,17 @@
 
 	Mnemonic:	sheep_herder
 	Abstract:	A simple go routine to cause the log file that sheep are writing to to be
-				rolled now and again.
+				rolled now and again.  The log is rolled on period boundaries and, if a
+				size is set with Set_roll_size(), when the file grows beyond that size.
+				Rolled files can be compressed (gzip) and pruned by a retention policy;
+				both are done in the background so that bleating isn't held up.
 
 	Date:		30 April 2014
 	Author:		E. Scott Daniels
 
-	Mods:		16 Jul 2014 - Corrected bug that was causing log file name to be gnerated
+	Mods:		18 Oct 2026 - Added rolling on size, compression and retention of rolled
+					files; the previous file is now synced and closed by the herder.
+				16 Jul 2014 - Corrected bug that was causing log file name to be gnerated
 					based on local time and not zulu time.
 				02 Jul 2014 - Corrected typos in Baa message.
 */
@@ -35,12 +40,134 @@
 package bleater
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	//"forge.research.att.com/gopkgs/bleater"
 )
 
+/*
+	Herding (rolling) settings and state kept by the bleater that owns the log file.
+*/
+type herd struct {
+	max_size	int64			// roll when the file reaches this size; 0 rolls only on period
+	compress	bool			// gzip rolled files
+	keep_files	int				// retain at most this many rolled files (0 == all)
+	keep_days	int				// retain rolled files for this many days (0 == forever)
+	cw			*counting_writer	// counts writes to the current file
+	roll_ch		chan bool		// written to when the current file is too large
+	bg			sync.Mutex		// serialises background compression and pruning
+}
+
+/*
+	Wraps the log file counting the bytes written so that the herder can be told when
+	the file gets too large. Children are given this as their target too, so their
+	writes are counted.
+*/
+type counting_writer struct {
+	w			io.Writer
+	n			int64			// bytes in the file (atomic)
+	max			int64
+	roll_ch		chan bool
+}
+
+func (cw *counting_writer) Write( p []byte ) ( int, error ) {
+	n, err := cw.w.Write( p )
+	if atomic.AddInt64( &cw.n, int64( n ) ) >= cw.max && cw.max > 0 {
+		select {
+			case cw.roll_ch <- true:			// don't block; one waiting signal is enough
+			default:
+		}
+	}
+
+	return n, err
+}
+
+/*
+	Return the herd settings, creating them if needed.
+*/
+func (b *Bleater) get_herd( ) ( *herd ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.herd == nil {
+		b.herd = &herd{ roll_ch: make( chan bool, 1 ) }
+	}
+
+	return b.herd
+}
+
+/*
+	Return a counting writer for the file and make it the one checked by the herder.
+*/
+func (b *Bleater) count_writes( h *herd, f *os.File ) ( *counting_writer ) {
+	cw := &counting_writer{ w: f, roll_ch: h.roll_ch }
+	if fi, err := f.Stat(); err == nil {
+		cw.n = fi.Size()
+	}
+
+	b.mtx.Lock()
+	cw.max = h.max_size
+	h.cw = cw
+	b.mtx.Unlock()
+
+	return cw
+}
+
+/*
+	True if the current file has reached the maximum size.
+*/
+func (b *Bleater) too_big( h *herd ) ( bool ) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return h.cw != nil && h.max_size > 0 && atomic.LoadInt64( &h.cw.n ) >= h.max_size
+}
+
+/*
+	Set_roll_size causes Sheep_herder() to roll the log when the file reaches max bytes,
+	as well as on period boundaries. Zero turns size based rolling off. Takes effect from
+	the next roll if the herder is already running.
+*/
+func (b *Bleater) Set_roll_size( max int64 ) {
+	h := b.get_herd( )
+	b.mtx.Lock()
+	h.max_size = max
+	b.mtx.Unlock()
+}
+
+/*
+	Set_roll_compress causes rolled log files to be compressed with gzip (a .gz suffix is
+	added) in the background.
+*/
+func (b *Bleater) Set_roll_compress( on bool ) {
+	h := b.get_herd( )
+	b.mtx.Lock()
+	h.compress = on
+	b.mtx.Unlock()
+}
+
+/*
+	Set_roll_retention sets how many rolled files are kept. After each roll, files in the
+	log directory with the name <prefix>.log.* (other than the current file) are removed
+	if they aren't among the newest keep_files, or if they are older than keep_days.
+	A value of zero doesn't limit.
+*/
+func (b *Bleater) Set_roll_retention( keep_files int, keep_days int ) {
+	h := b.get_herd( )
+	b.mtx.Lock()
+	h.keep_files = keep_files
+	h.keep_days = keep_days
+	b.mtx.Unlock()
+}
+
 /*
 	Generate a new logfile name based on the current date, the log directory passed in
 	and the cycle period.
@@ -70,10 +197,169 @@ func (b *Bleater) Mk_logfile_nm( ldir *string, period int64 ) ( *string ) {
 	return &s
 }
 
+/*
+	Return the name, or the name with a .n suffix, such that it isn't the current file and
+	neither it nor its compressed version exists. A size roll inside of a period would
+	otherwise reopen the file just rolled.
+*/
+func unused_name( name string, cur string ) ( string ) {
+	try := name
+	for i := 1; ; i++ {
+		if try != cur && ! exists( try ) && ! exists( try + ".gz" ) {
+			return try
+		}
+		try = fmt.Sprintf( "%s.%d", name, i )
+	}
+}
+
+func exists( name string ) ( bool ) {
+	_, err := os.Stat( name )
+	return err == nil
+}
+
+/*
+	Compress the file to <name>.gz and remove it.
+*/
+func gzip_file( name string ) ( err error ) {
+	in, err := os.Open( name )
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile( name + ".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664 )
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter( out )
+	_, err = io.Copy( zw, in )
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove( name + ".gz" )
+		return err
+	}
+
+	return os.Remove( name )
+}
+
+/*
+	Remove rolled files (<ldir>/<prefix>.log.*) which the retention settings don't keep.
+	The current file is never removed.
+*/
+func (b *Bleater) prune_logs( ldir string, cur string, keep_files int, keep_days int ) {
+	names, _ := filepath.Glob( fmt.Sprintf( "%s/%s.log.*", ldir, b.pfx ) )
+
+	rolled := make( []os.FileInfo, 0, len( names ) )
+	paths := make( map[os.FileInfo]string, len( names ) )
+	for _, name := range names {
+		if name == cur {
+			continue
+		}
+		if fi, err := os.Stat( name ); err == nil && fi.Mode().IsRegular() {
+			rolled = append( rolled, fi )
+			paths[fi] = name
+		}
+	}
+	sort.Slice( rolled, func( i, j int ) bool { return rolled[i].ModTime().After( rolled[j].ModTime() ) } )
+
+	oldest := time.Now().Add( -time.Duration( keep_days ) * 24 * time.Hour )
+	for i, fi := range rolled {
+		if (keep_files > 0 && i >= keep_files) || (keep_days > 0 && fi.ModTime().Before( oldest )) {
+			if err := os.Remove( paths[fi] ); err != nil {
+				b.Baa( 0, "ERR: herder unable to remove old log %s: %s", paths[fi], err )
+			}
+		}
+	}
+}
+
+/*
+	Compress and prune after a roll. Run as a go routine; rolls which happen in quick
+	succession are handled one after the other.
+*/
+func (b *Bleater) tidy( h *herd, ldir string, old_name string ) {
+	h.bg.Lock()
+	defer h.bg.Unlock()
+
+	b.mtx.Lock()
+	compress := h.compress
+	keep_files := h.keep_files
+	keep_days := h.keep_days
+	cur := ""
+	if b.tfile != nil {
+		cur = b.tfile.Name()
+	}
+	b.mtx.Unlock()
+
+	if compress && old_name != "" {
+		if err := gzip_file( old_name ); err != nil {
+			b.Baa( 0, "ERR: herder unable to compress %s: %s", old_name, err )
+		}
+	}
+
+	if keep_files > 0 || keep_days > 0 {
+		b.prune_logs( ldir, cur, keep_files, keep_days )
+	}
+}
+
+/*
+	Roll closes the current log file and opens a new one in the directory, named with
+	Mk_logfile_nm() (with a .n suffix if that file was already used during this period).
+	The previous file is synced and closed once all bleaters (children included) have
+	been moved to the new one, and is then compressed and old files pruned in the
+	background if Set_roll_compress() and Set_roll_retention() were used.
+
+	Sheep_herder() calls this on each period boundary, and when the file grows too large,
+	but it may be called at any time (e.g. on receipt of a signal).
+*/
+func (b *Bleater) Roll( ldir *string, period int64 ) ( err error ) {
+	h := b.get_herd( )
+
+	b.mtx.Lock()
+	old := b.tfile
+	b.mtx.Unlock()
+
+	old_name := ""
+	if old != nil {
+		old_name = old.Name()
+	}
+
+	lfn := unused_name( *b.Mk_logfile_nm( ldir, period ), old_name )
+	f, err := os.OpenFile( lfn, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0664 )
+	if err != nil {
+		return err
+	}
+
+	b.Set_target( b.count_writes( h, f ), false )		// children are moved too; their in progress writes finish first
+	b.mtx.Lock()
+	b.tfile = f
+	b.mtx.Unlock()
+
+	if old != nil {
+		serr := old.Sync()
+		if cerr := old.Close(); cerr != nil || serr != nil {
+			b.Baa( 0, "ERR: herder had trouble closing the previous log %s: sync=%v close=%v", old_name, serr, cerr )
+		}
+	}
+
+	go b.tidy( h, *ldir, old_name )
+	return nil
+}
+
 /*
 	Go routine that manages the rolling over of the bleater log.
 	We assume there is already a log open.  Period defines when the log is rolled (e.g. 300 causes
 	the log to be rolled on 5 minute boundaries, 3600 hour boundaries, and 86400 at midnight).
+	If a size was set with Set_roll_size() the log is also rolled when it reaches that size.
 */
 func (b *Bleater) Sheep_herder(  ldir *string, period int64 ) {
 	b.Baa( 1, "sheep herder started with a period of %ds, first log roll in %d seconds", period, period - (time.Now().Unix() % period ) )
@@ -82,20 +368,38 @@ func (b *Bleater) Sheep_herder(  ldir *string, period int64 ) {
 		period = 60
 	}
 
+	h := b.get_herd( )
+	b.mtx.Lock()
+	f := b.tfile
+	b.mtx.Unlock()
+	if f != nil {
+		b.Set_target( b.count_writes( h, f ), false )		// count writes to the log already open
+		b.mtx.Lock()
+		b.tfile = f
+		b.mtx.Unlock()
+	}
+
+	pdur := time.Duration( period ) * time.Second
 	for {
-		now := time.Now().Unix()
-		time.Sleep(  time.Duration( (period - (now % period )) ) * time.Second )	// should wake us at midnight
-		lfn := b.Mk_logfile_nm( ldir, period )
-		b.Baa( 0, "herder is rolling the log into: %s", *lfn )
-		
-		err := b.Append_target( *lfn, true )			// create the next directory
-		if err != nil {
-			b.Baa( 0, "ERR: unable to roll the log to %s: %s", lfn, err )
+		next := time.Now().UTC().Truncate( pdur ).Add( pdur )		// wake on the period boundary (midnight for a day)
+		timer := time.NewTimer( time.Until( next ) )
+
+		select {
+			case <-timer.C:
+				b.Baa( 0, "herder is rolling the log at the end of the period" )
+
+			case <-h.roll_ch:
+				timer.Stop()
+				if ! b.too_big( h ) {						// stale signal from the file rolled last time
+					continue
+				}
+				b.Baa( 0, "herder is rolling the log as it has reached the maximum size" )
+		}
+
+		if err := b.Roll( ldir, period ); err != nil {
+			b.Baa( 0, "ERR: unable to roll the log into %s: %s", *ldir, err )
 		} else {
 			b.Baa( 0, "herder rolled the log" )
 		}
-
-		time.Sleep( 5 * time.Second )				// let some time pass before we recalc midnight so as to not loop during the same second
 	}
 }
-
@@ -0,0 +1,419 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	syslog
+	Abstract:	Targets which send bleats to syslog (RFC 5424) over a UDP, TCP or unix
+				socket, or to the systemd journal using its native protocol.  Both
+				are Record_targets, so the bleat level is mapped to a severity and the
+				bleater prefix and fields are passed as separate items rather than
+				as part of the text:
+
+					syslog:		APP-NAME is the tag, MSGID is the bleater prefix, and
+								fields are the structured data element fields@32473.
+					journal:	SYSLOG_IDENTIFIER is the tag, BLEATER_PREFIX and
+								BLEATER_LEVEL are added, and each field is added with
+								its key in upper case.
+
+				Levels map to severities as: ERR->err(3), WARN->warning(4),
+				INFO->info(6), DEBUG and above->debug(7).
+
+				Example:
+					st, err := bleater.Mk_syslog_target( "udp", "loghost:514", "myprog", bleater.SYSLOG_DAEMON )
+					sheep.Set_target( st, true )
+
+	Date:		18 October 2026
+	Author:		agent
+
+	Mods:		18 Oct 2026 - Dials and writes are limited by net_timeout.
+*/
+
+package bleater
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SYSLOG_USER		int = 1			// facilities; local n is SYSLOG_LOCAL0 + n
+	SYSLOG_DAEMON	int = 3
+	SYSLOG_LOCAL0	int = 16
+
+	def_journal_socket	string = "/run/systemd/journal/socket"
+	sd_fields_id		string = "fields@32473"
+	ts_5424				string = "2006-01-02T15:04:05.000000Z07:00"
+
+	net_timeout			time.Duration = 5 * time.Second		// dial and write limit so a stalled server doesn't hang Baa()
+)
+
+/*
+	Syslog_severity returns the syslog severity for the bleat level.
+*/
+func Syslog_severity( l uint ) ( int ) {
+	switch l {
+		case ERR:
+			return 3
+
+		case WARN:
+			return 4
+
+		case INFO:
+			return 6
+	}
+
+	return 7
+}
+
+/*
+	Return s with characters outside of printable US-ASCII (or in the extra set)
+	replaced with _ and truncated to max characters; "-" (nil) if empty.
+*/
+func syslog_name( s string, max int, extra string ) ( string ) {
+	if s == "" {
+		return "-"
+	}
+
+	b := []byte( s )
+	if len( b ) > max {
+		b = b[:max]
+	}
+	for i, c := range b {
+		if c < 33 || c > 126 || strings.IndexByte( extra, c ) >= 0 {
+			b[i] = '_'
+		}
+	}
+
+	return string( b )
+}
+
+/*
+	Convert a record (a plain one is treated as INFO with no prefix) to its text for the
+	message portion.
+*/
+func record_msg( r *Record ) ( string ) {
+	if r.plain {
+		return strings.TrimRight( string( r.Line ), "\n" )
+	}
+
+	return r.Msg
+}
+
+// ---- syslog ---------------------------------------------------------------------------
+
+/*
+	A target which sends RFC 5424 messages to a syslog server.
+*/
+type Syslog_target struct {
+	mtx			sync.Mutex
+	network		string
+	addr		string
+	conn		net.Conn
+	stream		bool				// tcp and unix stream sockets use octet counting (RFC 6587)
+	facility	int
+	tag			string
+	hostname	string
+	pid			int
+}
+
+/*
+	Mk_syslog_target creates a target which sends messages to the syslog server at addr.
+	Network is one of: udp, tcp, unixgram (a datagram unix socket such as /dev/log) or
+	unix (a stream unix socket).  Tag is the application name added to each message.
+	An error is returned if the server can't be reached.  If a stream connection is
+	lost, it is reopened on the next message.  Connecting and sending each give up
+	after a few seconds so that a server which has stopped responding doesn't hold up
+	the programme; the message is then dropped and the error returned.
+*/
+func Mk_syslog_target( network string, addr string, tag string, facility int ) ( st *Syslog_target, err error ) {
+	switch network {
+		case "udp", "udp4", "udp6", "unixgram":
+
+		case "tcp", "tcp4", "tcp6", "unix":
+
+		default:
+			return nil, fmt.Errorf( "unsupported syslog network: %s", network )
+	}
+
+	st = &Syslog_target{
+		network:	network,
+		addr:		addr,
+		stream:		strings.HasPrefix( network, "tcp" ) || network == "unix",
+		facility:	facility,
+		tag:		tag,
+		pid:		os.Getpid(),
+	}
+	st.hostname, _ = os.Hostname()
+
+	if st.conn, err = net.DialTimeout( network, addr, net_timeout ); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+/*
+	Build the RFC 5424 message for the record.
+*/
+func (st *Syslog_target) format( r *Record ) ( []byte ) {
+	level := r.Level
+	when := r.Time
+	if r.plain {
+		level = INFO
+		when = time.Now()
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf( buf, "<%d>1 %s %s %s %d %s ", st.facility * 8 + Syslog_severity( level ), when.Format( ts_5424 ),
+		syslog_name( st.hostname, 255, "" ), syslog_name( st.tag, 48, "" ), st.pid, syslog_name( r.Prefix, 32, "" ) )
+
+	if len( r.Fields ) > 0 {
+		buf.WriteString( "[" + sd_fields_id )
+		for i := 0; i+1 < len( r.Fields ); i += 2 {
+			v := kv_string( r.Fields[i+1] )
+			v = strings.NewReplacer( `\`, `\\`, `"`, `\"`, `]`, `\]` ).Replace( v )
+			fmt.Fprintf( buf, ` %s="%s"`, syslog_name( kv_string( r.Fields[i] ), 32, `= ]"` ), v )
+		}
+		buf.WriteString( "]" )
+	} else {
+		buf.WriteString( "-" )
+	}
+
+	if msg := record_msg( r ); msg != "" {
+		buf.WriteString( " " + msg )
+	}
+
+	return buf.Bytes()
+}
+
+/*
+	Send one message, reconnecting and trying again once if a stream connection has
+	been lost.
+*/
+func (st *Syslog_target) send( msg []byte ) ( err error ) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	if st.stream {
+		msg = append( []byte( fmt.Sprintf( "%d ", len( msg ) ) ), msg... )
+	}
+
+	for try := 0; try < 2; try++ {
+		if st.conn == nil {
+			if st.conn, err = net.DialTimeout( st.network, st.addr, net_timeout ); err != nil {
+				st.conn = nil
+				return err
+			}
+		}
+
+		st.conn.SetWriteDeadline( time.Now().Add( net_timeout ) )
+		if _, err = st.conn.Write( msg ); err == nil || ! st.stream {
+			return err
+		}
+
+		st.conn.Close()
+		st.conn = nil
+	}
+
+	return err
+}
+
+/*
+	Write sends the text as a message at the INFO severity.
+*/
+func (st *Syslog_target) Write( p []byte ) ( int, error ) {
+	if err := st.send( st.format( &Record{ Line: p, plain: true } ) ); err != nil {
+		return 0, err
+	}
+
+	return len( p ), nil
+}
+
+/*
+	Write_record sends the bleat to the server.
+*/
+func (st *Syslog_target) Write_record( r *Record ) ( error ) {
+	return st.send( st.format( r ) )
+}
+
+/*
+	Close closes the connection to the server.
+*/
+func (st *Syslog_target) Close( ) ( error ) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	if st.conn == nil {
+		return nil
+	}
+	err := st.conn.Close()
+	st.conn = nil
+	return err
+}
+
+// ---- journald -------------------------------------------------------------------------
+
+/*
+	A target which sends messages to the systemd journal.
+*/
+type Journal_target struct {
+	mtx		sync.Mutex
+	conn	net.Conn
+	tag		string
+}
+
+/*
+	Mk_journal_target creates a target which writes to the journal's socket at path
+	(the standard socket if path is empty).  Tag is used as the SYSLOG_IDENTIFIER.
+	Messages are sent as single datagrams, so a message larger than the socket allows
+	fails with an error.
+*/
+func Mk_journal_target( path string, tag string ) ( jt *Journal_target, err error ) {
+	if path == "" {
+		path = def_journal_socket
+	}
+
+	jt = &Journal_target{ tag: tag }
+	if jt.conn, err = net.DialTimeout( "unixgram", path, net_timeout ); err != nil {
+		return nil, err
+	}
+
+	return jt, nil
+}
+
+/*
+	Return the key in the form journald accepts: upper case letters, digits and
+	underscores, not starting with an underscore (those are trusted fields set by
+	journald) or a digit.
+*/
+func journal_key( k string ) ( string ) {
+	b := []byte( strings.ToUpper( k ) )
+	for i, c := range b {
+		if ! ((c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_') {
+			b[i] = '_'
+		}
+	}
+
+	k = strings.TrimLeft( string( b ), "_" )
+	if k == "" || (k[0] >= '0' && k[0] <= '9') {
+		k = "F_" + k
+	}
+	if len( k ) > 64 {
+		k = k[:64]
+	}
+
+	return k
+}
+
+/*
+	Add the field to the datagram. Values with a newline use the binary form: the key,
+	a newline, the length as a 64 bit little endian value, the value and a newline.
+*/
+func journal_field( buf *bytes.Buffer, k string, v string ) {
+	if strings.IndexByte( v, '\n' ) < 0 {
+		buf.WriteString( k + "=" + v + "\n" )
+		return
+	}
+
+	buf.WriteString( k + "\n" )
+	binary.Write( buf, binary.LittleEndian, uint64( len( v ) ) )
+	buf.WriteString( v + "\n" )
+}
+
+/*
+	Build the datagram for the record.
+*/
+func (jt *Journal_target) format( r *Record ) ( []byte ) {
+	level := r.Level
+	if r.plain {
+		level = INFO
+	}
+
+	buf := &bytes.Buffer{}
+	journal_field( buf, "MESSAGE", record_msg( r ) )
+	journal_field( buf, "PRIORITY", fmt.Sprintf( "%d", Syslog_severity( level ) ) )
+	if jt.tag != "" {
+		journal_field( buf, "SYSLOG_IDENTIFIER", jt.tag )
+	}
+	if ! r.plain {
+		if r.Prefix != "" {
+			journal_field( buf, "BLEATER_PREFIX", r.Prefix )
+		}
+		journal_field( buf, "BLEATER_LEVEL", Level_name( r.Level ) )
+	}
+	for i := 0; i+1 < len( r.Fields ); i += 2 {
+		journal_field( buf, journal_key( kv_string( r.Fields[i] ) ), kv_string( r.Fields[i+1] ) )
+	}
+
+	return buf.Bytes()
+}
+
+func (jt *Journal_target) send( msg []byte ) ( err error ) {
+	jt.mtx.Lock()
+	defer jt.mtx.Unlock()
+
+	if jt.conn == nil {
+		return fmt.Errorf( "journal target is closed" )
+	}
+
+	jt.conn.SetWriteDeadline( time.Now().Add( net_timeout ) )
+	_, err = jt.conn.Write( msg )
+	return err
+}
+
+/*
+	Write sends the text as a message at the INFO priority.
+*/
+func (jt *Journal_target) Write( p []byte ) ( int, error ) {
+	if err := jt.send( jt.format( &Record{ Line: p, plain: true } ) ); err != nil {
+		return 0, err
+	}
+
+	return len( p ), nil
+}
+
+/*
+	Write_record sends the bleat to the journal.
+*/
+func (jt *Journal_target) Write_record( r *Record ) ( error ) {
+	return jt.send( jt.format( r ) )
+}
+
+/*
+	Close closes the socket.
+*/
+func (jt *Journal_target) Close( ) ( error ) {
+	jt.mtx.Lock()
+	defer jt.mtx.Unlock()
+
+	if jt.conn == nil {
+		return nil
+	}
+	err := jt.conn.Close()
+	jt.conn = nil
+	return err
+}
@@ -27,6 +27,15 @@
 
 	Mods:		03 Feb 2015 - Fix straggling % in sprintf format, remove
 					unneeded ;'s.
+				18 Oct 2026 - Checkpoint and tumbler files are written to a temp
+					file which is synced and renamed, and the directory synced, so
+					that a crash never leaves a partial file under the final name.
+					Close returns the real name when the md5 isn't added.
+				18 Oct 2026 - Added compression and a selectable digest.
+				18 Oct 2026 - Added the inter-process lock (Mk_chkpt_locked) and a
+					mutex so that Write, Write_string and Close may be used from
+					several goroutines.
+				18 Oct 2026 - Zstd compression is built in.
 */
 
 /*
@@ -49,13 +58,39 @@
 						<path>_Xnn-<md5>.ckpt
 
 				This is enabled by invoking the Add_md5() function after the checkpoint
-				object is created.
+				object is created.  Set_digest() can be used to select sha256 rather
+				than md5, and Set_compression() to compress the checkpoint as it is
+				written; the compression is indicated by a suffix:
+				<path>_Xnn-<digest>.ckpt.gz.  Gzip and zstd are provided, and
+				others can be added with Register_compressor().
+
+				As files with the digest in the name are not overwritten when the
+				tumblers roll, a retention policy (Keep_last(), Keep_age(),
+				Keep_gfs()) can be set with Set_retention() and old files removed
+				by calling Prune().
+
+				In delta mode (Set_delta()) the 'a' files are full snapshots and
+				the 'b' files hold only the changes since the previous checkpoint,
+				written with Write_delta().  Replay() loads the newest snapshot and
+				applies its deltas.
+
+				Mk_record_writer() and Mk_record_reader() add a record layer (type
+				tag, length and CRC per record) for users who would rather not
+				invent their own format; see record.go.
 
 				The file <path>.ckpt (no tumbler) is used to record the tumbler
 				values such that the next time the programme is started it will
 				create the next file in a continued sequence rather than resetting
 				or requiring the user programme to manage the tumbler data.
 
+				While a checkpoint is being written it has a .tmp suffix. On close
+				the file is synced to disk and renamed to its final name. The
+				tumbler file is updated in the same manner when the checkpoint
+				is created. A crash leaves
+				either the previous state or the new state, never a partial file
+				with the final name or a partial tumbler file. Open_latest() can be
+				used to find and open the newest good checkpoint after a restart.
+
 				chkpt implements the io.Writer interface such that it is possible
 				to use the pointer to the object in an fmt.Fprintf() call:
 					c = Mk_chkpt( "/usr2/ckpts/agama", 5, 25 )
@@ -70,11 +105,12 @@ package chkpt
 
 import (
 	"bufio"
-	"crypto/md5"
 	"fmt"
 	"hash"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/att/gopkgs/clike"
 	"github.com/att/gopkgs/token"
@@ -95,13 +131,57 @@ type Chkpt struct {
 	output	*os.File			// open ckpt file
 	bw		*bufio.Writer		// wrapping writer to file
 	br		*bufio.Reader		// wrapping reader
-	md5hash	hash.Hash			// we compute the md5 on write and add to the name
-	open_name	*string		// name of the file that is being written to
-	addmd5	bool				// true if we should add the md5 value to the file name (prevents overlay)
+	cw		io.WriteCloser		// compressing writer when compression is on
+	wr		io.Writer			// where Write() sends data: cw or bw
+	dhash	hash.Hash			// we compute the digest on write and add to the name
+	open_name	*string		// name of the file that is being written to (without .ckpt)
+	tmp_name	string			// name of the file while it is being written
+	add_digest	bool			// true if we should add the digest to the file name (prevents overlay)
+	digest	string				// digest algorithm (DIGEST_ constant); md5 if not set
+	comp	*compressor			// compression to apply; nil for none
+	retention	Policy			// what Prune() keeps; nil to keep everything
+	delta	bool				// b files are deltas against the last full (a) snapshot
+	full	bool				// in delta mode, the open checkpoint is a full snapshot
+	base	string				// basename of the snapshot deltas apply to; empty forces a full snapshot
+	seq		int					// position of the open delta file in the chain
+	mtx		sync.Mutex			// serialises Write/Create/Close from multiple goroutines
+	lock_file	*os.File		// holds the inter-process lock while open; nil if not locked
+	lock_err	error			// set when the lock is released; Create() then fails
 }
 
 // --------------- private -----------------------------------------------------------------
 
+/*
+	If set, called at each step of writing a checkpoint or the tumbler file so that a
+	test can simulate a crash (by panicking) or a failure (by returning an error).
+*/
+var fail_hook func( step string ) ( error )
+
+func fail_point( step string ) ( error ) {
+	if fail_hook != nil {
+		return fail_hook( step )
+	}
+
+	return nil
+}
+
+/*
+	Sync the directory so that a rename (or create) in it is on disk.
+*/
+func sync_dir( dir string ) ( error ) {
+	d, err := os.Open( dir )
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
 /*
 	Look into the tumbler counter file and get the two counters out.
 */
@@ -143,13 +223,13 @@ func (c *Chkpt) read_tumblers( ) (aval int, bval int, err error) {
 
 /*
 	Save the current tumbler values into the counter file.
-	We write the current values to a temp file and close it. If successful
-	then we move it over the existing file.
+	We write the current values to a temp file, sync and close it. If successful
+	then we move it over the existing file and sync the directory.
 */
 func (c *Chkpt) save_tumblers( ) (error) {
 
 	old_fname := fmt.Sprintf( "%s.ckpt", *c.path )
-	new_fname := fmt.Sprintf( "%s", old_fname )
+	new_fname := old_fname + ".tmp"
 
 	f, err := os.Create( new_fname )
 	if err != nil {
@@ -160,13 +240,30 @@ func (c *Chkpt) save_tumblers( ) (error) {
 	s := fmt.Sprintf( "%d %d\n", c.aval, c.bval )
 	b := []byte( s )
 	_, err = f.Write( b )
+	if err == nil {
+		err = fail_point( "tumbler_sync" )
+	}
+	if err == nil {
+		err = f.Sync( )
+	}
+	if cerr := f.Close( ); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fail_point( "tumbler_rename" )
+	}
 	if err != nil {
-		f.Close( )
+		os.Remove( new_fname )
 		return err
 	}
-	f.Close( )
 
 	err = os.Rename( new_fname, old_fname )
+	if err == nil {
+		err = fail_point( "tumbler_dirsync" )
+	}
+	if err == nil {
+		err = sync_dir( filepath.Dir( old_fname ) )
+	}
 	return err
 }
 
@@ -176,6 +273,9 @@ func (c *Chkpt) save_tumblers( ) (error) {
 	Mk_chkpt creates a checkpointing environment. Path is any leading directory path, with
 	a basename (prefix) mask (e.g. /usr2/backups/foo).  The tumbler information is added to
 	path in the form of either a_xxxx or b_xxxx where xxx is the tumbler number.
+
+	No lock is taken; see Mk_chkpt_locked() to keep other processes from writing
+	checkpoints for the same path.
 */
 func Mk_chkpt( path string, amax int, bmax int ) (c *Chkpt) {
 
@@ -185,6 +285,15 @@ func Mk_chkpt( path string, amax int, bmax int ) (c *Chkpt) {
 		path:	&path,
 	}
 
+	c.load_tumblers( )
+
+	return
+}
+
+/*
+	Set the tumbler values from the counter file.
+*/
+func (c *Chkpt) load_tumblers( ) {
 	c.aval, c.bval, _ = c.read_tumblers( )
 
 	if c.aval > c.amax {
@@ -193,27 +302,29 @@ func Mk_chkpt( path string, amax int, bmax int ) (c *Chkpt) {
 	if c.bval > c.bmax {
 		c.bval = 0
 	}
-
-	return
 }
 
 /*
 	Sets the flag that will cause the final file name to include the MD5 value computed for
 	the file.  Adding the md5 breaks the 'overlay' nature of the tumbler system.
+	See Set_digest() to use a different algorithm.
 */
 func (c *Chkpt) Add_md5( ) {
-	c.addmd5 = true
+	c.Set_digest( DIGEST_MD5 )
 }
 
 /*
 	Write_string writes the string to the open chkpt file.
 */
 func (c *Chkpt) Write_string( s string ) (n int, err error ) {
-	if c.md5hash != nil {
-		io.WriteString( c.md5hash, s )
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.output == nil {			// silently ignore attempt to write before it's open
+		return
 	}
 
-	n, err = io.WriteString( c.output, s )
+	n, err = io.WriteString( c.wr, s )
 	if err != nil {
 		c.errors = true
 	}
@@ -226,16 +337,14 @@ func (c *Chkpt) Write_string( s string ) (n int, err error ) {
 	package can be used.
 */
 func (c *Chkpt) Write( b []byte ) (n int, err error ) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 
 	if c.output == nil {			// silently ignore attempt to write before it's open
 		return
 	}
 
-	if c.md5hash != nil {
-		io.WriteString( c.md5hash, string( b ) )
-	}
-
-	n, err = c.output.Write( b )
+	n, err = c.wr.Write( b )
 	if err != nil {
 		c.errors = true
 	}
@@ -243,47 +352,87 @@ func (c *Chkpt) Write( b []byte ) (n int, err error ) {
 }
 
 /*
-	Closes the open checkpoint file. The final file is renamed with the md5sum (if no write errors).
+	Closes the open checkpoint file. The file is synced to disk and then renamed from its
+	temporary name to the final name, with the digest and compression suffix added if
+	requested, and the directory is synced.
 	The final filename and any error status are reported to the caller.
 	Success is reported (err == nil) only when no write errors and a successful close/rename.
+	If there were write errors the file is not renamed (it is removed) so that a partial
+	checkpoint is never left with a final name.
 */
 func (c *Chkpt) Close( ) (final_name string, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 
+	return c.close( )
+}
 
+/*
+	Does the work of Close; the caller must hold the mutex.
+*/
+func (c *Chkpt) close( ) (final_name string, err error) {
 	if c.output == nil {
 		return "", nil
 	}
+	defer func() { c.delta_closed( final_name, err ) }()
 
+	if c.cw != nil {		// compressor must write its trailer before the flush
+		if c.cw.Close( ) != nil {
+			c.errors = true
+		}
+	}
 	if c.bw != nil {		// flush if writing
-		c.bw.Flush( )
+		if c.bw.Flush( ) != nil {
+			c.errors = true
+		}
 	}
 
-	err = c.output.Close( )
+	err = fail_point( "sync" )
+	if err == nil {
+		err = c.output.Sync( )
+	}
+	if cerr := c.output.Close( ); err == nil {
+		err = cerr
+	}
 
 	c.bw = nil
 	c.br = nil
+	c.cw = nil
+	c.wr = nil
 	c.output = nil
 
 	final_name = ""
-	if err == nil && c.md5hash != nil {
-		md5 := c.md5hash.Sum( nil )
-
-		c.md5hash = nil
-
-		if c.open_name != nil {
-			if c.addmd5 {
-				final_name = fmt.Sprintf( "%s-%x.ckpt", *c.open_name, md5 )
-				err = os.Rename( *c.open_name + ".ckpt", final_name )
-			} else {
-				final_name = *c.open_name
-			}
+	if c.dhash != nil && c.open_name != nil {
+		suffix := ""
+		if c.comp != nil {
+			suffix = c.comp.suffix
+		}
+		if c.add_digest {
+			final_name = fmt.Sprintf( "%s-%x.ckpt%s", *c.open_name, c.dhash.Sum( nil ), suffix )
+		} else {
+			final_name = *c.open_name + ".ckpt" + suffix
 		}
 	}
-
+	c.dhash = nil
 	c.open_name = nil
 
 	if err == nil && c.errors {			// no close error, but write errors, fail the close
-		err = fmt.Errorf( "close successful, but write errors detected; file may be corrupt: %s", final_name )
+		err = fmt.Errorf( "write errors detected; checkpoint discarded: %s", final_name )
+	}
+	if err == nil {
+		err = fail_point( "rename" )
+	}
+	if err != nil {
+		os.Remove( c.tmp_name )
+		return final_name, err
+	}
+
+	if err = os.Rename( c.tmp_name, final_name ); err != nil {
+		os.Remove( c.tmp_name )
+		return final_name, err
+	}
+	if err = fail_point( "dirsync" ); err == nil {
+		err = sync_dir( filepath.Dir( final_name ) )
 	}
 
 	return
@@ -293,6 +442,7 @@ func (c *Chkpt) Close( ) (final_name string, err error) {
 	The create method caues a new checkpoint file to be created and opened. Once
 	opened, thw Write or Write_string methods can be called to write to the the file.
 	The Close method should be invoked when all writing has occurred.
+	If the environment was locked and the lock has been released, an error is returned.
 */
 func (c *Chkpt) Create( )  ( error ) {
 	var (
@@ -300,12 +450,22 @@ func (c *Chkpt) Create( )  ( error ) {
 		tval	int				// tumbler value for filename
 		tch		string = "b"	// tumbler letter for filename; likely it will be 'b'
 	)
-	
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.lock_err != nil {		// we released the lock
+		return c.lock_err
+	}
+
 	if c.output != nil {		// user didn't close last one
-		c.Close( )				// we'll ignore errors -- little we can do if they didn't drive the close
+		c.close( )				// we'll ignore errors -- little we can do if they didn't drive the close
 	}
 
 	c.errors = false
+	if c.delta && c.base == "" {		// no snapshot for deltas to apply to; force a roll to the next a
+		c.bval = c.bmax
+	}
 	c.bval++					// inc the tumbler(s) and set the ch/value pair for name
 	if c.bval > c.bmax {
 		c.bval = 0
@@ -320,19 +480,40 @@ func (c *Chkpt) Create( )  ( error ) {
 		tval = c.bval
 	}
 
-	fname := fmt.Sprintf( "%s_%s%d.ckpt", *c.path, tch, tval )
-	c.output, err = os.Create( fname )
-	if err != nil {
+	if err = c.save_tumblers( ); err != nil {		// saved first so that a crash while writing doesn't reuse the name on restart
 		return err
 	}
 
 	on := fmt.Sprintf( "%s_%s%d", *c.path, tch, tval )
+	c.tmp_name = on + ".ckpt.tmp"					// renamed to the final name on close
+	c.output, err = os.Create( c.tmp_name )
+	if err != nil {
+		return err
+	}
 	c.open_name = &on
 
-	c.save_tumblers( )
+	mk_hash := digests[c.digest]
+	if mk_hash == nil {
+		mk_hash = digests[DIGEST_MD5]
+	}
+	c.dhash = mk_hash()
+	c.bw = bufio.NewWriter( io.MultiWriter( c.output, c.dhash ) )		// digest is of the bytes in the file
+	c.wr = c.bw
+	if c.comp != nil {
+		if c.cw, err = c.comp.writer( c.bw ); err != nil {
+			c.output.Close( )
+			os.Remove( c.tmp_name )
+			c.output = nil
+			c.open_name = nil
+			return err
+		}
+		c.wr = c.cw
+	}
 
-	c.bw = bufio.NewWriter( c.output )
-	c.md5hash = md5.New()
+	if err = c.delta_open( tch ); err != nil {
+		c.errors = true
+		return err
+	}
 
 	return nil
 }
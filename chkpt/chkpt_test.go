@@ -21,7 +21,18 @@
 package chkpt_test
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 	"testing"
 
@@ -53,3 +64,757 @@ func TestChkpt( t *testing.T ) {
 	}
 	fmt.Printf( "output chkpt to: %s\n", fname )
 }
+
+/*
+	Create a checkpoint with the given contents returning the final name.
+*/
+func mk_ckpt( t *testing.T, c *chkpt.Chkpt, contents string ) ( string ) {
+	if err := c.Create(); err != nil {
+		t.Fatalf( "create failed: %s", err )
+	}
+	fmt.Fprintf( c, "%s", contents )
+	fname, err := c.Close()
+	if err != nil {
+		t.Fatalf( "close failed: %s", err )
+	}
+
+	return fname
+}
+
+func TestOpen_latest( t *testing.T ) {
+	path := filepath.Join( t.TempDir(), "state" )
+
+	if _, _, err := chkpt.Open_latest( path ); err == nil {
+		t.Errorf( "expected error when there are no checkpoints" )
+	}
+
+	c := chkpt.Mk_chkpt( path, 2, 2 )
+	c.Add_md5()
+	names := make( []string, 0 )
+	for i := 0; i < 8; i++ {							// a1 b1 b2 a2 b1 b2 a1 b1
+		names = append( names, mk_ckpt( t, c, fmt.Sprintf( "checkpoint %d\n", i ) ) )
+	}
+
+	list, err := chkpt.List( path )
+	if err != nil {
+		t.Fatalf( "list failed: %s", err )
+	}
+	fmt.Fprintf( os.Stderr, "checkpoints newest first:\n\t%s\n", strings.Join( list, "\n\t" ) )
+	if len( list ) != 8 || list[0] != names[7] || list[1] != names[6] || list[2] != names[5] {
+		t.Errorf( "list not in tumbler order" )
+	}
+
+	rc, fname, err := chkpt.Open_latest( path )
+	if err != nil {
+		t.Fatalf( "open latest failed: %s", err )
+	}
+	buf, _ := io.ReadAll( rc )
+	rc.Close()
+	if fname != names[7] || string( buf ) != "checkpoint 7\n" {
+		t.Errorf( "open latest returned the wrong file: %s: %s", fname, buf )
+	}
+
+	os.WriteFile( names[7], []byte( "truncat" ), 0644 )			// corrupt the newest two
+	os.WriteFile( names[6], []byte( "checkpoint 6 with garbage\n" ), 0644 )
+	os.WriteFile( path + "_b2.ckpt", []byte( "never closed\n" ), 0644 )	// crashed while writing
+
+	rc, fname, err = chkpt.Open_latest( path )
+	if err != nil {
+		t.Fatalf( "open latest after corruption failed: %s", err )
+	}
+	buf, _ = io.ReadAll( rc )
+	rc.Close()
+	if fname != names[5] || string( buf ) != "checkpoint 5\n" {
+		t.Errorf( "open latest did not fall back to the older file: %s: %s", fname, buf )
+	}
+}
+
+type crash struct {
+	step	string
+}
+
+/*
+	Write a checkpoint crashing (panic) at the named step. Returns true if the crash
+	happened.
+*/
+func crash_at( c *chkpt.Chkpt, step string, contents string ) ( crashed bool ) {
+	chkpt.Set_fail_hook( func( s string ) error {
+		if s == step {
+			panic( &crash{ step: s } )
+		}
+		return nil
+	} )
+
+	defer func() {
+		chkpt.Set_fail_hook( nil )
+		if r := recover(); r != nil {
+			if _, ok := r.( *crash ); !ok {
+				panic( r )
+			}
+			crashed = true
+		}
+	}()
+
+	c.Create()
+	fmt.Fprintf( c, "%s", contents )
+	c.Close()
+	return false
+}
+
+func TestInterrupted( t *testing.T ) {
+	steps := []string{ "tumbler_sync", "tumbler_rename", "tumbler_dirsync", "sync", "rename", "dirsync" }
+
+	for _, step := range steps {
+		dir := t.TempDir()
+		path := filepath.Join( dir, "state" )
+
+		c := chkpt.Mk_chkpt( path, 3, 5 )
+		c.Add_md5()
+		good := mk_ckpt( t, c, "good state\n" )
+
+		c = chkpt.Mk_chkpt( path, 3, 5 )		// as if restarted
+		c.Add_md5()
+		if ! crash_at( c, step, "new state\n" ) {
+			t.Errorf( "%s: crash was not simulated", step )
+			continue
+		}
+
+		rc, fname, err := chkpt.Open_latest( path )
+		if err != nil {
+			t.Errorf( "%s: no checkpoint after crash: %s", step, err )
+			continue
+		}
+		buf, _ := io.ReadAll( rc )
+		rc.Close()
+		renamed := step == "dirsync"					// crash after the rename
+		if renamed && string( buf ) != "new state\n" || ! renamed && (fname != good || string( buf ) != "good state\n") {
+			t.Errorf( "%s: unexpected checkpoint after crash: %s: %s", step, fname, buf )
+		}
+
+		tb, err := os.ReadFile( path + ".ckpt" )
+		if err != nil || (string( tb ) != "1 0\n" && string( tb ) != "1 1\n") {
+			t.Errorf( "%s: tumbler file is not complete: %q %v", step, tb, err )
+		}
+
+		c = chkpt.Mk_chkpt( path, 3, 5 )		// restart again; must be able to continue
+		c.Add_md5()
+		mk_ckpt( t, c, "after restart\n" )
+		rc, _, err = chkpt.Open_latest( path )
+		if err != nil {
+			t.Errorf( "%s: no checkpoint after restart: %s", step, err )
+			continue
+		}
+		buf, _ = io.ReadAll( rc )
+		rc.Close()
+		if string( buf ) != "after restart\n" {
+			t.Errorf( "%s: newest checkpoint after restart is wrong: %s", step, buf )
+		}
+	}
+
+	for _, md5 := range []bool{ true, false } {			// crash mid-cycle when the target slot holds a file from the last cycle
+		for _, step := range append( steps, "open" ) {
+			path := filepath.Join( t.TempDir(), "state" )
+			c := chkpt.Mk_chkpt( path, 2, 3 )
+			if md5 {
+				c.Add_md5()
+			}
+			for i := 0; i < 7; i++ {									// a1 b1 b2 b3 a2 b1 b2; b3 next
+				mk_ckpt( t, c, fmt.Sprintf( "ckpt %d\n", i ) )
+				time.Sleep( 5 * time.Millisecond )						// mtimes must differ
+			}
+
+			want := "ckpt 6\n"
+			if step == "open" {											// still being written
+				c.Create()
+				fmt.Fprintf( c, "new state\n" )
+			} else {
+				crash_at( c, step, "new state\n" )
+				if step == "dirsync" {
+					want = "new state\n"
+				}
+			}
+
+			rc, fname, err := chkpt.Open_latest( path )
+			if err != nil {
+				t.Errorf( "md5=%v %s: no checkpoint after mid-cycle crash: %s", md5, step, err )
+				continue
+			}
+			buf, _ := io.ReadAll( rc )
+			rc.Close()
+			if string( buf ) != want {
+				t.Errorf( "md5=%v %s: mid-cycle crash opened the wrong checkpoint: %s: %s", md5, step, fname, buf )
+			}
+		}
+	}
+
+	chkpt.Set_fail_hook( func( s string ) error {			// failures (not crashes) must not leave a file behind
+		if s == "sync" {
+			return fmt.Errorf( "simulated sync failure" )
+		}
+		return nil
+	} )
+	defer chkpt.Set_fail_hook( nil )
+
+	path := filepath.Join( t.TempDir(), "state" )
+	c := chkpt.Mk_chkpt( path, 3, 5 )
+	c.Create()
+	fmt.Fprintf( c, "data\n" )
+	if _, err := c.Close(); err == nil {
+		t.Errorf( "close did not report the sync failure" )
+	}
+	if list, _ := filepath.Glob( path + "_*" ); len( list ) != 0 {
+		t.Errorf( "files left after failed close: %v", list )
+	}
+}
+
+func TestCompression( t *testing.T ) {
+	path := filepath.Join( t.TempDir(), "state" )
+	data := strings.Repeat( "reservation state line\n", 1000 )
+
+	c := chkpt.Mk_chkpt( path, 3, 5 )
+	if err := c.Set_compression( chkpt.COMP_GZIP ); err != nil {
+		t.Fatalf( "unable to set gzip: %s", err )
+	}
+	if err := c.Set_digest( chkpt.DIGEST_SHA256 ); err != nil {
+		t.Fatalf( "unable to set sha256: %s", err )
+	}
+	fname := mk_ckpt( t, c, data )
+	if ! strings.HasSuffix( fname, ".ckpt.gz" ) || len( filepath.Base( fname ) ) != len( "state_a1-.ckpt.gz" ) + 64 {
+		t.Errorf( "name does not have a sha256 digest and gzip suffix: %s", fname )
+	}
+	if fi, err := os.Stat( fname ); err != nil || fi.Size() >= int64( len( data ) ) {
+		t.Errorf( "file was not compressed: %v", err )
+	}
+
+	rc, lname, err := chkpt.Open_latest( path )
+	if err != nil {
+		t.Fatalf( "unable to open compressed checkpoint: %s", err )
+	}
+	buf, _ := io.ReadAll( rc )
+	rc.Close()
+	if lname != fname || string( buf ) != data {
+		t.Errorf( "compressed checkpoint did not read back: %s %d bytes", lname, len( buf ) )
+	}
+
+	if err = c.Set_compression( chkpt.COMP_ZSTD ); err != nil {
+		t.Fatalf( "unable to set zstd: %s", err )
+	}
+	fname = mk_ckpt( t, c, data )
+	if fi, err := os.Stat( fname ); err != nil || ! strings.HasSuffix( fname, ".ckpt.zst" ) || fi.Size() >= int64( len( data ) ) {
+		t.Errorf( "zstd checkpoint was not compressed: %s %v", fname, err )
+	}
+	rc, lname, err = chkpt.Open_latest( path )
+	if err != nil {
+		t.Fatalf( "unable to open zstd checkpoint: %s", err )
+	}
+	buf, _ = io.ReadAll( rc )
+	rc.Close()
+	if lname != fname || string( buf ) != data {
+		t.Errorf( "zstd checkpoint did not read back: %s %d bytes", lname, len( buf ) )
+	}
+
+	err = chkpt.Register_compressor( "zlib", ".zz",
+		func( w io.Writer ) ( io.WriteCloser, error ) { return zlib.NewWriter( w ), nil },
+		func( r io.Reader ) ( io.ReadCloser, error ) { return zlib.NewReader( r ) } )
+	if err != nil {
+		t.Fatalf( "unable to register compressor: %s", err )
+	}
+	if err = chkpt.Register_compressor( "other", ".gz", nil, nil ); err == nil {
+		t.Errorf( "expected error registering incomplete compressor" )
+	}
+	c.Set_compression( "zlib" )
+	c.Add_md5()
+	fname = mk_ckpt( t, c, "zlib data\n" )
+	rc, lname, err = chkpt.Open_latest( path )
+	if err != nil {
+		t.Fatalf( "unable to open zlib checkpoint: %s", err )
+	}
+	buf, _ = io.ReadAll( rc )
+	rc.Close()
+	if lname != fname || !strings.HasSuffix( lname, ".ckpt.zz" ) || string( buf ) != "zlib data\n" {
+		t.Errorf( "user compressed checkpoint did not read back: %s %s", lname, buf )
+	}
+}
+
+/*
+	Compress with the given chunk size and read back.
+*/
+func zstd_round_trip( t *testing.T, what string, data []byte, chunk int ) ( []byte ) {
+	out := &bytes.Buffer{}
+	zw, _ := chkpt.Mk_zstd_writer( out )
+	for p := data; len( p ) > 0; {
+		n := min( chunk, len( p ) )
+		if _, err := zw.Write( p[:n] ); err != nil {
+			t.Fatalf( "%s: write failed: %s", what, err )
+		}
+		p = p[n:]
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf( "%s: close failed: %s", what, err )
+	}
+
+	zr, err := chkpt.Mk_zstd_reader( bytes.NewReader( out.Bytes() ) )
+	if err != nil {
+		t.Fatalf( "%s: unable to open: %s", what, err )
+	}
+	got, err := io.ReadAll( zr )
+	if err != nil || ! bytes.Equal( got, data ) {
+		t.Errorf( "%s: did not read back: err=%v %d of %d bytes", what, err, len( got ), len( data ) )
+	}
+
+	return out.Bytes()
+}
+
+func TestZstd( t *testing.T ) {
+	lines := &bytes.Buffer{}
+	for i := 0; lines.Len() < 3 << 20; i++ {							// several blocks, and more than the window
+		fmt.Fprintf( lines, "reservation %d host%03d state=%s\n", i, (i * 7919) % 200, []string{ "up", "down", "pending" }[i % 3] )
+	}
+	rnd := make( []byte, 300000 )
+	rand.New( rand.NewSource( 1 ) ).Read( rnd )
+	high := bytes.Repeat( []byte( "caf\xc3\xa9 \xe2\x82\xac\xff\xfe " ), 5000 )
+
+	cases := []struct {
+		what	string
+		data	[]byte
+	}{
+		{ "empty", nil },
+		{ "short", []byte( "hello hello hello world\n" ) },
+		{ "text", lines.Bytes() },
+		{ "random", rnd },
+		{ "run", bytes.Repeat( []byte{ 'x' }, 300000 ) },
+		{ "high bytes", high },
+		{ "mixed", append( append( append( []byte{}, lines.Bytes()[:200000]... ), rnd[:50000]... ), lines.Bytes()[:400000]... ) },
+	}
+	for _, c := range cases {
+		for _, chunk := range []int{ 1 << 30, 4093 } {
+			z := zstd_round_trip( t, c.what, c.data, chunk )
+			if c.what == "text" && len( z ) > len( c.data ) / 5 {
+				t.Errorf( "text was not compressed well: %d to %d bytes", len( c.data ), len( z ) )
+			}
+			if c.what == "random" && len( z ) > len( c.data ) + 100 {
+				t.Errorf( "random data grew too much: %d to %d bytes", len( c.data ), len( z ) )
+			}
+		}
+	}
+
+	z := zstd_round_trip( t, "text", lines.Bytes()[:500000], 1 << 30 )		// damage is reported, not returned as data
+	flipped := append( []byte{}, z... )
+	flipped[len( z ) / 2] ^= 0x10
+	for _, bad := range [][]byte{ z[:len( z ) / 2], flipped } {
+		zr, err := chkpt.Mk_zstd_reader( bytes.NewReader( bad ) )
+		if err == nil {
+			_, err = io.ReadAll( zr )
+		}
+		if err == nil {
+			t.Errorf( "damaged zstd data did not give an error" )
+		}
+	}
+	if _, err := chkpt.Mk_zstd_reader( strings.NewReader( "not zstd" ) ); err == nil {
+		t.Errorf( "expected error reading something which is not zstd" )
+	}
+
+	cli := "KLUv/WRVHR0UAEZtWRSwaTkgYq4E4g+NlZQyJZmS1E5wIFwATgBQABU2vRMzn8pRI/HGhGSt" +		// zstd -19: huffman literals and fse tables
+		"EXR/vrBsQ0rScX12OGIRp2iZrjQWSeVl4vGLGc1OkxpZsUUttmHRpVZG5rvsz2U8pRJrhdBw" +
+		"IBhACBAMHA4CEhgEChYGFhQCIhYUDgYBrcz1MdUiasRXhI5+TsXYilNLvc9QNbg4qRcxHzF0" +
+		"+lxCibbdhGXJ2nFU/HKJHw2nThqXJWTlSJLRsdSgXMpiOj8T8xF5dVm4I7etFZMJploEjfiK" +
+		"0MHTnIqxFaeWep8hV4MLk3oR80NUEt1YHbH7KSqHjAyW5JZPUD28ci0Z+zOqiU/IRgn5lyFF" +
+		"UczMdlKeD60qaKeUEjd9hCpqQwcAI74idOw0p2Jsxaml3odcDS6E1IuYH6KKbqyO2P0UlUNG" +
+		"xkpyyyeoHl65loz9zqgmPiEbJOR/SFEUM7OdlOdTqqCdUkrY9BGqiNrQrcz1CYF2qBEQ1W3/" +
+		"DYFXkgxjEVQkIjyEEBIcLH1YUgx80XZ1nS5jYhbXSDCrUvK48Yo+4NhicXK1HnZXYQYem8lp" +
+		"fVzZF9Ibtn58Dd8T+F88VjRcXZbH/NvCcmEbGfxZHuvDzyOzsqvBa9F+Q6cOn4+FbcFyOV2P" +
+		"ntboKpdsb3ycpuCzWLrBtuVXedg6+d2xCaZvNUqAx36WX+ojV9AGbkASFi8FNgvOxkdNBXJE" +
+		"TeTERTRsTrngsIJmxDrq6hRZX6Ap0MJlsSkURCtz5KXYNBwSaxxF9CMlES/S6KTmYbEBwyDa" +
+		"dnlxaKN5+AntldAEiwbOi0vb5MahDfPCCWnQWJRoSOjHDpslkURP5lMUL/RSGG1WqvRUEgvi" +
+		"pFUgrQINPSli"
+	want := &bytes.Buffer{}
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf( want, "host%02d state=%s load=%d\n", i % 37, []string{ "up", "down", "pending", "drained" }[(i * i) % 4], (i * 7919) % 1000 )
+	}
+	raw, _ := base64.StdEncoding.DecodeString( cli )
+	zr, err := chkpt.Mk_zstd_reader( bytes.NewReader( raw ) )
+	if err != nil {
+		t.Fatalf( "unable to open zstd data from the command line tool: %s", err )
+	}
+	got, err := io.ReadAll( zr )
+	if err != nil || ! bytes.Equal( got, want.Bytes() ) {
+		t.Errorf( "zstd data from the command line tool did not read back: err=%v %d bytes", err, len( got ) )
+	}
+}
+
+func TestRetention( t *testing.T ) {
+	now := time.Date( 2026, 10, 18, 12, 0, 0, 0, time.Local )
+	files := make( []*chkpt.Info, 0 )
+	for h := 0; h < 24 * 70; h += 6 {					// every 6 hours for 70 days, newest first
+		files = append( files, &chkpt.Info{ Name: fmt.Sprintf( "f%d", h ), Mtime: now.Add( -time.Duration( h ) * time.Hour ) } )
+	}
+
+	count := func( keep []bool ) ( n int ) {
+		for _, k := range keep {
+			if k {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := count( chkpt.Keep_last( 3 ).Keep( files, now ) ); n != 3 {
+		t.Errorf( "keep last 3 kept %d", n )
+	}
+	if n := count( chkpt.Keep_age( 24 * time.Hour ).Keep( files, now ) ); n != 5 {		// 0, 6, 12, 18 and 24 hours old
+		t.Errorf( "keep 1 day kept %d", n )
+	}
+	keep := chkpt.Keep_gfs( 7, 4, 2 ).Keep( files, now )
+	if ! keep[0] || count( keep ) < 7 || count( keep ) > 7 + 4 + 2 {
+		t.Errorf( "gfs kept %d files", count( keep ) )
+	}
+	last := files[len( files ) - 1]
+	for i, k := range keep {
+		if k && now.Sub( files[i].Mtime ) > 62 * 24 * time.Hour {
+			t.Errorf( "gfs kept a file older than two months: %s", files[i].Name )
+		}
+	}
+	if keep[len( keep ) - 1] {
+		t.Errorf( "gfs kept the oldest file: %s", last.Name )
+	}
+	if n := count( chkpt.Keep_any( chkpt.Keep_last( 1 ), chkpt.Keep_age( 12 * time.Hour ) ).Keep( files, now ) ); n != 3 {
+		t.Errorf( "keep any kept %d", n )
+	}
+
+	path := filepath.Join( t.TempDir(), "state" )
+	c := chkpt.Mk_chkpt( path, 3, 5 )
+	c.Add_md5()
+	names := make( []string, 0 )
+	for i := 0; i < 6; i++ {
+		names = append( names, mk_ckpt( t, c, fmt.Sprintf( "state %d\n", i ) ) )
+	}
+	os.WriteFile( path + "_b9.ckpt.tmp", []byte( "crashed" ), 0644 )
+
+	if removed, _ := c.Prune(); len( removed ) != 0 {
+		t.Errorf( "prune without a policy removed files" )
+	}
+
+	c.Set_retention( chkpt.Keep_last( 4 ) )
+	removed, err := c.Prune()
+	if err != nil || len( removed ) != 3 {						// two checkpoints and the temp file
+		t.Errorf( "prune keeping 4 removed %d: %v %v", len( removed ), removed, err )
+	}
+
+	os.WriteFile( names[5], []byte( "corrupt" ), 0644 )
+	os.WriteFile( names[4], []byte( "corrupt" ), 0644 )
+	c.Set_retention( chkpt.Keep_last( 1 ) )
+	if _, err = c.Prune(); err != nil {
+		t.Errorf( "prune failed: %s", err )
+	}
+	list, _ := chkpt.List( path )
+	if len( list ) != 2 || list[0] != names[5] || list[1] != names[3] {
+		t.Errorf( "prune did not keep the newest verified checkpoint: %v", list )
+	}
+
+	path = filepath.Join( t.TempDir(), "open" )				// prune while a checkpoint is open in a slot with an old file
+	c = chkpt.Mk_chkpt( path, 2, 3 )
+	c.Add_md5()
+	names = names[:0]
+	for i := 0; i < 7; i++ {
+		names = append( names, mk_ckpt( t, c, fmt.Sprintf( "ckpt %d\n", i ) ) )
+		time.Sleep( 5 * time.Millisecond )
+	}
+	c.Set_retention( chkpt.Keep_last( 2 ) )
+	c.Create()													// b3; holds ckpt 3 from the first cycle
+	fmt.Fprintf( c, "ckpt 7\n" )
+	if _, err = c.Prune(); err != nil {
+		t.Errorf( "prune with an open checkpoint failed: %s", err )
+	}
+	list, _ = chkpt.List( path )
+	if len( list ) != 2 || list[0] != names[6] || list[1] != names[5] {
+		t.Errorf( "prune with an open checkpoint kept the wrong files: %v", list )
+	}
+	if fname, err := c.Close(); err != nil {
+		t.Errorf( "close after prune failed: %s", err )
+	} else if list, _ = chkpt.List( path ); len( list ) != 3 || list[0] != fname {
+		t.Errorf( "checkpoint closed after prune is not the newest: %v", list )
+	}
+}
+
+/*
+	State for the delta test: a map written as key=value lines in a snapshot and as
+	single key=value changes in deltas.
+*/
+func load_state( m map[string]string ) ( func( r io.Reader ) error ) {
+	return func( r io.Reader ) error {
+		buf, err := io.ReadAll( r )
+		for _, line := range strings.Split( strings.TrimSpace( string( buf ) ), "\n" ) {
+			if kv := strings.SplitN( line, "=", 2 ); len( kv ) == 2 {
+				m[kv[0]] = kv[1]
+			}
+		}
+		return err
+	}
+}
+
+func apply_state( m map[string]string ) ( func( change []byte ) error ) {
+	return func( change []byte ) error {
+		kv := strings.SplitN( string( change ), "=", 2 )
+		m[kv[0]] = kv[1]
+		return nil
+	}
+}
+
+func TestDelta( t *testing.T ) {
+	path := filepath.Join( t.TempDir(), "state" )
+	state := map[string]string{ "a": "1", "b": "2" }
+
+	c := chkpt.Mk_chkpt( path, 2, 3 )
+	c.Set_delta()
+	c.Set_compression( chkpt.COMP_GZIP )
+	if err := c.Create(); err != nil || ! c.Is_full() {
+		t.Fatalf( "first checkpoint was not a full snapshot: %v", err )
+	}
+	for k, v := range state {
+		fmt.Fprintf( c, "%s=%s\n", k, v )
+	}
+	if err := c.Write_delta( []byte( "x=1" ) ); err == nil {
+		t.Errorf( "write delta to a full snapshot did not fail" )
+	}
+	c.Close()
+
+	names := make( []string, 0 )
+	for i := 0; i < 3; i++ {
+		c.Create()
+		if c.Is_full() {
+			t.Fatalf( "checkpoint %d should have been a delta", i )
+		}
+		for j := 0; j < 2; j++ {
+			k := fmt.Sprintf( "k%d", i * 2 + j )
+			state[k] = fmt.Sprintf( "%d", i )
+			c.Write_delta( []byte( k + "=" + state[k] ) )
+		}
+		state["a"] = fmt.Sprintf( "changed%d", i )
+		c.Write_delta( []byte( "a=" + state["a"] ) )
+		fname, err := c.Close()
+		if err != nil {
+			t.Fatalf( "delta close failed: %s", err )
+		}
+		names = append( names, fname )
+	}
+
+	got := make( map[string]string )
+	snap, n, err := chkpt.Replay( path, load_state( got ), apply_state( got ) )
+	if err != nil || n != 9 || fmt.Sprintf( "%v", got ) != fmt.Sprintf( "%v", state ) {
+		t.Errorf( "replay of %s with %d changes did not rebuild state: %v: %v", snap, n, got, err )
+	}
+
+	os.WriteFile( names[1], []byte( "corrupt" ), 0644 )		// chain stops before the second delta
+	got = make( map[string]string )
+	if _, n, err = chkpt.Replay( path, load_state( got ), apply_state( got ) ); err != nil || n != 3 || got["a"] != "changed0" || got["k2"] != "" {
+		t.Errorf( "replay with a corrupt delta applied %d changes: %v: %v", n, got, err )
+	}
+
+	c = chkpt.Mk_chkpt( path, 2, 3 )						// restart must begin with a full snapshot
+	c.Set_delta()
+	if c.Create(); ! c.Is_full() {
+		t.Errorf( "first checkpoint after restart is not a full snapshot" )
+	}
+	fmt.Fprintf( c, "a=restarted\n" )
+	c.Close()
+	c.Create()
+	c.Write_delta( []byte( "z=26" ) )
+	c.Close()
+
+	got = make( map[string]string )
+	if _, n, err = chkpt.Replay( path, load_state( got ), apply_state( got ) ); err != nil || n != 1 || len( got ) != 2 || got["z"] != "26" {
+		t.Errorf( "replay after restart applied %d changes: %v: %v", n, got, err )
+	}
+}
+
+type host_rec struct {
+	Name	string
+	Port	int
+}
+
+/*
+	A user codec: values are strings written upper case.
+*/
+type upper_codec struct{}
+
+func (upper_codec) Encode( v interface{} ) ( []byte, error ) {
+	return []byte( strings.ToUpper( v.( string ) ) ), nil
+}
+
+func (upper_codec) Decode( b []byte, v interface{} ) ( error ) {
+	*(v.( *string )) = string( b )
+	return nil
+}
+
+func TestRecords( t *testing.T ) {
+	const (
+		REC_HOST uint16 = iota + 1
+		REC_NOTE
+	)
+
+	for _, codec := range []chkpt.Codec{ chkpt.Json_codec, chkpt.Gob_codec } {
+		path := filepath.Join( t.TempDir(), "recs" )
+		c := chkpt.Mk_chkpt( path, 3, 5 )
+		c.Add_md5()
+		c.Create()
+		rw := chkpt.Mk_record_writer( c, codec )
+		for i := 0; i < 3; i++ {
+			if err := rw.Put( REC_HOST, &host_rec{ Name: fmt.Sprintf( "host%d", i ), Port: 4000 + i } ); err != nil {
+				t.Fatalf( "put failed: %s", err )
+			}
+		}
+		rw.Put( REC_NOTE, "last" )
+		if _, err := c.Close(); err != nil {
+			t.Fatalf( "close failed: %s", err )
+		}
+
+		r, _, err := chkpt.Open_latest( path )
+		if err != nil {
+			t.Fatalf( "open latest failed: %s", err )
+		}
+		rr := chkpt.Mk_record_reader( r, codec )
+		hosts := 0
+		note := ""
+		for rr.Next() {
+			switch rr.Type() {
+				case REC_HOST:
+					h := &host_rec{}
+					if err = rr.Decode( h ); err != nil || h.Port != 4000 + hosts {
+						t.Errorf( "bad host record: %v %v", h, err )
+					}
+					hosts++
+
+				case REC_NOTE:
+					rr.Decode( &note )
+			}
+		}
+		r.Close()
+		if rr.Err() != nil || rr.Torn() || hosts != 3 || note != "last" {
+			t.Errorf( "records not read back: hosts=%d note=%s err=%v torn=%v", hosts, note, rr.Err(), rr.Torn() )
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	rw := chkpt.Mk_record_writer( buf, upper_codec{} )
+	sizes := make( []int, 0 )
+	for _, s := range []string{ "one", "two", "three" } {
+		rw.Put( REC_NOTE, s )
+		sizes = append( sizes, buf.Len() )
+	}
+	all := buf.Bytes()
+
+	rr := chkpt.Mk_record_reader( bytes.NewReader( all[:len( all ) - 2] ), upper_codec{} )	// torn final record
+	got := make( []string, 0 )
+	for rr.Next() {
+		s := ""
+		rr.Decode( &s )
+		got = append( got, s )
+	}
+	if rr.Err() != nil || ! rr.Torn() || strings.Join( got, "," ) != "ONE,TWO" {
+		t.Errorf( "torn final record not skipped: %v err=%v torn=%v", got, rr.Err(), rr.Torn() )
+	}
+
+	bad := append( []byte{}, all... )
+	bad[len( bad ) - 1] ^= 0xff												// crc failure on the last record is also torn
+	rr = chkpt.Mk_record_reader( bytes.NewReader( bad ), upper_codec{} )
+	for rr.Next() {}
+	if rr.Err() != nil || ! rr.Torn() {
+		t.Errorf( "bad final record not treated as torn: err=%v", rr.Err() )
+	}
+
+	bad = append( []byte{}, all... )
+	bad[sizes[0] - 1] ^= 0xff												// corrupt first record; more follows
+	rr = chkpt.Mk_record_reader( bytes.NewReader( bad ), upper_codec{} )
+	if rr.Next() || rr.Err() == nil {
+		t.Errorf( "corrupt record followed by others was not an error" )
+	}
+	bad = []byte{ 0x3f, 0xff, 0xff, 0xff, 0, 0, 0, 0, 'x' }					// corrupt length near the limit; stream is short
+	var before, after runtime.MemStats
+	runtime.ReadMemStats( &before )
+	rr = chkpt.Mk_record_reader( bytes.NewReader( bad ), upper_codec{} )
+	for rr.Next() {}
+	runtime.ReadMemStats( &after )
+	if ! rr.Torn() {
+		t.Errorf( "short frame with a large length not treated as torn: err=%v", rr.Err() )
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1 << 20 {
+		t.Errorf( "reading a frame with a corrupt length allocated %d bytes", n )
+	}
+}
+
+func TestLocking( t *testing.T ) {
+	path := filepath.Join( t.TempDir(), "locked" )
+
+	c, err := chkpt.Mk_chkpt_locked( path, 3, 5 )
+	if err != nil {
+		t.Fatalf( "first chkpt did not get the lock: %s", err )
+	}
+
+	_, err = chkpt.Mk_chkpt_locked( path, 3, 5 )				// flock conflicts between open files, even in one process
+	var le *chkpt.Lock_error
+	if ! errors.As( err, &le ) || le.Pid != os.Getpid() {
+		t.Errorf( "expected lock error naming our pid, got: %v", err )
+	} else {
+		fmt.Fprintf( os.Stderr, "lock error (expected): %s\n", err )
+	}
+
+	uc := chkpt.Mk_chkpt( filepath.Join( filepath.Dir( path ), "unlocked" ), 3, 5 )		// without the lock many may be made for a path
+	uc2 := chkpt.Mk_chkpt( filepath.Join( filepath.Dir( path ), "unlocked" ), 3, 5 )
+	if uc.Create() != nil || uc2.Create() != nil {
+		t.Errorf( "unlocked chkpts for the same path could not both create" )
+	}
+	uc.Close()
+	uc2.Close()
+
+	c.Create()
+	rw := chkpt.Mk_record_writer( c, chkpt.Json_codec )
+	wg := sync.WaitGroup{}
+	for g := 0; g < 8; g++ {
+		wg.Add( 1 )
+		go func( g int ) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if i % 2 == 0 {
+					rw.Put( uint16( g ), &host_rec{ Name: strings.Repeat( "x", i ), Port: i } )
+				} else {
+					fmt.Fprintf( c, "" )					// a zero length raw write must be harmless
+				}
+			}
+		}( g )
+	}
+	wg.Wait()
+	if _, err = c.Close(); err != nil {
+		t.Fatalf( "close failed: %s", err )
+	}
+
+	r, _, err := chkpt.Open_latest( path )
+	if err != nil {
+		t.Fatalf( "open latest failed: %s", err )
+	}
+	rr := chkpt.Mk_record_reader( r, chkpt.Json_codec )
+	n := 0
+	for rr.Next() {
+		h := &host_rec{}
+		if rr.Decode( h ) != nil || len( h.Name ) != h.Port {
+			t.Errorf( "record %d was damaged by concurrent writes", n )
+		}
+		n++
+	}
+	r.Close()
+	if rr.Err() != nil || n != 800 {
+		t.Errorf( "expected 800 records, read %d: %v", n, rr.Err() )
+	}
+
+	c.Release()
+	if err = c.Create(); err == nil {
+		t.Errorf( "create after release did not fail" )
+	}
+	c2, err := chkpt.Mk_chkpt_locked( path, 3, 5 )
+	if err != nil {
+		t.Fatalf( "lock after the other released it failed: %s", err )
+	}
+	if err = c2.Create(); err != nil {
+		t.Errorf( "create after the other released the lock failed: %s", err )
+	}
+	c2.Release()
+}
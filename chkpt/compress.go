@@ -0,0 +1,204 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	compress
+	Abstract:	Compression and digest algorithms which can be selected for a
+				checkpoint. Both are recorded in the final file name (compression
+				as a suffix, the digest by its length) so that a reader can
+				determine how to read and verify a file from its name alone.
+
+				Gzip and zstd are built in; the zstd implementation is in zstd*.go
+				as the standard library has none.
+	Date:		18 October 2026
+	Author:		agent
+
+	Mods:		18 Oct 2026 - Zstd is provided rather than left to the application.
+*/
+
+package chkpt
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	COMP_NONE	string = ""				// no compression
+	COMP_GZIP	string = "gzip"			// gzip; built in
+	COMP_ZSTD	string = "zstd"			// zstd; built in
+
+	DIGEST_MD5		string = "md5"
+	DIGEST_SHA256	string = "sha256"
+)
+
+/*
+	A compression algorithm: the suffix added to the file name and functions to wrap
+	a writer and a reader.
+*/
+type compressor struct {
+	suffix	string
+	writer	func( io.Writer ) ( io.WriteCloser, error )
+	reader	func( io.Reader ) ( io.ReadCloser, error )
+}
+
+var (
+	comp_lock	sync.RWMutex
+	compressors = map[string]*compressor {
+		COMP_GZIP: &compressor {
+			suffix: ".gz",
+			writer: func( w io.Writer ) ( io.WriteCloser, error ) { return gzip.NewWriter( w ), nil },
+			reader: func( r io.Reader ) ( io.ReadCloser, error ) { return gzip.NewReader( r ) },
+		},
+		COMP_ZSTD: &compressor {
+			suffix: ".zst",
+			writer: mk_zstd_writer,
+			reader: mk_zstd_reader,
+		},
+	}
+
+	digests = map[string]func() hash.Hash {
+		DIGEST_MD5:		md5.New,
+		DIGEST_SHA256:	sha256.New,
+	}
+)
+
+/*
+	Register_compressor adds a compression algorithm, or replaces an existing one, which
+	can then be selected with Set_compression() and is recognised by the reader.  The
+	suffix (e.g. ".xz") is added to the final file name and must be unique. A built in
+	algorithm can be replaced, for instance to use a zstd implementation which gives
+	better compression:
+
+		chkpt.Register_compressor( chkpt.COMP_ZSTD, ".zst",
+			func( w io.Writer ) ( io.WriteCloser, error ) { return zstd.NewWriter( w ) },
+			func( r io.Reader ) ( io.ReadCloser, error ) {
+				d, err := zstd.NewReader( r )
+				if err != nil {
+					return nil, err
+				}
+				return d.IOReadCloser(), nil
+			} )
+*/
+func Register_compressor( name string, suffix string, writer func( io.Writer ) ( io.WriteCloser, error ), reader func( io.Reader ) ( io.ReadCloser, error ) ) ( error ) {
+	if name == COMP_NONE || ! strings.HasPrefix( suffix, "." ) || writer == nil || reader == nil {
+		return fmt.Errorf( "compressor must have a name, a suffix starting with a dot, a writer and a reader" )
+	}
+
+	comp_lock.Lock()
+	defer comp_lock.Unlock()
+
+	for cname, comp := range compressors {
+		if cname != name && comp.suffix == suffix {
+			return fmt.Errorf( "suffix %s is already used by %s", suffix, cname )
+		}
+	}
+
+	compressors[name] = &compressor{ suffix: suffix, writer: writer, reader: reader }
+	return nil
+}
+
+/*
+	Return the compressor with the name, or an error if it is not registered.
+*/
+func get_compressor( name string ) ( *compressor, error ) {
+	comp_lock.RLock()
+	defer comp_lock.RUnlock()
+
+	if comp := compressors[name]; comp != nil {
+		return comp, nil
+	}
+
+	return nil, fmt.Errorf( "unknown compression: %s", name )
+}
+
+/*
+	Return the compressor for a file name suffix (including the dot), or nil.
+*/
+func suffix_compressor( suffix string ) ( *compressor ) {
+	comp_lock.RLock()
+	defer comp_lock.RUnlock()
+
+	for _, comp := range compressors {
+		if comp.suffix == suffix {
+			return comp
+		}
+	}
+
+	return nil
+}
+
+/*
+	Return a new hash for the digest found in a file name. The algorithm is determined
+	by the length of the hex string (32 for md5, 64 for sha256).
+*/
+func name_digest( hex_digest string ) ( hash.Hash, error ) {
+	for _, mk := range digests {
+		if h := mk(); h.Size() * 2 == len( hex_digest ) {
+			return h, nil
+		}
+	}
+
+	return nil, fmt.Errorf( "unrecognised digest in name: %s", hex_digest )
+}
+
+/*
+	Set_compression causes checkpoints to be compressed as they are written using the
+	named algorithm (COMP_GZIP, COMP_ZSTD, or one added with Register_compressor()).
+	The algorithm's suffix is added to the final name (e.g. <path>_b3-<md5>.ckpt.gz).
+	COMP_NONE turns compression off. The change applies to the next checkpoint created.
+*/
+func (c *Chkpt) Set_compression( name string ) ( error ) {
+	if name == COMP_NONE {
+		c.comp = nil
+		return nil
+	}
+
+	comp, err := get_compressor( name )
+	if err != nil {
+		return err
+	}
+
+	c.comp = comp
+	return nil
+}
+
+/*
+	Set_digest selects the digest algorithm (DIGEST_MD5 or DIGEST_SHA256) and, like
+	Add_md5(), causes the digest to be added to the final name. The digest is computed
+	over the bytes written to the file (after compression) so it can be checked with
+	the usual command line tools (e.g. sha256sum).
+*/
+func (c *Chkpt) Set_digest( name string ) ( error ) {
+	if digests[name] == nil {
+		return fmt.Errorf( "unknown digest: %s", name )
+	}
+
+	c.digest = name
+	c.add_digest = true
+	return nil
+}
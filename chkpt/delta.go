@@ -0,0 +1,255 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	delta
+	Abstract:	Incremental checkpoints. In delta mode the 'a' tumbler files are full
+				snapshots written by the user as usual, and each 'b' file holds the
+				changes made since the previous checkpoint as a series of framed
+				records.  The first record in a 'b' file is a header which names
+				the full snapshot the deltas apply to, and the position of the file
+				in the chain of deltas for that snapshot:
+					delta <seq> <snapshot-file-basename>
+
+				Replay() rebuilds the state by loading the newest good full snapshot
+				and then applying the deltas in its chain in order.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package chkpt
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	Set_delta puts the checkpoint environment into delta mode. The digest is also added
+	to names (md5 unless Set_digest() was used to pick another algorithm) so that each
+	delta file can name its snapshot unambiguously.
+
+	After Create(), Is_full() indicates whether the checkpoint is a full snapshot, which
+	is written with Write() or Write_string() as in normal mode, or a delta file whose
+	changes are written with Write_delta().  The first checkpoint created, including the
+	first after the programme restarts, is always a full snapshot so that a chain of
+	deltas never depends on state held by an earlier process.
+*/
+func (c *Chkpt) Set_delta( ) {
+	c.delta = true
+	if ! c.add_digest {
+		c.Add_md5( )
+	}
+}
+
+/*
+	Is_full returns true if the open checkpoint is a full snapshot. When not in delta
+	mode every checkpoint is full.
+*/
+func (c *Chkpt) Is_full( ) ( bool ) {
+	return ! c.delta || c.full
+}
+
+/*
+	Write_delta writes one change to the open delta checkpoint as a framed record. It is
+	an error to call this when the open checkpoint is a full snapshot.
+*/
+func (c *Chkpt) Write_delta( b []byte ) ( error ) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.output == nil {
+		return fmt.Errorf( "no checkpoint is open" )
+	}
+	if c.Is_full() {
+		return fmt.Errorf( "open checkpoint is a full snapshot, not a delta" )
+	}
+
+	if err := write_frame( c.wr, b ); err != nil {
+		c.errors = true
+		return err
+	}
+
+	return nil
+}
+
+/*
+	Called by Create() once the file is open; for a delta file the header record is
+	written.
+*/
+func (c *Chkpt) delta_open( tch string ) ( error ) {
+	if ! c.delta {
+		return nil
+	}
+
+	c.full = tch == "a"
+	if c.full {
+		c.seq = 0
+		return nil
+	}
+
+	c.seq++
+	return write_frame( c.wr, []byte( fmt.Sprintf( "delta %d %s", c.seq, c.base ) ) )
+}
+
+/*
+	Called when Close() finishes. Once a full snapshot is safely written it becomes the
+	base for the deltas which follow. If either a snapshot or a delta fails, the chain
+	is broken and the next checkpoint must be a full snapshot.
+*/
+func (c *Chkpt) delta_closed( final_name string, err error ) {
+	if ! c.delta {
+		return
+	}
+
+	if err != nil {
+		c.base = ""
+		return
+	}
+
+	if c.full {
+		c.base = filepath.Base( final_name )
+	}
+}
+
+/*
+	Read and parse the header record of a delta file.
+*/
+func read_delta_hdr( r io.Reader ) ( seq int, base string, err error ) {
+	hdr, err := read_frame( r )
+	if err != nil {
+		return 0, "", err
+	}
+
+	fields := strings.SplitN( string( hdr ), " ", 3 )
+	if len( fields ) != 3 || fields[0] != "delta" {
+		return 0, "", fmt.Errorf( "not a delta checkpoint" )
+	}
+	if seq, err = strconv.Atoi( fields[1] ); err != nil {
+		return 0, "", fmt.Errorf( "bad delta sequence number: %s", fields[1] )
+	}
+
+	return seq, fields[2], nil
+}
+
+/*
+	Replay rebuilds state from the checkpoints for the path.  The newest full snapshot
+	which can be verified is found, and load is called with a reader of its contents.
+	Then the delta files in the snapshot's chain are read in order and apply is called
+	with each change written by Write_delta().  The chain stops at the first delta
+	file which is missing or corrupt as the deltas after it can't be applied.
+
+	Only closed delta files are replayed.  A delta is written under a .tmp name, through
+	a buffer (and the compressor), and gets its digest on Close(), so the changes
+	written since the last Close() are lost if the programme stops.  Close and create
+	deltas as often as needed to keep that loss acceptable.
+
+	The name of the snapshot and the number of changes applied are returned.  If load or
+	apply return an error, or a verified delta file can't be read, Replay stops and
+	returns it.
+*/
+func Replay( path string, load func( r io.Reader ) error, apply func( change []byte ) error ) ( snapshot string, nchanges int, err error ) {
+	files, err := list_chkpts( path )
+	if err != nil {
+		return "", 0, err
+	}
+	digests_used := uses_digests( files )
+
+	var base *ckpt_file
+	for _, cf := range files {
+		if cf.tch == "a" && cf.usable( digests_used ) == nil {
+			base = cf
+			break
+		}
+	}
+	if base == nil {
+		return "", 0, fmt.Errorf( "no usable full snapshot for %s", path )
+	}
+
+	r, err := base.open()
+	if err != nil {
+		return "", 0, err
+	}
+	err = load( r )
+	r.Close()
+	if err != nil {
+		return base.name, 0, err
+	}
+
+	chain := make( map[int]*ckpt_file )
+	bname := filepath.Base( base.name )
+	for _, cf := range files {
+		if cf.tch != "b" || cf.usable( digests_used ) != nil {
+			continue
+		}
+
+		r, oerr := cf.open()
+		if oerr != nil {
+			continue
+		}
+		seq, hbase, herr := read_delta_hdr( r )
+		r.Close()
+		if herr == nil && hbase == bname && chain[seq] == nil {
+			chain[seq] = cf
+		}
+	}
+
+	seqs := make( []int, 0, len( chain ) )
+	for seq := range chain {
+		seqs = append( seqs, seq )
+	}
+	sort.Ints( seqs )
+
+	for i, seq := range seqs {
+		if seq != i + 1 {									// gap; nothing after it can be applied
+			break
+		}
+
+		r, err := chain[seq].open()
+		if err != nil {
+			break
+		}
+		read_delta_hdr( r )
+
+		for {
+			change, rerr := read_frame( r )
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {								// the digest matched, so it wasn't torn; not something we wrote
+				r.Close()
+				return base.name, nchanges, fmt.Errorf( "unable to read delta %s: %s", chain[seq].name, rerr )
+			}
+			if err = apply( change ); err != nil {
+				r.Close()
+				return base.name, nchanges, err
+			}
+			nchanges++
+		}
+		r.Close()
+	}
+
+	return base.name, nchanges, nil
+}
@@ -0,0 +1,46 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Gives the tests in chkpt_test access to internals.
+*/
+package chkpt
+
+import (
+	"io"
+)
+
+/*
+	Set_fail_hook sets the function called at each step of writing a checkpoint; nil
+	removes it.
+*/
+func Set_fail_hook( f func( step string ) error ) {
+	fail_hook = f
+}
+
+/*
+	Give the tests the zstd writer and reader directly.
+*/
+func Mk_zstd_writer( w io.Writer ) ( io.WriteCloser, error ) {
+	return mk_zstd_writer( w )
+}
+
+func Mk_zstd_reader( r io.Reader ) ( io.ReadCloser, error ) {
+	return mk_zstd_reader( r )
+}
@@ -0,0 +1,96 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	frame
+	Abstract:	Frames a block of bytes so that it can be read back from a stream
+				and checked: a 4 byte length and a 4 byte CRC-32 (IEEE) of the
+				payload, both big endian, followed by the payload.
+	Date:		18 October 2026
+	Author:		agent
+
+	Mods:		18 Oct 2026 - The payload buffer grows as data is read so that a corrupt
+					length doesn't allocate up to max_frame bytes.
+*/
+
+package chkpt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	frame_hdr_len	int = 8
+	max_frame		int = 1 << 30		// larger is assumed to be a corrupt length
+)
+
+/*
+	Write the payload as one frame.
+*/
+func write_frame( w io.Writer, payload []byte ) ( error ) {
+	if len( payload ) > max_frame {
+		return fmt.Errorf( "frame too large: %d bytes", len( payload ) )
+	}
+
+	buf := make( []byte, frame_hdr_len + len( payload ) )			// one write so concurrent writers can't interleave
+	binary.BigEndian.PutUint32( buf, uint32( len( payload ) ) )
+	binary.BigEndian.PutUint32( buf[4:], crc32.ChecksumIEEE( payload ) )
+	copy( buf[frame_hdr_len:], payload )
+
+	_, err := w.Write( buf )
+	return err
+}
+
+/*
+	Read the next frame returning the payload. At the end of the stream io.EOF is
+	returned. If the stream ends part way through a frame (a torn write)
+	io.ErrUnexpectedEOF is returned, and an error is returned if the CRC doesn't match.
+*/
+func read_frame( r io.Reader ) ( payload []byte, err error ) {
+	hdr := make( []byte, frame_hdr_len )
+	if _, err = io.ReadFull( r, hdr ); err != nil {
+		return nil, err											// EOF if nothing read, ErrUnexpectedEOF if partial
+	}
+
+	flen := binary.BigEndian.Uint32( hdr )
+	if int64( flen ) > int64( max_frame ) {
+		return nil, fmt.Errorf( "frame length is not sane: %d", flen )
+	}
+
+	buf := bytes.NewBuffer( make( []byte, 0, min( int( flen ), 64 * 1024 ) ) )		// grows with what is read, not with a length that may be corrupt
+	if _, err = io.CopyN( buf, r, int64( flen ) ); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	payload = buf.Bytes()
+
+	if crc := crc32.ChecksumIEEE( payload ); crc != binary.BigEndian.Uint32( hdr[4:] ) {
+		return nil, fmt.Errorf( "frame crc mismatch" )
+	}
+
+	return payload, nil
+}
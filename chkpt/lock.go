@@ -0,0 +1,148 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	lock
+	Abstract:	The lock file which prevents two processes from writing checkpoints
+				for the same path. Locking is optional: Mk_chkpt_locked() takes the
+				lock, which is held until Release() is called or the process exits.
+
+				The lock is an flock(2) on <path>.lock; on systems without flock
+				Mk_chkpt_locked() returns an error rather than go without the lock.
+				As flock conflicts between open files, a second locked environment
+				for the same path in the same process is also refused.
+	Date:		18 October 2026
+	Author:		agent
+
+	Mods:		18 Oct 2026 - The counter file is read once the lock is held.
+*/
+package chkpt
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+	Lock_error is returned when another process holds the lock for the checkpoint path.
+*/
+type Lock_error struct {
+	Fname	string			// the lock file
+	Pid		int				// process holding the lock as recorded in the file; 0 if not known
+}
+
+func (e *Lock_error) Error( ) ( string ) {
+	if e.Pid > 0 {
+		return fmt.Sprintf( "checkpoints are locked by another process (pid %d): %s", e.Pid, e.Fname )
+	}
+	return fmt.Sprintf( "checkpoints are locked by another process: %s", e.Fname )
+}
+
+/*
+	Open the lock file (<path>.lock) and lock it.  On success our pid is written to the
+	file so that a process which finds it locked can say who has it.
+*/
+func (c *Chkpt) lock( ) ( error ) {
+	fname := *c.path + ".lock"
+	f, err := os.OpenFile( fname, os.O_RDWR | os.O_CREATE, 0644 )
+	if err != nil {
+		return fmt.Errorf( "unable to open lock file: %s", err )
+	}
+
+	locked, err := flock( f )
+	if err != nil {
+		le := &Lock_error{ Fname: fname }
+		if locked {
+			buf, _ := io.ReadAll( io.LimitReader( f, 32 ) )
+			le.Pid, _ = strconv.Atoi( strings.TrimSpace( string( buf ) ) )
+		}
+		f.Close()
+
+		if locked {
+			return le
+		}
+		return fmt.Errorf( "unable to lock %s: %s", fname, err )
+	}
+
+	f.Truncate( 0 )
+	f.WriteAt( []byte( fmt.Sprintf( "%d\n", os.Getpid() ) ), 0 )
+	c.lock_file = f
+
+	return nil
+}
+
+/*
+	Mk_chkpt_locked creates a checkpointing environment as Mk_chkpt() does, and takes an
+	exclusive lock on <path>.lock which is held until Release() is called (or the process
+	exits).  If the lock is held by another process (or another locked environment in this
+	process) a *Lock_error is returned; if locking isn't possible, or isn't supported on
+	this system, another error is returned.  No environment is returned with an error.
+*/
+func Mk_chkpt_locked( path string, amax int, bmax int ) ( c *Chkpt, err error ) {
+	c = &Chkpt {
+		amax:	amax,
+		bmax:	bmax,
+		path:	&path,
+	}
+	if err = c.lock( ); err != nil {
+		return nil, err
+	}
+	c.load_tumblers( )						// not before: the previous holder may still have been updating them
+
+	return c, nil
+}
+
+/*
+	Lock_err returns nil unless the environment was locked and the lock has since been
+	released; Create() returns the same error.
+*/
+func (c *Chkpt) Lock_err( ) ( error ) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.lock_err
+}
+
+/*
+	Release closes any open checkpoint (as Close() does) and, if the environment was made
+	with Mk_chkpt_locked(), releases the lock so that another process (or another Chkpt in
+	this process) may use the path. No checkpoints can be created after the lock is
+	released.  Without a lock, Release() is the same as Close().
+*/
+func (c *Chkpt) Release( ) ( err error ) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.output != nil {
+		_, err = c.close()
+	}
+
+	if c.lock_file != nil {
+		c.lock_file.Close()					// closing drops the flock
+		c.lock_file = nil
+		c.lock_err = fmt.Errorf( "checkpoint lock has been released: %s.lock", *c.path )
+	}
+
+	return err
+}
@@ -0,0 +1,42 @@
+// vi: sw=4 ts=4:
+//go:build !unix
+
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	lock_other
+	Abstract:	Systems without flock(2): locking isn't supported and an error is
+				returned so that Mk_chkpt_locked() fails rather than silently
+				going without the lock.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package chkpt
+
+import (
+	"errors"
+	"os"
+)
+
+func flock( f *os.File ) ( locked bool, err error ) {
+	return false, errors.ErrUnsupported
+}
@@ -0,0 +1,49 @@
+// vi: sw=4 ts=4:
+//go:build unix
+
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	lock_unix
+	Abstract:	Advisory file locking using flock(2).
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package chkpt
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+	Take an exclusive lock on the open file without waiting. Locked is true if another
+	process holds the lock.
+*/
+func flock( f *os.File ) ( locked bool, err error ) {
+	err = syscall.Flock( int( f.Fd() ), syscall.LOCK_EX | syscall.LOCK_NB )
+	if err == syscall.EWOULDBLOCK {
+		return true, err
+	}
+
+	return false, err
+}
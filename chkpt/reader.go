@@ -0,0 +1,418 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	reader
+	Abstract:	Finds the checkpoint files for a path, orders them newest first using
+				the tumbler values in the counter file, and opens the newest one which
+				is not corrupt.
+	Date:		18 October 2026
+	Author:		agent
+
+	Mods:		18 Oct 2026 - Handle compressed files and sha256 digests.
+				18 Oct 2026 - A file left from an earlier cycle in the slot named by the
+					counter is no longer taken as the newest.
+*/
+
+package chkpt
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+/*
+	Describes one checkpoint file found on disk.
+*/
+type ckpt_file struct {
+	name	string				// full path
+	tch		string				// tumbler letter (a or b)
+	tval	int					// tumbler value
+	digest	string				// digest from the name; empty if the name has none
+	comp	*compressor			// compression indicated by the suffix; nil if none
+	mtime	time.Time
+}
+
+/*
+	Find all checkpoint files for the path. Files are matched on the name created by
+	Create() and Close(): <path>_Xnn.ckpt or <path>_Xnn-<digest>.ckpt, either of which
+	may have a compression suffix.  Files with a suffix which isn't a known compression
+	(e.g. .tmp) are ignored.
+*/
+func find_chkpts( path string ) ( files []*ckpt_file, err error ) {
+	re, err := regexp.Compile( "^" + regexp.QuoteMeta( filepath.Base( path ) ) + "_([ab])([0-9]+)(-([0-9a-f]+))?\\.ckpt(\\.[a-z0-9]+)?$" )
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir( path )
+	entries, err := os.ReadDir( dir )
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		m := re.FindStringSubmatch( e.Name() )
+		if m == nil || ! e.Type().IsRegular() {
+			continue
+		}
+
+		cf := &ckpt_file {
+			name:	filepath.Join( dir, e.Name() ),
+			tch:	m[1],
+			digest:	m[4],
+		}
+		if m[5] != "" {
+			if cf.comp = suffix_compressor( m[5] ); cf.comp == nil {
+				continue
+			}
+		}
+		cf.tval, _ = strconv.Atoi( m[2] )
+		if fi, ierr := e.Info(); ierr == nil {
+			cf.mtime = fi.ModTime()
+		}
+
+		files = append( files, cf )
+	}
+
+	return files, nil
+}
+
+/*
+	Return the position of the file in the tumbler sequence counting back from the
+	current tumbler values; 0 is the newest.  The current cycle of b files (b1 through
+	bval) is newer than a<aval>, which is newer than the b files left from the previous
+	cycle (bval+1 up to the largest present), which are newer than the older a files.
+	Bmax and amax are the largest tumbler values present as the real rollover points
+	aren't known to a reader.
+*/
+func tumbler_rank( cf *ckpt_file, aval int, bval int, amax int, bmax int ) ( int ) {
+	if cf.tch == "b" {
+		if cf.tval <= bval {
+			return bval - cf.tval						// current cycle
+		}
+		return bval + 1 + (bmax - cf.tval)				// previous cycle, after a<aval>
+	}
+
+	back := aval - cf.tval								// how many a files back from the current one
+	if back < 0 {
+		back += amax
+	}
+	if back == 0 {
+		return bval
+	}
+
+	return bval + 1 + bmax + back
+}
+
+/*
+	Return the tumbler values one checkpoint before aval/bval: the previous b file, or
+	the last b file of the previous cycle when the current file is an a file.
+*/
+func prev_tumblers( aval int, bval int, amax int, bmax int ) ( int, int ) {
+	if bval > 0 {
+		return aval, bval - 1
+	}
+
+	aval--
+	if aval < 1 {
+		aval = amax
+	}
+	return aval, bmax
+}
+
+/*
+	Rank the newest file in each tumbler slot by its position in the tumbler sequence;
+	other files get -1. Files must be ordered newest first.
+*/
+func rank_chkpts( files []*ckpt_file, aval int, bval int, amax int, bmax int, have_digests bool ) ( rank map[*ckpt_file]int ) {
+	seen := make( map[string]bool )
+	rank = make( map[*ckpt_file]int, len( files ) )
+	for _, cf := range files {								// files are newest first, so the first in each slot is the newest
+		slot := fmt.Sprintf( "%s%d", cf.tch, cf.tval )
+		if seen[slot] || (have_digests && cf.digest == "") {		// older file in the slot, or one that was never closed
+			rank[cf] = -1
+			continue
+		}
+		seen[slot] = true
+		rank[cf] = tumbler_rank( cf, aval, bval, amax, bmax )
+	}
+
+	return rank
+}
+
+/*
+	Order the files newest first. If the tumbler values are known, the newest file for
+	each tumbler slot is ordered by its position in the tumbler sequence and the older
+	files in the same slot (left behind when the md5 is added to the name) follow, newest
+	first.  When md5 names are in use, a file without one was never closed and is put
+	with the older files rather than taking the slot.  Without tumbler values the files
+	are ordered by modification time.
+
+	The counter file is saved when a checkpoint is created, before it is written, so
+	while a checkpoint is being written (or if writing it was cut short) the slot the
+	counter names still holds the file from an earlier cycle.  That file is older than
+	the others and is recognised by its modification time; the files are then ranked
+	from the checkpoint before the one named by the counter.
+*/
+func order_chkpts( files []*ckpt_file, aval int, bval int, have_tumblers bool ) {
+	by_time := func( i, j int ) bool {
+		if files[i].mtime.Equal( files[j].mtime ) {
+			return files[i].name > files[j].name
+		}
+		return files[i].mtime.After( files[j].mtime )
+	}
+
+	sort.SliceStable( files, by_time )
+	if ! have_tumblers {
+		return
+	}
+
+	amax := 0
+	bmax := 0
+	have_digests := false
+	for _, cf := range files {
+		have_digests = have_digests || cf.digest != ""
+		if cf.tch == "a" && cf.tval > amax {
+			amax = cf.tval
+		}
+		if cf.tch == "b" && cf.tval > bmax {
+			bmax = cf.tval
+		}
+	}
+
+	rank := rank_chkpts( files, aval, bval, amax, bmax, have_digests )
+
+	var current *ckpt_file									// file in the slot the counter names
+	var newest time.Time									// newest of the other ranked files
+	for _, cf := range files {
+		switch {
+			case rank[cf] == 0:
+				current = cf
+
+			case rank[cf] > 0 && cf.mtime.After( newest ):
+				newest = cf.mtime
+		}
+	}
+	if current != nil && current.mtime.Before( newest ) {		// left from an earlier cycle; counter is ahead of the files
+		aval, bval = prev_tumblers( aval, bval, amax, bmax )
+		rank = rank_chkpts( files, aval, bval, amax, bmax, have_digests )
+	}
+
+	sort.SliceStable( files, func( i, j int ) bool {
+		ri := rank[files[i]]
+		rj := rank[files[j]]
+		switch {
+			case ri < 0 && rj < 0:
+				return false								// keep time order among the left overs
+
+			case ri < 0 || rj < 0:
+				return rj < 0
+
+			default:
+				return ri < rj
+		}
+	} )
+}
+
+/*
+	Compute the digest of the file and compare it with the digest in the name. The
+	algorithm is determined from the length of the digest in the name.
+*/
+func (cf *ckpt_file) verify( ) ( error ) {
+	h, err := name_digest( cf.digest )
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open( cf.name )
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = io.Copy( h, f ); err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString( h.Sum( nil ) ); sum != cf.digest {
+		return fmt.Errorf( "checkpoint is corrupt: %s: computed digest is %s", cf.name, sum )
+	}
+
+	return nil
+}
+
+/*
+	Return true if any of the files has a digest in its name.
+*/
+func uses_digests( files []*ckpt_file ) ( bool ) {
+	for _, cf := range files {
+		if cf.digest != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	Returns nil if the file can be used: it has a digest which matches its contents, or
+	it has no digest and digests are not in use (digests_used is false).
+*/
+func (cf *ckpt_file) usable( digests_used bool ) ( error ) {
+	if cf.digest == "" {
+		if digests_used {
+			return fmt.Errorf( "checkpoint was never closed: %s", cf.name )
+		}
+		return nil
+	}
+
+	return cf.verify()
+}
+
+/*
+	Closes both the decompressing reader and the file.
+*/
+type comp_reader struct {
+	io.ReadCloser
+	f	*os.File
+}
+
+func (cr *comp_reader) Close( ) ( error ) {
+	err := cr.ReadCloser.Close()
+	if ferr := cr.f.Close(); err == nil {
+		err = ferr
+	}
+
+	return err
+}
+
+/*
+	Open the file returning a reader which decompresses if needed.
+*/
+func (cf *ckpt_file) open( ) ( io.ReadCloser, error ) {
+	f, err := os.Open( cf.name )
+	if err != nil || cf.comp == nil {
+		return f, err
+	}
+
+	r, err := cf.comp.reader( f )
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf( "unable to decompress %s: %s", cf.name, err )
+	}
+
+	return &comp_reader{ ReadCloser: r, f: f }, nil
+}
+
+/*
+	Read the tumbler values from the counter file for the path.
+*/
+func path_tumblers( path string ) ( aval int, bval int, err error ) {
+	c := &Chkpt{ path: &path }
+	return c.read_tumblers()
+}
+
+/*
+	List returns the names of the checkpoint files for the path, newest first. Path is
+	the same path given to Mk_chkpt().  The tumbler values in the counter file
+	(<path>.ckpt) are used to determine the order; if the counter file is missing or
+	can't be read, the files are ordered by modification time.
+*/
+func List( path string ) ( names []string, err error ) {
+	files, err := list_chkpts( path )
+	if err != nil {
+		return nil, err
+	}
+
+	names = make( []string, len( files ) )
+	for i, cf := range files {
+		names[i] = cf.name
+	}
+
+	return names, nil
+}
+
+/*
+	Find and order the checkpoint files for the path.
+*/
+func list_chkpts( path string ) ( files []*ckpt_file, err error ) {
+	files, err = find_chkpts( path )
+	if err != nil {
+		return nil, err
+	}
+
+	aval, bval, terr := path_tumblers( path )
+	order_chkpts( files, aval, bval, terr == nil )
+
+	return files, nil
+}
+
+/*
+	Open_latest opens the newest checkpoint file for the path which is not corrupt and
+	returns a reader for it along with its name.  Files are considered in the order
+	given by List(); a file whose name includes a digest (md5 or sha256) is opened only
+	if the digest of its contents matches. A compressed file is decompressed by the
+	returned reader. When some files have a digest in the name, a file without one is
+	assumed to be a checkpoint that was never closed (e.g. the programme crashed while
+	writing it) and is skipped.  When no file has an md5, the newest file is opened
+	as there is no way to verify it.
+
+	An error is returned if no usable checkpoint exists.  The caller must close the reader.
+*/
+func Open_latest( path string ) ( rc io.ReadCloser, fname string, err error ) {
+	files, err := list_chkpts( path )
+	if err != nil {
+		return nil, "", err
+	}
+
+	digests_used := uses_digests( files )
+
+	var last_err error
+	for _, cf := range files {
+		if uerr := cf.usable( digests_used ); uerr != nil {
+			if cf.digest != "" {
+				last_err = uerr
+			}
+			continue
+		}
+
+		r, oerr := cf.open()
+		if oerr != nil {
+			last_err = oerr
+			continue
+		}
+
+		return r, cf.name, nil
+	}
+
+	if last_err != nil {
+		return nil, "", fmt.Errorf( "no usable checkpoint for %s: %s", path, last_err )
+	}
+	return nil, "", fmt.Errorf( "no checkpoint files for %s", path )
+}
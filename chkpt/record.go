@@ -0,0 +1,253 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	record
+	Abstract:	A record layer on top of a checkpoint (or any writer/reader). Each
+				record is a frame (length, CRC-32, payload; see frame.go) whose
+				payload is a 2 byte type tag followed by the value encoded with
+				a codec.  JSON and gob codecs are supplied; a user codec is anything
+				which implements the Codec interface.
+
+				Writing records to a checkpoint:
+					c.Create()
+					rw := chkpt.Mk_record_writer( c, chkpt.Json_codec )
+					rw.Put( REC_HOST, host )
+					c.Close()
+
+				Reading them back:
+					r, _, err := chkpt.Open_latest( path )
+					rr := chkpt.Mk_record_reader( r, chkpt.Json_codec )
+					for rr.Next() {
+						switch rr.Type() {
+							case REC_HOST:
+								h := &Host{}
+								err = rr.Decode( h )
+						}
+					}
+					err = rr.Err()
+
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package chkpt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	rec_tag_len	int = 2
+)
+
+/*
+	Converts values to and from bytes for records.
+*/
+type Codec interface {
+	Encode( v interface{} ) ( []byte, error )
+	Decode( b []byte, v interface{} ) ( error )
+}
+
+type json_codec struct{}
+
+func (json_codec) Encode( v interface{} ) ( []byte, error ) {
+	return json.Marshal( v )
+}
+
+func (json_codec) Decode( b []byte, v interface{} ) ( error ) {
+	return json.Unmarshal( b, v )
+}
+
+/*
+	Each record is encoded on its own so that any record can be decoded without those
+	before it; the gob type information is therefore repeated in each record.
+*/
+type gob_codec struct{}
+
+func (gob_codec) Encode( v interface{} ) ( []byte, error ) {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder( buf ).Encode( v )
+	return buf.Bytes(), err
+}
+
+func (gob_codec) Decode( b []byte, v interface{} ) ( error ) {
+	return gob.NewDecoder( bytes.NewReader( b ) ).Decode( v )
+}
+
+var (
+	Json_codec	Codec = json_codec{}
+	Gob_codec	Codec = gob_codec{}
+)
+
+// ---- writing -------------------------------------------------------------------------
+
+/*
+	Writes records to an underlying writer, usually a Chkpt.
+*/
+type Record_writer struct {
+	w		io.Writer
+	codec	Codec
+}
+
+/*
+	Mk_record_writer creates a record writer which writes to w encoding values with
+	the codec.
+*/
+func Mk_record_writer( w io.Writer, codec Codec ) ( *Record_writer ) {
+	return &Record_writer{ w: w, codec: codec }
+}
+
+/*
+	Put encodes the value and writes it as a record with the type tag. The tag is
+	defined by the user and is returned by the reader so the caller knows what to
+	decode the record into.
+*/
+func (rw *Record_writer) Put( rtype uint16, v interface{} ) ( error ) {
+	b, err := rw.codec.Encode( v )
+	if err != nil {
+		return err
+	}
+
+	return rw.Put_raw( rtype, b )
+}
+
+/*
+	Put_raw writes the bytes as a record with the type tag without encoding them.
+*/
+func (rw *Record_writer) Put_raw( rtype uint16, b []byte ) ( error ) {
+	payload := make( []byte, rec_tag_len + len( b ) )
+	binary.BigEndian.PutUint16( payload, rtype )
+	copy( payload[rec_tag_len:], b )
+
+	return write_frame( rw.w, payload )
+}
+
+// ---- reading -------------------------------------------------------------------------
+
+/*
+	Reads records one at a time in the style of bufio.Scanner.
+*/
+type Record_reader struct {
+	br		*bufio.Reader
+	codec	Codec
+	rtype	uint16
+	data	[]byte
+	err		error
+	torn	bool
+	nrecs	int
+}
+
+/*
+	Mk_record_reader creates a reader which reads records from r, decoding them with
+	the codec.
+*/
+func Mk_record_reader( r io.Reader, codec Codec ) ( *Record_reader ) {
+	return &Record_reader{ br: bufio.NewReader( r ), codec: codec }
+}
+
+/*
+	Next advances to the next record returning false when there are no more or on an
+	error.  A final record which is incomplete, or which fails the CRC check and is the
+	last thing in the stream, is assumed to be a write that was interrupted; it is
+	skipped, Next returns false, Err returns nil and Torn returns true.  A bad record
+	which is followed by more data is corruption and is reported by Err.
+*/
+func (rr *Record_reader) Next( ) ( bool ) {
+	if rr.err != nil || rr.torn {
+		return false
+	}
+
+	payload, err := read_frame( rr.br )
+	if err == nil && len( payload ) < rec_tag_len {
+		err = fmt.Errorf( "record is too short to have a type" )
+	}
+
+	if err != nil {
+		switch {
+			case err == io.EOF:
+				// normal end
+
+			case err == io.ErrUnexpectedEOF:
+				rr.torn = true
+
+			default:
+				if _, perr := rr.br.Peek( 1 ); perr == io.EOF {
+					rr.torn = true
+				} else {
+					rr.err = fmt.Errorf( "record %d: %s", rr.nrecs + 1, err )
+				}
+		}
+		rr.data = nil
+		return false
+	}
+
+	rr.nrecs++
+	rr.rtype = binary.BigEndian.Uint16( payload )
+	rr.data = payload[rec_tag_len:]
+	return true
+}
+
+/*
+	Type returns the type tag of the current record.
+*/
+func (rr *Record_reader) Type( ) ( uint16 ) {
+	return rr.rtype
+}
+
+/*
+	Bytes returns the encoded data of the current record.
+*/
+func (rr *Record_reader) Bytes( ) ( []byte ) {
+	return rr.data
+}
+
+/*
+	Decode decodes the current record into v using the reader's codec.
+*/
+func (rr *Record_reader) Decode( v interface{} ) ( error ) {
+	if rr.data == nil {
+		return fmt.Errorf( "no current record" )
+	}
+
+	return rr.codec.Decode( rr.data, v )
+}
+
+/*
+	Err returns the error which stopped the reader, or nil if it stopped at the end of
+	the stream (including after a torn final record).
+*/
+func (rr *Record_reader) Err( ) ( error ) {
+	return rr.err
+}
+
+/*
+	Torn returns true if the final record was incomplete and was skipped.
+*/
+func (rr *Record_reader) Torn( ) ( bool ) {
+	return rr.torn
+}
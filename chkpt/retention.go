@@ -0,0 +1,262 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	retention
+	Abstract:	Retention policies which decide which checkpoint files are kept, and
+				the Prune function which removes the others.  Needed when the digest
+				is added to the name as files are then never overwritten by the
+				tumbler rotation.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package chkpt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+	What a policy is given about each checkpoint file.
+*/
+type Info struct {
+	Name	string				// full path of the file
+	Mtime	time.Time			// when it was written
+}
+
+/*
+	A retention policy. Keep is given the checkpoint files, newest first, and returns
+	a parallel slice with true for each file that should be kept.
+*/
+type Policy interface {
+	Keep( files []*Info, now time.Time ) ( keep []bool )
+}
+
+// ---- keep last n ---------------------------------------------------------------------
+
+type last_policy struct {
+	n	int
+}
+
+/*
+	Keep_last returns a policy which keeps the n most recent checkpoints.
+*/
+func Keep_last( n int ) ( Policy ) {
+	return &last_policy{ n: n }
+}
+
+func (p *last_policy) Keep( files []*Info, now time.Time ) ( keep []bool ) {
+	keep = make( []bool, len( files ) )
+	for i := 0; i < len( files ) && i < p.n; i++ {
+		keep[i] = true
+	}
+
+	return keep
+}
+
+// ---- keep by age ---------------------------------------------------------------------
+
+type age_policy struct {
+	max_age	time.Duration
+}
+
+/*
+	Keep_age returns a policy which keeps checkpoints written within max_age.
+*/
+func Keep_age( max_age time.Duration ) ( Policy ) {
+	return &age_policy{ max_age: max_age }
+}
+
+func (p *age_policy) Keep( files []*Info, now time.Time ) ( keep []bool ) {
+	keep = make( []bool, len( files ) )
+	for i, f := range files {
+		keep[i] = now.Sub( f.Mtime ) <= p.max_age
+	}
+
+	return keep
+}
+
+// ---- grandfather-father-son ----------------------------------------------------------
+
+type gfs_policy struct {
+	daily	int
+	weekly	int
+	monthly	int
+}
+
+/*
+	Keep_gfs returns a grandfather-father-son policy: the newest checkpoint of each of
+	the most recent daily days, of each of the most recent weekly (ISO) weeks, and of
+	each of the most recent monthly months is kept.  A file may satisfy more than one
+	(e.g. the newest of today is also the newest of this week and month).  Periods
+	with no checkpoint are not counted.
+*/
+func Keep_gfs( daily int, weekly int, monthly int ) ( Policy ) {
+	return &gfs_policy{ daily: daily, weekly: weekly, monthly: monthly }
+}
+
+func (p *gfs_policy) Keep( files []*Info, now time.Time ) ( keep []bool ) {
+	keep = make( []bool, len( files ) )
+
+	periods := []struct {
+		n		int
+		key		func( t time.Time ) string
+	} {
+		{ p.daily, func( t time.Time ) string { return t.Format( "2006-01-02" ) } },
+		{ p.weekly, func( t time.Time ) string { y, w := t.ISOWeek(); return fmt.Sprintf( "%d-%02d", y, w ) } },
+		{ p.monthly, func( t time.Time ) string { return t.Format( "2006-01" ) } },
+	}
+
+	for _, period := range periods {
+		seen := make( map[string]bool )
+		for i, f := range files {							// newest first, so the first in each period is its newest
+			if len( seen ) >= period.n {
+				break
+			}
+			k := period.key( f.Mtime.Local() )
+			if ! seen[k] {
+				seen[k] = true
+				keep[i] = true
+			}
+		}
+	}
+
+	return keep
+}
+
+// ---- union ---------------------------------------------------------------------------
+
+type any_policy struct {
+	policies	[]Policy
+}
+
+/*
+	Keep_any returns a policy which keeps a file if any of the policies keeps it, for
+	example the last 5 along with anything from the last day:
+		chkpt.Keep_any( chkpt.Keep_last( 5 ), chkpt.Keep_age( 24 * time.Hour ) )
+*/
+func Keep_any( policies ...Policy ) ( Policy ) {
+	return &any_policy{ policies: policies }
+}
+
+func (p *any_policy) Keep( files []*Info, now time.Time ) ( keep []bool ) {
+	keep = make( []bool, len( files ) )
+	for _, sp := range p.policies {
+		for i, k := range sp.Keep( files, now ) {
+			keep[i] = keep[i] || k
+		}
+	}
+
+	return keep
+}
+
+// ---- pruning -------------------------------------------------------------------------
+
+/*
+	Set_retention sets the policy used by Prune(). Nil removes the policy.
+*/
+func (c *Chkpt) Set_retention( p Policy ) {
+	c.retention = p
+}
+
+/*
+	Prune removes the checkpoint files for the path which the retention policy doesn't
+	keep.  The newest checkpoint which can be verified (as by Open_latest()) is always
+	kept, whatever the policy says, so that pruning can never leave nothing to restart
+	from; in delta mode this is the newest full snapshot along with the newer files
+	which hold its deltas.  Temporary files left by checkpoints that were never
+	closed are also removed (not the one currently being written).  The tumbler
+	file is never removed.
+
+	The names of the removed files are returned. If a file can't be removed, the
+	others are still removed and the last error is returned.  Prune does nothing if
+	there is no retention policy.
+*/
+func (c *Chkpt) Prune( ) ( removed []string, err error ) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.retention == nil {
+		return nil, nil
+	}
+
+	files, err := list_chkpts( *c.path )
+	if err != nil {
+		return nil, err
+	}
+
+	newest := -1
+	if c.delta {											// keep the newest snapshot and everything newer (its deltas)
+		digests_used := uses_digests( files )
+		for i, cf := range files {
+			if cf.tch == "a" && cf.usable( digests_used ) == nil {
+				newest = i
+				break
+			}
+		}
+	} else {
+		if rc, fname, oerr := Open_latest( *c.path ); oerr == nil {
+			rc.Close()
+			for i, cf := range files {
+				if cf.name == fname {
+					newest = i
+					break
+				}
+			}
+		}
+	}
+
+	info := make( []*Info, len( files ) )
+	for i, cf := range files {
+		info[i] = &Info{ Name: cf.name, Mtime: cf.mtime }
+	}
+
+	keep := c.retention.Keep( info, time.Now() )
+	for i, cf := range files {
+		if i == newest || (c.delta && i < newest) || (i < len( keep ) && keep[i]) {
+			continue
+		}
+
+		if rerr := os.Remove( cf.name ); rerr != nil {
+			err = rerr
+			continue
+		}
+		removed = append( removed, cf.name )
+	}
+
+	tmps, _ := filepath.Glob( *c.path + "_*.ckpt.tmp" )
+	for _, tname := range tmps {
+		if tname == c.tmp_name && c.output != nil {
+			continue
+		}
+		if rerr := os.Remove( tname ); rerr != nil {
+			err = rerr
+			continue
+		}
+		removed = append( removed, tname )
+	}
+
+	return removed, err
+}
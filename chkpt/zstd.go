@@ -0,0 +1,457 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	zstd
+	Abstract:	Zstandard (RFC 8878) support for COMP_ZSTD: the pieces shared by the
+				writer (zstd_write.go) and the reader (zstd_read.go).  These are the
+				code tables, the FSE (finite state entropy) tables, the bit streams
+				and the xxhash64 used for the frame checksum.
+
+				The standard library has no zstd, and this package takes no outside
+				dependencies, so a small implementation is kept here.  The reader
+				handles any frame without a dictionary.  The writer favours
+				simplicity over ratio, which is about that of gzip.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package chkpt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	zstd_magic		uint32 = 0xfd2fb528
+	zstd_block_max	int = 128 * 1024		// largest block content
+	zstd_window_log	uint = 20				// window the writer declares; matches reach back no further
+	zstd_max_window	int = 1 << 27			// largest window the reader accepts
+
+	zblk_raw		int = 0					// block types
+	zblk_rle		int = 1
+	zblk_comp		int = 2
+
+	xx_p1	uint64 = 11400714785074694791	// xxhash64 primes
+	xx_p2	uint64 = 14029467366897019727
+	xx_p3	uint64 = 1609587929392839161
+	xx_p4	uint64 = 9650029242287828579
+	xx_p5	uint64 = 2870177450012600261
+)
+
+var (
+	ll_base = []uint32 {					// literal length codes: base value and extra bits
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536 }
+	ll_bits = []uint8 {
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16 }
+
+	ml_base = []uint32 {					// match length codes
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539 }
+	ml_bits = []uint8 {
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16 }
+
+	ll_def_norm = []int16 {					// predefined distributions
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1 }
+	ml_def_norm = []int16 {
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1 }
+	of_def_norm = []int16 {
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1 }
+
+	ll_def_tab, _ = build_fse( ll_def_norm, 6 )
+	ml_def_tab, _ = build_fse( ml_def_norm, 6 )
+	of_def_tab, _ = build_fse( of_def_norm, 5 )
+)
+
+// ---- fse tables -----------------------------------------------------------------------
+
+/*
+	One state of an FSE decoding table: the symbol it decodes and how the next state
+	is found (base plus the next nbits bits of the stream).
+*/
+type fse_entry struct {
+	sym		uint8
+	nbits	uint8
+	base	uint16
+}
+
+/*
+	Return the accuracy log of a table (its size is a power of two).
+*/
+func fse_log( tab []fse_entry ) ( uint ) {
+	return uint( bits.Len( uint( len( tab ) ) ) - 1 )
+}
+
+/*
+	Build the decoding table for a normalised distribution. A count of -1 is a
+	symbol with a probability of less than one state.
+*/
+func build_fse( norm []int16, alog uint ) ( []fse_entry, error ) {
+	size := 1 << alog
+	tab := make( []fse_entry, size )
+	next := make( []int, len( norm ) )
+
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			if high < 0 {
+				return nil, fmt.Errorf( "fse distribution is too large" )
+			}
+			tab[high].sym = uint8( s )
+			high--
+			next[s] = 1
+		} else {
+			next[s] = int( n )
+		}
+	}
+
+	pos := 0
+	step := (size >> 1) + (size >> 3) + 3
+	for s, n := range norm {
+		for i := 0; i < int( n ); i++ {
+			tab[pos].sym = uint8( s )
+			pos = (pos + step) & (size - 1)
+			for pos > high {
+				pos = (pos + step) & (size - 1)
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, fmt.Errorf( "fse distribution does not fill the table" )
+	}
+
+	for u := range tab {
+		s := tab[u].sym
+		ns := next[s]
+		next[s]++
+		nb := int( alog ) + 1 - bits.Len( uint( ns ) )
+		if nb < 0 || ns == 0 {
+			return nil, fmt.Errorf( "fse distribution is not sane" )
+		}
+		tab[u].nbits = uint8( nb )
+		tab[u].base = uint16( (ns << nb) - size )
+	}
+
+	return tab, nil
+}
+
+/*
+	Read an FSE table description (normalised counts) from the start of b returning
+	the counts, the accuracy log and the number of bytes used.
+*/
+func read_fse_table( b []byte, max_sym int, max_log uint ) ( norm []int16, alog uint, used int, err error ) {
+	fr := &fbits{ b: b }
+	alog = uint( fr.read( 4 ) ) + 5
+	if alog > max_log {
+		return nil, 0, 0, fmt.Errorf( "fse accuracy log too large: %d", alog )
+	}
+
+	remaining := (1 << alog) + 1
+	threshold := 1 << alog
+	nbits := alog + 1
+	for remaining > 1 {
+		if len( norm ) > max_sym {
+			return nil, 0, 0, fmt.Errorf( "fse table has too many symbols" )
+		}
+
+		max := 2 * threshold - 1 - remaining
+		v := int( fr.peek( nbits ) )
+		count := 0
+		if v & (threshold - 1) < max {
+			count = v & (threshold - 1)
+			fr.skip( nbits - 1 )
+		} else {
+			count = v & (2 * threshold - 1)
+			if count >= threshold {
+				count -= max
+			}
+			fr.skip( nbits )
+		}
+
+		count--
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		norm = append( norm, int16( count ) )
+
+		if count == 0 {
+			for {
+				r := int( fr.read( 2 ) )
+				for i := 0; i < r; i++ {
+					norm = append( norm, 0 )
+				}
+				if r != 3 || len( norm ) > max_sym + 1 {
+					break
+				}
+			}
+		}
+
+		for remaining < threshold && threshold > 1 {
+			nbits--
+			threshold >>= 1
+		}
+	}
+
+	used = (fr.pos + 7) / 8
+	if remaining != 1 || len( norm ) > max_sym + 1 || used > len( b ) {
+		return nil, 0, 0, fmt.Errorf( "fse table description is corrupt" )
+	}
+
+	return norm, alog, used, nil
+}
+
+// ---- bit streams ----------------------------------------------------------------------
+
+/*
+	Reads bits from the start of a buffer, least significant bit first. Reading past
+	the end gives zeros; the caller checks pos against the length.
+*/
+type fbits struct {
+	b	[]byte
+	pos	int				// bits read
+}
+
+func (fr *fbits) peek( n uint ) ( uint64 ) {
+	i := fr.pos >> 3
+	var w uint64
+	for k := 7; k >= 0; k-- {
+		w <<= 8
+		if i + k < len( fr.b ) {
+			w |= uint64( fr.b[i+k] )
+		}
+	}
+
+	return (w >> uint( fr.pos & 7 )) & (1 << n - 1)
+}
+
+func (fr *fbits) skip( n uint ) {
+	fr.pos += int( n )
+}
+
+func (fr *fbits) read( n uint ) ( uint64 ) {
+	v := fr.peek( n )
+	fr.skip( n )
+	return v
+}
+
+/*
+	Reads bits backwards from the end of a buffer, as the entropy coded streams are
+	written: the highest set bit of the last byte marks the start.  Pos counts the bits
+	left and goes negative if more are read than the stream holds, the missing bits
+	being zero.
+*/
+type rbits struct {
+	b	[]byte
+	pos	int
+}
+
+func mk_rbits( b []byte ) ( *rbits, error ) {
+	if len( b ) == 0 || b[len( b ) - 1] == 0 {
+		return nil, fmt.Errorf( "bit stream has no start marker" )
+	}
+
+	return &rbits{ b: b, pos: (len( b ) - 1) * 8 + bits.Len8( b[len( b ) - 1] ) - 1 }, nil
+}
+
+/*
+	Return the 64 bits starting at bit p (fewer at the end of the buffer).
+*/
+func (br *rbits) word( p int ) ( uint64 ) {
+	i := p >> 3
+	var w uint64
+	if i + 8 <= len( br.b ) {
+		w = binary.LittleEndian.Uint64( br.b[i:] )
+	} else {
+		for k := len( br.b ) - 1; k >= i; k-- {
+			w = w << 8 | uint64( br.b[k] )
+		}
+	}
+
+	return w >> uint( p & 7 )
+}
+
+/*
+	Return the next n (at most 56) bits without consuming them.
+*/
+func (br *rbits) peek( n uint ) ( uint64 ) {
+	if n == 0 {
+		return 0
+	}
+
+	p := br.pos - int( n )
+	if p >= 0 {
+		return br.word( p ) & (1 << n - 1)
+	}
+	if br.pos <= 0 {
+		return 0
+	}
+	return (br.word( 0 ) & (1 << uint( br.pos ) - 1)) << uint( -p )
+}
+
+func (br *rbits) skip( n uint ) {
+	br.pos -= int( n )
+}
+
+func (br *rbits) read( n uint ) ( uint64 ) {
+	v := br.peek( n )
+	br.skip( n )
+	return v
+}
+
+/*
+	Writes bits least significant first so that rbits reads them back last written
+	first.
+*/
+type wbits struct {
+	out	[]byte
+	acc	uint64
+	n	uint
+}
+
+/*
+	Add the low n (at most 32) bits of v.
+*/
+func (bw *wbits) add( v uint64, n uint ) {
+	bw.acc |= (v & (1 << n - 1)) << bw.n
+	bw.n += n
+	for bw.n >= 8 {
+		bw.out = append( bw.out, byte( bw.acc ) )
+		bw.acc >>= 8
+		bw.n -= 8
+	}
+}
+
+/*
+	Add the start marker and return the stream.
+*/
+func (bw *wbits) close( ) ( []byte ) {
+	bw.add( 1, 1 )
+	if bw.n > 0 {
+		bw.out = append( bw.out, byte( bw.acc ) )
+	}
+
+	return bw.out
+}
+
+// ---- xxhash64 -------------------------------------------------------------------------
+
+/*
+	Streaming xxhash64 (seed 0); the frame checksum is the low 32 bits.
+*/
+type xxh64 struct {
+	v		[4]uint64
+	total	uint64
+	mem		[32]byte
+	nmem	int
+}
+
+func mk_xxh64( ) ( *xxh64 ) {
+	p1 := xx_p1							// a variable so the sums wrap
+	return &xxh64{ v: [4]uint64{ p1 + xx_p2, xx_p2, 0, -p1 } }
+}
+
+func xx_round( acc uint64, in uint64 ) ( uint64 ) {
+	return bits.RotateLeft64( acc + in * xx_p2, 31 ) * xx_p1
+}
+
+func (x *xxh64) stripe( b []byte ) {
+	for i := range x.v {
+		x.v[i] = xx_round( x.v[i], binary.LittleEndian.Uint64( b[i*8:] ) )
+	}
+}
+
+func (x *xxh64) Write( b []byte ) ( int, error ) {
+	n := len( b )
+	x.total += uint64( n )
+
+	if x.nmem > 0 {
+		k := copy( x.mem[x.nmem:], b )
+		x.nmem += k
+		b = b[k:]
+		if x.nmem < 32 {
+			return n, nil
+		}
+		x.stripe( x.mem[:] )
+		x.nmem = 0
+	}
+
+	for ; len( b ) >= 32; b = b[32:] {
+		x.stripe( b )
+	}
+	x.nmem = copy( x.mem[:], b )
+
+	return n, nil
+}
+
+func (x *xxh64) Sum64( ) ( uint64 ) {
+	var h uint64
+	if x.total >= 32 {
+		h = bits.RotateLeft64( x.v[0], 1 ) + bits.RotateLeft64( x.v[1], 7 ) + bits.RotateLeft64( x.v[2], 12 ) + bits.RotateLeft64( x.v[3], 18 )
+		for _, v := range x.v {
+			h = (h ^ xx_round( 0, v )) * xx_p1 + xx_p4
+		}
+	} else {
+		h = xx_p5
+	}
+	h += x.total
+
+	b := x.mem[:x.nmem]
+	for ; len( b ) >= 8; b = b[8:] {
+		h ^= xx_round( 0, binary.LittleEndian.Uint64( b ) )
+		h = bits.RotateLeft64( h, 27 ) * xx_p1 + xx_p4
+	}
+	if len( b ) >= 4 {
+		h ^= uint64( binary.LittleEndian.Uint32( b ) ) * xx_p1
+		h = bits.RotateLeft64( h, 23 ) * xx_p2 + xx_p3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64( c ) * xx_p5
+		h = bits.RotateLeft64( h, 11 ) * xx_p1
+	}
+
+	h ^= h >> 33
+	h *= xx_p2
+	h ^= h >> 29
+	h *= xx_p3
+	h ^= h >> 32
+
+	return h
+}
@@ -0,0 +1,740 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	zstd_read
+	Abstract:	Decompresses a zstd stream: one or more frames, any of which may be
+				skippable.  Frames which need a dictionary are not supported.  The
+				content checksum is verified when the frame has one.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package chkpt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+/*
+	A huffman decoding table for literals, indexed by the next max_bits bits.
+*/
+type huf_table struct {
+	max_bits	uint
+	sym			[]uint8
+	nbits		[]uint8
+}
+
+/*
+	Decompressing reader.
+*/
+type zstd_reader struct {
+	br		*bufio.Reader
+	err		error				// sticky; io.EOF at the end of the last frame
+
+	window	int
+	last	bool				// last block of the frame has been decoded
+	xh		*xxh64				// nil if the frame has no checksum
+	hist	[]byte				// output of the frame; at least the last window bytes are kept
+	out		[]byte				// decoded, not yet returned (the end of hist)
+
+	rep		[3]int				// repeat offsets
+	huf		*huf_table			// tables kept for the repeat and treeless modes
+	ll_tab	[]fse_entry
+	of_tab	[]fse_entry
+	ml_tab	[]fse_entry
+
+	blk		[]byte				// block buffers
+	lits	[]byte
+}
+
+/*
+	Return a reader which decompresses the zstd stream read from r. The first frame
+	header is read so that something other than zstd is reported straight away.
+*/
+func mk_zstd_reader( r io.Reader ) ( io.ReadCloser, error ) {
+	zr := &zstd_reader{ br: bufio.NewReader( r ) }
+	if err := zr.frame_start( true ); err != nil {
+		if err == io.EOF {
+			err = fmt.Errorf( "not zstd data: empty stream" )
+		}
+		return nil, err
+	}
+
+	return zr, nil
+}
+
+func (zr *zstd_reader) Read( p []byte ) ( int, error ) {
+	for len( zr.out ) == 0 {
+		if zr.err != nil {
+			return 0, zr.err
+		}
+		zr.err = zr.next()
+	}
+
+	n := copy( p, zr.out )
+	zr.out = zr.out[n:]
+	return n, nil
+}
+
+func (zr *zstd_reader) Close( ) ( error ) {
+	return nil
+}
+
+/*
+	Read n bytes into the block buffer.
+*/
+func (zr *zstd_reader) read_n( n int ) ( []byte, error ) {
+	if cap( zr.blk ) < n {
+		zr.blk = make( []byte, n )
+	}
+	b := zr.blk[:n]
+	if _, err := io.ReadFull( zr.br, b ); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return b, nil
+}
+
+/*
+	Read the header of the next frame, skipping skippable frames. At the end of the
+	stream io.EOF is returned.
+*/
+func (zr *zstd_reader) frame_start( first bool ) ( error ) {
+	for {
+		b, err := zr.read_n( 4 )
+		if err != nil {
+			if err == io.ErrUnexpectedEOF && ! first {
+				if _, perr := zr.br.Peek( 1 ); perr == io.EOF {
+					return io.EOF
+				}
+			}
+			return err
+		}
+
+		magic := binary.LittleEndian.Uint32( b )
+		if magic & 0xfffffff0 == 0x184d2a50 {				// skippable frame
+			if b, err = zr.read_n( 4 ); err != nil {
+				return err
+			}
+			if _, err = io.CopyN( io.Discard, zr.br, int64( binary.LittleEndian.Uint32( b ) ) ); err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			continue
+		}
+		if magic != zstd_magic {
+			return fmt.Errorf( "not zstd data" )
+		}
+		break
+	}
+
+	b, err := zr.read_n( 1 )
+	if err != nil {
+		return err
+	}
+	fhd := b[0]
+	single := fhd & 0x20 != 0
+	if fhd & 0x08 != 0 {
+		return fmt.Errorf( "zstd frame header is corrupt" )
+	}
+
+	fcs_len := []int{ 0, 2, 4, 8 }[fhd >> 6]
+	if fcs_len == 0 && single {
+		fcs_len = 1
+	}
+	did_len := []int{ 0, 1, 2, 4 }[fhd & 3]
+	wd_len := 1
+	if single {
+		wd_len = 0
+	}
+
+	if b, err = zr.read_n( wd_len + did_len + fcs_len ); err != nil {
+		return err
+	}
+
+	if ! single {
+		exp := uint( b[0] >> 3 )
+		base := 1 << (10 + exp)
+		zr.window = base + (base / 8) * int( b[0] & 7 )
+	}
+	var did uint64
+	for i := did_len - 1; i >= 0; i-- {
+		did = did << 8 | uint64( b[wd_len+i] )
+	}
+	if did != 0 {
+		return fmt.Errorf( "zstd frames which need a dictionary are not supported" )
+	}
+	if single {
+		var fcs uint64
+		for i := fcs_len - 1; i >= 0; i-- {
+			fcs = fcs << 8 | uint64( b[wd_len+did_len+i] )
+		}
+		if fcs_len == 2 {
+			fcs += 256
+		}
+		if fcs > uint64( zstd_max_window ) {
+			return fmt.Errorf( "zstd window is too large: %d", fcs )
+		}
+		zr.window = int( fcs )
+	}
+	if zr.window > zstd_max_window {
+		return fmt.Errorf( "zstd window is too large: %d", zr.window )
+	}
+
+	zr.xh = nil
+	if fhd & 0x04 != 0 {
+		zr.xh = mk_xxh64()
+	}
+	zr.last = false
+	zr.hist = zr.hist[:0]
+	zr.rep = [3]int{ 1, 4, 8 }
+	zr.huf = nil
+	zr.ll_tab = nil
+	zr.of_tab = nil
+	zr.ml_tab = nil
+
+	return nil
+}
+
+/*
+	Decode the next block into out, or finish the frame and start the next.
+*/
+func (zr *zstd_reader) next( ) ( error ) {
+	if zr.last {
+		if zr.xh != nil {
+			b, err := zr.read_n( 4 )
+			if err != nil {
+				return err
+			}
+			if binary.LittleEndian.Uint32( b ) != uint32( zr.xh.Sum64() ) {
+				return fmt.Errorf( "zstd checksum mismatch" )
+			}
+		}
+		return zr.frame_start( false )
+	}
+
+	b, err := zr.read_n( 3 )
+	if err != nil {
+		return err
+	}
+	h := int( b[0] ) | int( b[1] ) << 8 | int( b[2] ) << 16
+	zr.last = h & 1 == 1
+	btype := (h >> 1) & 3
+	bsize := h >> 3
+
+	max := min( zr.window, zstd_block_max )
+	if bsize > max {
+		return fmt.Errorf( "zstd block is too large: %d", bsize )
+	}
+
+	if len( zr.hist ) > 2 * zr.window + zstd_block_max {			// drop what no match can reach
+		n := copy( zr.hist, zr.hist[len( zr.hist ) - zr.window:] )
+		zr.hist = zr.hist[:n]
+	}
+	start := len( zr.hist )
+
+	switch btype {
+		case zblk_raw:
+			if b, err = zr.read_n( bsize ); err != nil {
+				return err
+			}
+			zr.hist = append( zr.hist, b... )
+
+		case zblk_rle:
+			if b, err = zr.read_n( 1 ); err != nil {
+				return err
+			}
+			for i := 0; i < bsize; i++ {
+				zr.hist = append( zr.hist, b[0] )
+			}
+
+		case zblk_comp:
+			if b, err = zr.read_n( bsize ); err != nil {
+				return err
+			}
+			if err = zr.decode_block( b ); err != nil {
+				return err
+			}
+			if len( zr.hist ) - start > max {
+				return fmt.Errorf( "zstd block content is too large" )
+			}
+
+		default:
+			return fmt.Errorf( "zstd block type is reserved" )
+	}
+
+	zr.out = zr.hist[start:]
+	if zr.xh != nil {
+		zr.xh.Write( zr.out )
+	}
+
+	return nil
+}
+
+/*
+	Decode a compressed block appending its content to hist.
+*/
+func (zr *zstd_reader) decode_block( b []byte ) ( error ) {
+	lits, n, err := zr.read_literals( b )
+	if err != nil {
+		return err
+	}
+
+	return zr.sequences( b[n:], lits )
+}
+
+/*
+	Read the literals section returning the literals and the bytes used.
+*/
+func (zr *zstd_reader) read_literals( b []byte ) ( lits []byte, used int, err error ) {
+	if len( b ) < 1 {
+		return nil, 0, fmt.Errorf( "zstd literals section is missing" )
+	}
+	ltype := b[0] & 3
+	sf := (b[0] >> 2) & 3
+
+	if ltype < 2 {											// raw or rle
+		size := 0
+		hl := 1
+		switch sf {
+			case 0, 2:
+				size = int( b[0] >> 3 )
+
+			case 1:
+				hl = 2
+
+			case 3:
+				hl = 3
+		}
+		if len( b ) < hl {
+			return nil, 0, fmt.Errorf( "zstd literals header is short" )
+		}
+		if hl > 1 {
+			size = int( b[0] >> 4 ) | int( b[1] ) << 4
+			if hl == 3 {
+				size |= int( b[2] ) << 12
+			}
+		}
+		if size > zstd_block_max {
+			return nil, 0, fmt.Errorf( "zstd literals are too large" )
+		}
+
+		if ltype == 0 {
+			if len( b ) < hl + size {
+				return nil, 0, fmt.Errorf( "zstd literals are short" )
+			}
+			return b[hl:hl+size], hl + size, nil
+		}
+
+		if len( b ) < hl + 1 {
+			return nil, 0, fmt.Errorf( "zstd literals are short" )
+		}
+		lits = zr.lits[:0]
+		for i := 0; i < size; i++ {
+			lits = append( lits, b[hl] )
+		}
+		zr.lits = lits
+		return lits, hl + 1, nil
+	}
+
+	hl := []int{ 3, 3, 4, 5 }[sf]							// huffman coded
+	nbits := []uint{ 10, 10, 14, 18 }[sf]
+	streams := 4
+	if sf == 0 {
+		streams = 1
+	}
+	if len( b ) < hl {
+		return nil, 0, fmt.Errorf( "zstd literals header is short" )
+	}
+	var h uint64
+	for i := hl - 1; i >= 0; i-- {
+		h = h << 8 | uint64( b[i] )
+	}
+	regen := int( (h >> 4) & (1 << nbits - 1) )
+	csize := int( (h >> (4 + nbits)) & (1 << nbits - 1) )
+	if regen > zstd_block_max || len( b ) < hl + csize {
+		return nil, 0, fmt.Errorf( "zstd literals are corrupt" )
+	}
+
+	data := b[hl:hl+csize]
+	if ltype == 2 {
+		t, n, terr := read_huf_table( data )
+		if terr != nil {
+			return nil, 0, terr
+		}
+		zr.huf = t
+		data = data[n:]
+	} else if zr.huf == nil {
+		return nil, 0, fmt.Errorf( "zstd treeless literals with no earlier table" )
+	}
+
+	lits, err = zr.huf.decode( data, regen, streams, zr.lits[:0] )
+	zr.lits = lits
+	return lits, hl + csize, err
+}
+
+/*
+	Read the huffman table description returning the table and the bytes used.
+*/
+func read_huf_table( b []byte ) ( *huf_table, int, error ) {
+	if len( b ) < 1 {
+		return nil, 0, fmt.Errorf( "zstd huffman table is missing" )
+	}
+
+	var w []uint8
+	used := 0
+	hb := int( b[0] )
+	if hb < 128 {												// fse compressed weights
+		if hb == 0 || len( b ) < 1 + hb {
+			return nil, 0, fmt.Errorf( "zstd huffman table is short" )
+		}
+		var err error
+		if w, err = fse_weights( b[1:1+hb] ); err != nil {
+			return nil, 0, err
+		}
+		used = 1 + hb
+	} else {													// four bits each
+		n := hb - 127
+		used = 1 + (n + 1) / 2
+		if len( b ) < used {
+			return nil, 0, fmt.Errorf( "zstd huffman table is short" )
+		}
+		for i := 0; i < n; i++ {
+			if i & 1 == 0 {
+				w = append( w, b[1+i/2] >> 4 )
+			} else {
+				w = append( w, b[1+i/2] & 15 )
+			}
+		}
+	}
+
+	t, err := mk_huf_table( w )
+	return t, used, err
+}
+
+/*
+	Decode the huffman weights which are compressed with fse: two states share one
+	stream, taking turns, until the stream runs out.
+*/
+func fse_weights( b []byte ) ( []uint8, error ) {
+	norm, alog, n, err := read_fse_table( b, 11, 6 )
+	if err != nil {
+		return nil, err
+	}
+	tab, err := build_fse( norm, alog )
+	if err != nil {
+		return nil, err
+	}
+	br, err := mk_rbits( b[n:] )
+	if err != nil {
+		return nil, err
+	}
+
+	s := [2]uint64{ br.read( alog ), br.read( alog ) }
+	w := make( []uint8, 0, 256 )
+	for i := 0; ; i ^= 1 {
+		if len( w ) > 254 {
+			return nil, fmt.Errorf( "zstd huffman table has too many weights" )
+		}
+		e := tab[s[i]]
+		w = append( w, e.sym )
+		s[i] = uint64( e.base ) + br.read( uint( e.nbits ) )
+		if br.pos < 0 {
+			w = append( w, tab[s[i^1]].sym )
+			break
+		}
+	}
+
+	return w, nil
+}
+
+/*
+	Build the decoding table from the weights. The weight of the last symbol isn't
+	sent; it is the one which makes the total a power of two.
+*/
+func mk_huf_table( w []uint8 ) ( *huf_table, error ) {
+	total := 0
+	for _, x := range w {
+		if x > 11 {
+			return nil, fmt.Errorf( "zstd huffman weight is too large" )
+		}
+		if x > 0 {
+			total += 1 << (x - 1)
+		}
+	}
+	if total == 0 || len( w ) > 255 {
+		return nil, fmt.Errorf( "zstd huffman weights are corrupt" )
+	}
+
+	max_bits := uint( bits.Len( uint( total ) ) )
+	rest := 1 << max_bits - total
+	if max_bits > 11 || rest & (rest - 1) != 0 {
+		return nil, fmt.Errorf( "zstd huffman weights are corrupt" )
+	}
+	w = append( w, uint8( bits.Len( uint( rest ) ) ) )
+
+	t := &huf_table{ max_bits: max_bits, sym: make( []uint8, 1 << max_bits ), nbits: make( []uint8, 1 << max_bits ) }
+	pos := 0
+	for wt := uint8( 1 ); wt <= uint8( max_bits ); wt++ {				// lowest weight (longest code) first
+		for s, x := range w {
+			if x != wt {
+				continue
+			}
+			for k := 0; k < 1 << (wt - 1); k++ {
+				t.sym[pos] = uint8( s )
+				t.nbits[pos] = uint8( max_bits + 1 ) - wt
+				pos++
+			}
+		}
+	}
+
+	return t, nil
+}
+
+/*
+	Decode regen literals from one stream, or four with a jump table, appending them
+	to out.
+*/
+func (t *huf_table) decode( b []byte, regen int, streams int, out []byte ) ( []byte, error ) {
+	if streams == 1 {
+		return t.decode_stream( b, regen, out )
+	}
+
+	if len( b ) < 6 {
+		return nil, fmt.Errorf( "zstd literals jump table is short" )
+	}
+	sizes := []int{ int( binary.LittleEndian.Uint16( b ) ), int( binary.LittleEndian.Uint16( b[2:] ) ), int( binary.LittleEndian.Uint16( b[4:] ) ), 0 }
+	b = b[6:]
+	sizes[3] = len( b ) - sizes[0] - sizes[1] - sizes[2]
+	seg := (regen + 3) / 4
+	if sizes[3] < 0 || 3 * seg > regen {
+		return nil, fmt.Errorf( "zstd literals jump table is corrupt" )
+	}
+
+	var err error
+	for i, size := range sizes {
+		n := seg
+		if i == 3 {
+			n = regen - 3 * seg
+		}
+		if out, err = t.decode_stream( b[:size], n, out ); err != nil {
+			return nil, err
+		}
+		b = b[size:]
+	}
+
+	return out, nil
+}
+
+func (t *huf_table) decode_stream( b []byte, n int, out []byte ) ( []byte, error ) {
+	br, err := mk_rbits( b )
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < n; i++ {
+		v := br.peek( t.max_bits )
+		out = append( out, t.sym[v] )
+		br.skip( uint( t.nbits[v] ) )
+	}
+	if br.pos != 0 {
+		return nil, fmt.Errorf( "zstd huffman stream is corrupt" )
+	}
+
+	return out, nil
+}
+
+/*
+	Return the table for one of the sequence codes given its mode: predefined, a
+	single symbol, described in the block, or the one used by the previous block.
+*/
+func seq_table( b []byte, mode byte, prev []fse_entry, def []fse_entry, max_sym int, max_log uint ) ( []fse_entry, int, error ) {
+	switch mode {
+		case 0:
+			return def, 0, nil
+
+		case 1:
+			if len( b ) < 1 || int( b[0] ) > max_sym {
+				return nil, 0, fmt.Errorf( "zstd sequence rle symbol is corrupt" )
+			}
+			return []fse_entry{ { sym: b[0] } }, 1, nil
+
+		case 2:
+			norm, alog, n, err := read_fse_table( b, max_sym, max_log )
+			if err != nil {
+				return nil, 0, err
+			}
+			tab, err := build_fse( norm, alog )
+			return tab, n, err
+
+		default:
+			if prev == nil {
+				return nil, 0, fmt.Errorf( "zstd sequence table repeated with no earlier table" )
+			}
+			return prev, 0, nil
+	}
+}
+
+/*
+	Decode the sequences section and execute the sequences appending the block's
+	content to hist.
+*/
+func (zr *zstd_reader) sequences( b []byte, lits []byte ) ( error ) {
+	if len( b ) < 1 {
+		return fmt.Errorf( "zstd sequences section is missing" )
+	}
+	nseq := int( b[0] )
+	p := 1
+	switch {
+		case nseq == 255:
+			if len( b ) < 3 {
+				return fmt.Errorf( "zstd sequences header is short" )
+			}
+			nseq = int( b[1] ) + int( b[2] ) << 8 + 0x7f00
+			p = 3
+
+		case nseq >= 128:
+			if len( b ) < 2 {
+				return fmt.Errorf( "zstd sequences header is short" )
+			}
+			nseq = (nseq - 128) << 8 + int( b[1] )
+			p = 2
+	}
+
+	if nseq == 0 {
+		if p != len( b ) {
+			return fmt.Errorf( "zstd sequences section is corrupt" )
+		}
+		zr.hist = append( zr.hist, lits... )
+		return nil
+	}
+
+	if len( b ) < p + 1 || b[p] & 3 != 0 {
+		return fmt.Errorf( "zstd sequences header is corrupt" )
+	}
+	modes := b[p]
+	p++
+
+	var n int
+	var err error
+	if zr.ll_tab, n, err = seq_table( b[p:], modes >> 6, zr.ll_tab, ll_def_tab, len( ll_base ) - 1, 9 ); err != nil {
+		return err
+	}
+	p += n
+	if zr.of_tab, n, err = seq_table( b[p:], (modes >> 4) & 3, zr.of_tab, of_def_tab, 31, 8 ); err != nil {
+		return err
+	}
+	p += n
+	if zr.ml_tab, n, err = seq_table( b[p:], (modes >> 2) & 3, zr.ml_tab, ml_def_tab, len( ml_base ) - 1, 9 ); err != nil {
+		return err
+	}
+	p += n
+
+	br, err := mk_rbits( b[p:] )
+	if err != nil {
+		return err
+	}
+	lls := br.read( fse_log( zr.ll_tab ) )
+	ofs := br.read( fse_log( zr.of_tab ) )
+	mls := br.read( fse_log( zr.ml_tab ) )
+
+	li := 0
+	for i := 0; i < nseq; i++ {
+		lc := zr.ll_tab[lls].sym
+		oc := zr.of_tab[ofs].sym
+		mc := zr.ml_tab[mls].sym
+		if int( lc ) >= len( ll_base ) || int( mc ) >= len( ml_base ) || oc > 31 {
+			return fmt.Errorf( "zstd sequence code is corrupt" )
+		}
+
+		ofv := int( 1 << oc + br.read( uint( oc ) ) )
+		ml := int( ml_base[mc] ) + int( br.read( uint( ml_bits[mc] ) ) )
+		ll := int( ll_base[lc] ) + int( br.read( uint( ll_bits[lc] ) ) )
+
+		if i + 1 < nseq {
+			e := zr.ll_tab[lls]
+			lls = uint64( e.base ) + br.read( uint( e.nbits ) )
+			e = zr.ml_tab[mls]
+			mls = uint64( e.base ) + br.read( uint( e.nbits ) )
+			e = zr.of_tab[ofs]
+			ofs = uint64( e.base ) + br.read( uint( e.nbits ) )
+		}
+
+		off := 0
+		if ofv > 3 {
+			off = ofv - 3
+			zr.rep = [3]int{ off, zr.rep[0], zr.rep[1] }
+		} else {
+			idx := ofv - 1
+			if ll == 0 {
+				idx++
+			}
+			switch idx {
+				case 0:
+					off = zr.rep[0]
+
+				case 1:
+					off = zr.rep[1]
+					zr.rep = [3]int{ off, zr.rep[0], zr.rep[2] }
+
+				case 2:
+					off = zr.rep[2]
+					zr.rep = [3]int{ off, zr.rep[0], zr.rep[1] }
+
+				default:
+					off = zr.rep[0] - 1
+					zr.rep = [3]int{ off, zr.rep[0], zr.rep[1] }
+			}
+		}
+
+		if li + ll > len( lits ) {
+			return fmt.Errorf( "zstd sequence uses more literals than the block has" )
+		}
+		zr.hist = append( zr.hist, lits[li:li+ll]... )
+		li += ll
+
+		if off <= 0 || off > len( zr.hist ) || off > zr.window {
+			return fmt.Errorf( "zstd match offset is not sane: %d", off )
+		}
+		from := len( zr.hist ) - off
+		if ml <= off {
+			zr.hist = append( zr.hist, zr.hist[from:from+ml]... )
+		} else {
+			for k := 0; k < ml; k++ {						// overlaps what it writes
+				zr.hist = append( zr.hist, zr.hist[from+k] )
+			}
+		}
+	}
+
+	if br.pos != 0 {
+		return fmt.Errorf( "zstd sequences stream is corrupt" )
+	}
+	zr.hist = append( zr.hist, lits[li:]... )
+
+	return nil
+}
@@ -0,0 +1,824 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	zstd_write
+	Abstract:	Compresses a stream as a single zstd frame with a content checksum.
+				Each block is matched greedily against the data written so far (up to
+				the declared window) using short hash chains.  The literals are
+				huffman coded, and the sequences coded with tables built for the
+				block, when that is smaller.  A block which doesn't get smaller is
+				written as is.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package chkpt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+const (
+	zhash_log	uint = 16
+	zchain_len	int = 16				// earlier positions with the same hash tried for a longer match
+	zmin_huf	int = 64				// fewer literals are not worth a huffman table
+)
+
+/*
+	One sequence: literals to copy, then a match.
+*/
+type zseq struct {
+	ll		int
+	ml		int
+	off		int
+}
+
+/*
+	Encoding tables for one of the predefined sequence distributions: for a symbol and
+	the state which is to follow it, the state which leads there.
+*/
+type fse_enc struct {
+	tab		[]fse_entry
+	first	[]uint16				// a state for each symbol, used for the last sequence
+	to		[][]uint16				// [symbol][next state] -> state
+}
+
+var (
+	ll_enc = mk_fse_enc( ll_def_tab, len( ll_base ) )
+	ml_enc = mk_fse_enc( ml_def_tab, len( ml_base ) )
+	of_enc = mk_fse_enc( of_def_tab, len( of_def_norm ) )
+)
+
+func mk_fse_enc( tab []fse_entry, nsyms int ) ( *fse_enc ) {
+	fe := &fse_enc{ tab: tab, first: make( []uint16, nsyms ), to: make( [][]uint16, nsyms ) }
+	for u := len( tab ) - 1; u >= 0; u-- {
+		e := tab[u]
+		fe.first[e.sym] = uint16( u )
+		if fe.to[e.sym] == nil {
+			fe.to[e.sym] = make( []uint16, len( tab ) )
+		}
+		for x := int( e.base ); x < int( e.base ) + 1 << e.nbits; x++ {
+			fe.to[e.sym][x] = uint16( u )
+		}
+	}
+
+	return fe
+}
+
+/*
+	Move the state back over sym writing the bits the reader uses to get from the new
+	state to the current one.
+*/
+func (fe *fse_enc) step( bw *wbits, state uint16, sym uint8 ) ( uint16 ) {
+	u := fe.to[sym][state]
+	e := fe.tab[u]
+	bw.add( uint64( state - e.base ), uint( e.nbits ) )
+	return u
+}
+
+/*
+	Return the code for a length using the base table; small values map directly.
+*/
+func len_code( v int, base []uint32, direct int ) ( uint8 ) {
+	if v - int( base[0] ) < direct {
+		return uint8( v - int( base[0] ) )
+	}
+
+	c := len( base ) - 1
+	for int( base[c] ) > v {
+		c--
+	}
+	return uint8( c )
+}
+
+/*
+	Compressing writer.
+*/
+type zstd_writer struct {
+	w		io.Writer
+	err		error
+	started	bool					// frame header written
+	closed	bool
+	xh		*xxh64
+
+	pend	[]byte					// written, not yet compressed
+	hist	[]byte					// data already compressed followed by the current block
+	table	[]int32					// hash of 4 bytes -> position in hist + 1 where they were last seen
+	prev	[]int32					// position in hist -> position + 1 where its hash was seen before
+	last_off	int					// offset of the last match found
+	rep		[3]int					// repeat offsets as the reader has them
+
+	seqs	[]zseq					// block buffers
+	lits	[]byte
+	body	[]byte
+}
+
+/*
+	Return a writer which compresses to w. The frame is finished by Close(), which
+	does not close w.
+*/
+func mk_zstd_writer( w io.Writer ) ( io.WriteCloser, error ) {
+	return &zstd_writer{ w: w, xh: mk_xxh64(), table: make( []int32, 1 << zhash_log ), rep: [3]int{ 1, 4, 8 } }, nil
+}
+
+func (zw *zstd_writer) Write( p []byte ) ( int, error ) {
+	if zw.err != nil {
+		return 0, zw.err
+	}
+	if zw.closed {
+		return 0, fmt.Errorf( "write to closed zstd writer" )
+	}
+
+	zw.xh.Write( p )
+	zw.pend = append( zw.pend, p... )
+	for len( zw.pend ) > zstd_block_max {			// keep the remainder, the last block must be flagged
+		if zw.err = zw.block( zw.pend[:zstd_block_max], false ); zw.err != nil {
+			return 0, zw.err
+		}
+		zw.pend = zw.pend[:copy( zw.pend, zw.pend[zstd_block_max:] )]
+	}
+
+	return len( p ), nil
+}
+
+/*
+	Close writes what is left as the last block and the checksum.
+*/
+func (zw *zstd_writer) Close( ) ( error ) {
+	if zw.err != nil || zw.closed {
+		return zw.err
+	}
+	zw.closed = true
+
+	if zw.err = zw.block( zw.pend, true ); zw.err != nil {
+		return zw.err
+	}
+	zw.pend = nil
+
+	var sum [4]byte
+	binary.LittleEndian.PutUint32( sum[:], uint32( zw.xh.Sum64() ) )
+	_, zw.err = zw.w.Write( sum[:] )
+	return zw.err
+}
+
+/*
+	Write one block, preceded by the frame header if it's the first.
+*/
+func (zw *zstd_writer) block( data []byte, last bool ) ( error ) {
+	out := make( []byte, 0, len( data ) + 16 )
+	if ! zw.started {
+		out = binary.LittleEndian.AppendUint32( out, zstd_magic )
+		out = append( out, 0x04, byte( zstd_window_log - 10 ) << 3 )		// checksum, no content size; window
+		zw.started = true
+	}
+
+	btype := zblk_raw
+	body := data
+	size := len( data )
+	switch {
+		case len( data ) == 0:
+
+		case is_run( data ):
+			btype = zblk_rle
+			body = data[:1]
+			zw.remember( data )
+
+		default:
+			if cb := zw.compress( data ); cb != nil {				// data is added to the history either way
+				btype = zblk_comp
+				body = cb
+				size = len( cb )
+			}
+	}
+
+	h := btype << 1 | size << 3
+	if last {
+		h |= 1
+	}
+	out = append( out, byte( h ), byte( h >> 8 ), byte( h >> 16 ) )
+	out = append( out, body... )
+
+	_, err := zw.w.Write( out )
+	return err
+}
+
+/*
+	Return true if every byte is the same.
+*/
+func is_run( b []byte ) ( bool ) {
+	for _, c := range b {
+		if c != b[0] {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+	Add data to the history, dropping what is beyond the window once there is enough
+	of it to be worth the copy. Returns the position in hist where data starts.
+*/
+func (zw *zstd_writer) remember( data []byte ) ( int ) {
+	window := 1 << zstd_window_log
+	if len( zw.hist ) > 2 * window {
+		drop := len( zw.hist ) - window
+		zw.hist = zw.hist[:copy( zw.hist, zw.hist[drop:] )]
+		zw.prev = zw.prev[:copy( zw.prev, zw.prev[drop:] )]
+		for _, pos := range [][]int32{ zw.table, zw.prev } {
+			for k, v := range pos {
+				pos[k] = max( v - int32( drop ), 0 )
+			}
+		}
+	}
+
+	zw.hist = append( zw.hist, data... )
+	zw.prev = append( zw.prev, make( []int32, len( data ) )... )
+	return len( zw.hist ) - len( data )
+}
+
+func zhash( u uint32 ) ( uint32 ) {
+	return (u * 2654435761) >> (32 - zhash_log)
+}
+
+/*
+	Add the position to the hash chains.
+*/
+func (zw *zstd_writer) insert( p int ) {
+	h := zhash( binary.LittleEndian.Uint32( zw.hist[p:] ) )
+	zw.prev[p] = zw.table[h]
+	zw.table[h] = int32( p + 1 )
+}
+
+/*
+	Return the start and length of the best match for position i, or -1 if there is no
+	match of at least four bytes.  A match at the last offset is cheap to send so it
+	is kept unless another is a few bytes longer.
+*/
+func (zw *zstd_writer) best_match( i int, end int ) ( cand int, m int ) {
+	hist := zw.hist
+	match_len := func( p int ) ( int ) {
+		n := 0
+		for i + n < end && hist[p+n] == hist[i+n] {
+			n++
+		}
+		return n
+	}
+
+	cand = -1
+	if r := i - zw.last_off; zw.last_off > 0 && r >= 0 {
+		if m = match_len( r ); m >= 4 {
+			cand = r
+		} else {
+			m = 0
+		}
+	}
+	rep_len := m
+
+	p := int( zw.table[zhash( binary.LittleEndian.Uint32( hist[i:] ) )] ) - 1
+	for tries := 0; p >= 0 && i - p <= 1 << zstd_window_log && tries < zchain_len; tries++ {
+		if i + m < end && hist[p+m] == hist[i+m] {
+			if n := match_len( p ); n > m && n >= rep_len + 3 {
+				cand, m = p, n
+			}
+		}
+		p = int( zw.prev[p] ) - 1
+	}
+
+	if m < 4 {
+		return -1, 0
+	}
+	return cand, m
+}
+
+/*
+	Find the sequences for the block which starts at s0 in hist, filling seqs and lits.
+*/
+func (zw *zstd_writer) find_matches( s0 int ) {
+	hist := zw.hist
+	end := len( hist )
+	zw.seqs = zw.seqs[:0]
+	zw.lits = zw.lits[:0]
+
+	anchor := s0
+	for i := s0; i + 8 <= end; {
+		cand, m := zw.best_match( i, end )
+		zw.insert( i )
+		if cand < 0 {
+			i += 1 + (i - anchor) >> 6						// step faster through data which doesn't match
+			continue
+		}
+
+		for p := i + 1; p < i + m && p + 8 <= end; p++ {		// later matches can start inside this one
+			zw.insert( p )
+		}
+		for i > anchor && cand > 0 && hist[i-1] == hist[cand-1] {
+			i--
+			cand--
+			m++
+		}
+
+		zw.last_off = i - cand
+		zw.seqs = append( zw.seqs, zseq{ ll: i - anchor, ml: m, off: zw.last_off } )
+		zw.lits = append( zw.lits, hist[anchor:i]... )
+		i += m
+		anchor = i
+	}
+
+	zw.lits = append( zw.lits, hist[anchor:]... )
+}
+
+/*
+	Return the compressed block body, or nil if it is no smaller than the data.
+*/
+func (zw *zstd_writer) compress( data []byte ) ( []byte ) {
+	zw.find_matches( zw.remember( data ) )
+
+	rep := zw.rep
+	body := put_literals( zw.body[:0], zw.lits )
+	body = put_sequences( body, zw.seqs, &rep )
+	zw.body = body
+	if len( body ) >= len( data ) {
+		return nil
+	}
+
+	zw.rep = rep											// the reader only sees offsets in compressed blocks
+	return body
+}
+
+/*
+	Append the literals section: huffman coded if that is smaller, else raw (or rle).
+*/
+func put_literals( out []byte, lits []byte ) ( []byte ) {
+	n := len( lits )
+	if n >= zmin_huf {
+		if hb := huf_literals( lits ); hb != nil && len( hb ) < n {
+			return append( out, hb... )
+		}
+	}
+
+	ltype := 0
+	if n > 1 && is_run( lits ) {
+		ltype = 1
+	}
+	switch {
+		case n < 32:
+			out = append( out, byte( ltype | n << 3 ) )
+
+		case n < 4096:
+			out = append( out, byte( ltype | 1 << 2 | (n & 15) << 4 ), byte( n >> 4 ) )
+
+		default:
+			out = append( out, byte( ltype | 3 << 2 | (n & 15) << 4 ), byte( n >> 4 ), byte( n >> 12 ) )
+	}
+	if ltype == 1 {
+		return append( out, lits[0] )
+	}
+
+	return append( out, lits... )
+}
+
+/*
+	Return code lengths for a huffman code over the frequencies, limited to max_len
+	bits. When the tree is too deep the frequencies are flattened and it is rebuilt.
+*/
+func huf_lengths( freq []int, max_len int ) ( []int ) {
+	f := append( []int{}, freq... )
+	for {
+		lens := huf_build( f )
+		deepest := 0
+		for _, l := range lens {
+			deepest = max( deepest, l )
+		}
+		if deepest <= max_len {
+			return lens
+		}
+
+		for i := range f {
+			if f[i] > 0 {
+				f[i] = (f[i] + 1) / 2
+			}
+		}
+	}
+}
+
+/*
+	Build a huffman tree over the symbols with a non-zero frequency returning the
+	depth of each symbol (0 if unused). At least two symbols must be used.
+*/
+func huf_build( f []int ) ( []int ) {
+	weight := make( []int, 0, 2 * len( f ) )
+	parent := make( []int, 0, 2 * len( f ) )
+	leaf := make( []int, len( f ) )
+	active := make( []int, 0, len( f ) )
+	for s, n := range f {
+		leaf[s] = -1
+		if n > 0 {
+			leaf[s] = len( weight )
+			active = append( active, len( weight ) )
+			weight = append( weight, n )
+			parent = append( parent, -1 )
+		}
+	}
+
+	smallest := func( ) ( int ) {				// remove and return the lightest active node
+		k := 0
+		for j := range active {
+			if weight[active[j]] < weight[active[k]] {
+				k = j
+			}
+		}
+		node := active[k]
+		active = append( active[:k], active[k+1:]... )
+		return node
+	}
+
+	for len( active ) > 1 {
+		a := smallest()
+		b := smallest()
+		node := len( weight )
+		weight = append( weight, weight[a] + weight[b] )
+		parent = append( parent, -1 )
+		parent[a] = node
+		parent[b] = node
+		active = append( active, node )
+	}
+
+	lens := make( []int, len( f ) )
+	for s, node := range leaf {
+		for ; node >= 0 && parent[node] >= 0; node = parent[node] {
+			lens[s]++
+		}
+	}
+
+	return lens
+}
+
+/*
+	Return the literals section with the literals huffman coded in four streams, or nil
+	if they can't be.  The table is sent as four bit weights, so the largest literal
+	must be at most 128.
+*/
+func huf_literals( lits []byte ) ( []byte ) {
+	freq := make( []int, 256 )
+	for _, c := range lits {
+		freq[c]++
+	}
+	last := 255
+	for freq[last] == 0 {
+		last--
+	}
+	used := 0
+	for _, n := range freq {
+		if n > 0 {
+			used++
+		}
+	}
+	if used < 2 || last > 128 {
+		return nil
+	}
+
+	lens := huf_lengths( freq[:last+1], 11 )
+	max_bits := 0
+	for _, l := range lens {
+		max_bits = max( max_bits, l )
+	}
+	weight := make( []int, last + 1 )
+	for s, l := range lens {
+		if l > 0 {
+			weight[s] = max_bits + 1 - l
+		}
+	}
+
+	code := make( []uint64, last + 1 )					// canonical codes, as the reader builds its table
+	pos := 0
+	for wt := 1; wt <= max_bits; wt++ {
+		for s, x := range weight {
+			if x == wt {
+				code[s] = uint64( pos >> (wt - 1) )
+				pos += 1 << (wt - 1)
+			}
+		}
+	}
+
+	desc := []byte{ byte( 127 + last ) }					// weights of all but the last symbol
+	for s := 0; s < last; s += 2 {
+		b := byte( weight[s] << 4 )
+		if s + 1 < last {
+			b |= byte( weight[s+1] )
+		}
+		desc = append( desc, b )
+	}
+
+	n := len( lits )
+	seg := (n + 3) / 4
+	var streams [4][]byte
+	for i := range streams {
+		part := lits[min( i * seg, n ):min( (i + 1) * seg, n )]
+		bw := &wbits{}
+		for k := len( part ) - 1; k >= 0; k-- {			// read back first to last
+			c := part[k]
+			bw.add( code[c], uint( lens[c] ) )
+		}
+		streams[i] = bw.close()
+	}
+
+	csize := len( desc ) + 6
+	for _, s := range streams {
+		csize += len( s )
+	}
+
+	var sf, hl int
+	var nbits uint
+	switch {
+		case n < 1 << 10 && csize < 1 << 10:
+			sf, hl, nbits = 1, 3, 10
+
+		case n < 1 << 14 && csize < 1 << 14:
+			sf, hl, nbits = 2, 4, 14
+
+		case n < 1 << 18 && csize < 1 << 18:
+			sf, hl, nbits = 3, 5, 18
+
+		default:
+			return nil
+	}
+
+	h := uint64( 2 | sf << 2 ) | uint64( n ) << 4 | uint64( csize ) << (4 + nbits)
+	out := make( []byte, 0, hl + csize )
+	for i := 0; i < hl; i++ {
+		out = append( out, byte( h >> (8 * i) ) )
+	}
+	out = append( out, desc... )
+	for i := 0; i < 3; i++ {
+		out = binary.LittleEndian.AppendUint16( out, uint16( len( streams[i] ) ) )
+	}
+	for _, s := range streams {
+		out = append( out, s... )
+	}
+
+	return out
+}
+
+/*
+	Return the offset value to send for the offset, using a repeat code when it is one
+	of the recent offsets, and update the repeat offsets as the reader will.
+*/
+func offset_value( off int, ll int, rep *[3]int ) ( int ) {
+	switch {
+		case ll > 0 && off == rep[0]:
+			return 1
+
+		case off == rep[1]:
+			rep[0], rep[1] = rep[1], rep[0]
+			if ll == 0 {
+				return 1
+			}
+			return 2
+
+		case off == rep[2]:
+			*rep = [3]int{ rep[2], rep[0], rep[1] }
+			if ll == 0 {
+				return 2
+			}
+			return 3
+
+		case ll == 0 && off == rep[0] - 1:
+			*rep = [3]int{ off, rep[0], rep[1] }
+			return 3
+	}
+
+	*rep = [3]int{ off, rep[0], rep[1] }
+	return off + 3
+}
+
+/*
+	Return the normalised distribution, with an accuracy log of alog, for the counts;
+	nil if one can't be made.  A symbol too rare for a state of its own gets -1.
+*/
+func fse_normalise( count []int, total int, alog uint ) ( []int16 ) {
+	scale := 1 << alog
+	norm := make( []int16, len( count ) )
+	largest := 0
+	top := 0
+	sum := 0
+	for s, c := range count {
+		if c == 0 {
+			continue
+		}
+		top = s
+		if c > count[largest] {
+			largest = s
+		}
+
+		if n := (c * scale + total / 2) / total; n > 0 {
+			norm[s] = int16( n )
+			sum += n
+		} else {
+			norm[s] = -1
+			sum++
+		}
+	}
+
+	if norm[largest] < 1 || int( norm[largest] ) + scale - sum < 1 {
+		return nil
+	}
+	norm[largest] += int16( scale - sum )						// rounding is made up by the commonest
+
+	return norm[:top+1]
+}
+
+/*
+	Return the table description for a distribution; read_fse_table() reverses this.
+*/
+func put_fse_table( norm []int16, alog uint ) ( []byte ) {
+	bw := &wbits{}
+	bw.add( uint64( alog - 5 ), 4 )
+
+	remaining := 1 << alog + 1
+	threshold := 1 << alog
+	nbits := alog + 1
+	zero := false
+	for s := 0; s < len( norm ) && remaining > 1; {
+		if zero {													// how many more zeros, in twos bits
+			start := s
+			for norm[s] == 0 {
+				s++
+			}
+			for ; s >= start + 3; start += 3 {
+				bw.add( 3, 2 )
+			}
+			bw.add( uint64( s - start ), 2 )
+		}
+
+		count := int( norm[s] )
+		s++
+		max := 2 * threshold - 1 - remaining
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+
+		count++
+		if count >= threshold {
+			count += max
+		}
+		n := nbits
+		if count < max {
+			n--
+		}
+		bw.add( uint64( count ), n )
+		zero = count == 1
+
+		for remaining < threshold {
+			nbits--
+			threshold >>= 1
+		}
+	}
+
+	if bw.n > 0 {
+		bw.out = append( bw.out, byte( bw.acc ) )
+	}
+	return bw.out
+}
+
+/*
+	Return the bits needed to code the symbols with the distribution.
+*/
+func fse_cost( count []int, norm []int16, alog uint ) ( float64 ) {
+	cost := 0.0
+	for s, c := range count {
+		if c == 0 {
+			continue
+		}
+		if s >= len( norm ) || norm[s] == 0 {
+			return math.Inf( 1 )
+		}
+		cost += float64( c ) * (float64( alog ) - math.Log2( float64( max( norm[s], 1 ) ) ))
+	}
+
+	return cost
+}
+
+/*
+	Choose how to code one of the sequence codes returning the encoding tables, the
+	mode and the table description: a single symbol (rle), a table built for the block
+	if it pays for its description, or the predefined table.
+*/
+func seq_encoding( codes []uint8, def *fse_enc, def_norm []int16, max_log uint ) ( *fse_enc, byte, []byte ) {
+	count := make( []int, len( def_norm ) )
+	used := 0
+	for _, c := range codes {
+		if count[c] == 0 {
+			used++
+		}
+		count[c]++
+	}
+
+	if used == 1 {
+		c := codes[0]
+		return mk_fse_enc( []fse_entry{ { sym: c } }, int( c ) + 1 ), 1, []byte{ c }
+	}
+
+	alog := min( max_log, max( uint( bits.Len( uint( len( codes ) ) ) ), 5 ) )
+	for used > 1 << alog {
+		alog++
+	}
+	norm := fse_normalise( count, len( codes ), alog )
+	if norm != nil {
+		desc := put_fse_table( norm, alog )
+		if fse_cost( count, norm, alog ) + float64( 8 * len( desc ) ) < fse_cost( count, def_norm, fse_log( def.tab ) ) {
+			if tab, err := build_fse( norm, alog ); err == nil {
+				return mk_fse_enc( tab, len( norm ) ), 2, desc
+			}
+		}
+	}
+
+	return def, 0, nil
+}
+
+/*
+	Append the sequences section. Rep holds the repeat offsets and is updated.
+*/
+func put_sequences( out []byte, seqs []zseq, rep *[3]int ) ( []byte ) {
+	n := len( seqs )
+	switch {
+		case n < 128:
+			out = append( out, byte( n ) )
+
+		case n < 0x7f00:
+			out = append( out, byte( n >> 8 + 128 ), byte( n ) )
+
+		default:
+			out = append( out, 255, byte( n - 0x7f00 ), byte( (n - 0x7f00) >> 8 ) )
+	}
+	if n == 0 {
+		return out
+	}
+
+	llc := make( []uint8, n )
+	mlc := make( []uint8, n )
+	ofc := make( []uint8, n )
+	ofv := make( []int, n )
+	for i, s := range seqs {
+		llc[i] = len_code( s.ll, ll_base, 16 )
+		mlc[i] = len_code( s.ml, ml_base, 32 )
+		ofv[i] = offset_value( s.off, s.ll, rep )
+		ofc[i] = uint8( bits.Len( uint( ofv[i] ) ) - 1 )
+	}
+
+	ll, ll_mode, ll_desc := seq_encoding( llc, ll_enc, ll_def_norm, 9 )
+	of, of_mode, of_desc := seq_encoding( ofc, of_enc, of_def_norm, 8 )
+	ml, ml_mode, ml_desc := seq_encoding( mlc, ml_enc, ml_def_norm, 9 )
+	out = append( out, ll_mode << 6 | of_mode << 4 | ml_mode << 2 )
+	out = append( append( append( out, ll_desc... ), of_desc... ), ml_desc... )
+
+	extras := func( bw *wbits, i int ) {						// the reader takes offset, match, literal
+		s := seqs[i]
+		bw.add( uint64( s.ll ) - uint64( ll_base[llc[i]] ), uint( ll_bits[llc[i]] ) )
+		bw.add( uint64( s.ml ) - uint64( ml_base[mlc[i]] ), uint( ml_bits[mlc[i]] ) )
+		bw.add( uint64( ofv[i] ), uint( ofc[i] ) )
+	}
+
+	bw := &wbits{ out: out }
+	last := n - 1
+	lls := ll.first[llc[last]]
+	mls := ml.first[mlc[last]]
+	ofs := of.first[ofc[last]]
+	extras( bw, last )
+	for i := last - 1; i >= 0; i-- {
+		ofs = of.step( bw, ofs, ofc[i] )						// the reader updates literal, match, offset
+		mls = ml.step( bw, mls, mlc[i] )
+		lls = ll.step( bw, lls, llc[i] )
+		extras( bw, i )
+	}
+	bw.add( uint64( mls ), fse_log( ml.tab ) )					// the reader starts with literal, offset, match
+	bw.add( uint64( ofs ), fse_log( of.tab ) )
+	bw.add( uint64( lls ), fse_log( ll.tab ) )
+
+	return bw.close()
+}
@@ -27,6 +27,14 @@
 
 	Mods:		30 Nov 2014 - allows for comments on key = value lines.
 				11 Nov 2015 - Added ability to use += 
+				18 Oct 2026 - Parse state moved to a parser struct; values are expanded
+					after the file and all includes have been read. The origin of
+					each value is recorded. Includes are relative to the including
+					file, may be globs or optional, and cycles are detected.
+					Added line continuation, list values and heredocs. A last line
+					without a newline is no longer ignored. Added strict mode
+					(Parse_strict). A quote which isn't closed no longer panics.
+				18 Oct 2026 - Comments in lists; text after a list is diagnosed.
 */
 
 /*
@@ -35,9 +43,11 @@
 package config
 
 import (
-	"bufio"
-	"io"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/att/gopkgs/clike"
@@ -47,6 +57,184 @@ import (
 
 // --------------------------------------------------------------------------------------
 
+const (
+	MAX_INCLUDE_DEPTH	int = 16			// include directives may not nest deeper than this
+)
+
+const (
+	OP_SET		string = "set"			// key was given a value for the first time
+	OP_APPEND	string = "append"		// value was added to with +=
+	OP_OVERRIDE	string = "override"		// a previous value was replaced
+)
+
+/*
+	Origin records where, and how, a key was given a value.  Each time a key is set the
+	origin is added to the key's history so that it is possible to see how the final
+	value came to be when includes, duplicate sections and += are involved.
+*/
+type Origin struct {
+	Fname	string		`json:"file"`
+	Line	int			`json:"line"`
+	Op		string		`json:"op"`		// one of the OP_ constants
+
+	verbatim	bool					// value came from a heredoc and must not be expanded
+}
+
+/*
+	The history of each key by section.
+*/
+type provenance map[string]map[string][]*Origin
+
+/*
+	Add an origin to the history of the key in the named section.
+*/
+func (pv provenance) add( sname string, key string, o *Origin ) {
+	if pv[sname] == nil {
+		pv[sname] = make( map[string][]*Origin )
+	}
+
+	pv[sname][key] = append( pv[sname][key], o )
+}
+
+/*
+	Return the origin of the current value for the key, or nil if the key has no history.
+*/
+func (pv provenance) last( sname string, key string ) ( *Origin ) {
+	h := pv[sname][key]
+	if len( h ) == 0 {
+		return nil
+	}
+
+	return h[len( h )-1]
+}
+
+/*
+	Return the section names with history in sorted order.
+*/
+func (pv provenance) sect_names( ) ( names []string ) {
+	names = make( []string, 0, len( pv ) )
+	for sname := range pv {
+		names = append( names, sname )
+	}
+	sort.Strings( names )
+
+	return
+}
+
+/*
+	Return the names of keys with history in the section in sorted order.
+*/
+func (pv provenance) key_names( sname string ) ( names []string ) {
+	names = make( []string, 0, len( pv[sname] ) )
+	for key := range pv[sname] {
+		names = append( names, key )
+	}
+	sort.Strings( names )
+
+	return
+}
+
+/*
+	State kept while a configuration file, and any files it includes, is parsed.
+*/
+type cfg_parser struct {
+	all_str	bool
+	prov	provenance			// history of each key read from a file, by section
+	files	[]string			// files read, main file first, in the order opened
+	stack	[]string			// files currently being parsed; used to detect include cycles
+	strict	bool				// collect diagnostics
+	diags	Diagnostics
+}
+
+/*
+	Create a parser.
+*/
+func mk_parser( all_str bool ) ( p *cfg_parser ) {
+	p = &cfg_parser {
+		all_str: all_str,
+		prov: make( provenance ),
+	}
+
+	return
+}
+
+/*
+	Return the absolute, cleaned, path for the file so that the same file reached by
+	different paths is recognised.  If the absolute path can't be determined the name
+	is returned as is.
+*/
+func abs_path( fname string ) ( string ) {
+	if ap, err := filepath.Abs( fname ); err == nil {
+		return ap
+	}
+
+	return filepath.Clean( fname )
+}
+
+/*
+	Process an include directive. Spec is the directive without the leading <, parent
+	and line are the file and line where the directive was found.  The spec is one of:
+		<file			the file must exist
+		<?file			the file is included if it exists, and skipped if not
+		<dir/*.cfg		all files matching the pattern, in sorted order; no match is ok
+
+	A name which is not absolute is relative to the directory of the parent file.
+	Including a file which is already being parsed (a cycle), or nesting includes
+	deeper than MAX_INCLUDE_DEPTH, is an error.
+*/
+func (p *cfg_parser) include( m map[string]map[string]interface{}, spec string, parent string, line int ) ( map[string]map[string]interface{}, error ) {
+	optional := false
+	if strings.HasPrefix( spec, "?" ) {
+		optional = true
+		spec = spec[1:]
+	}
+	spec = strings.Trim( spec, " \t" )
+
+	if ! filepath.IsAbs( spec ) {
+		spec = filepath.Join( filepath.Dir( parent ), spec )
+	}
+
+	var fnames []string
+	if strings.ContainsAny( spec, "*?[" ) {
+		matches, err := filepath.Glob( spec )
+		if err != nil {
+			return m, fmt.Errorf( "%s:%d: bad include pattern: %s: %s", parent, line, spec, err )
+		}
+		sort.Strings( matches )
+		fnames = matches
+		p.files = append( p.files, filepath.Dir( spec ) )		// directory change (file added/removed) should trigger a watcher
+	} else {
+		if _, err := os.Stat( spec ); err != nil {
+			if optional && os.IsNotExist( err ) {
+				p.files = append( p.files, spec )					// watcher should notice if it appears
+				return m, nil
+			}
+			return m, fmt.Errorf( "%s:%d: unable to include %s: %s", parent, line, spec, err )
+		}
+		fnames = []string{ spec }
+	}
+
+	for _, fname := range fnames {
+		if len( p.stack ) >= MAX_INCLUDE_DEPTH {
+			return m, fmt.Errorf( "%s:%d: includes nested more than %d deep: %s", parent, line, MAX_INCLUDE_DEPTH, fname )
+		}
+
+		afname := abs_path( fname )
+		for i, sfname := range p.stack {
+			if sfname == afname {
+				return m, fmt.Errorf( "%s:%d: include cycle: %s -> %s", parent, line, strings.Join( p.stack[i:], " -> " ), afname )
+			}
+		}
+
+		var err error
+		if m, err = p.parse( m, fname ); err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
 /*
 	Parses a configuration file containing sections and key/value pairs within the
 	sections.  Returns a map of sections (by name) with each entry in the map being
@@ -58,16 +246,98 @@ import (
 	must be uniqueue.  If a duplicate key is encountered, the last one read will be the
 	one that ends up in the map.
 
+	Lines beginning with < include other files (see include() for details).
+
+	A line ending with a backslash is continued on the next line. A value given as a
+	list in square brackets is returned as a []string:
+		hosts = [ host1, host2, "host 3" ]
+
+	Elements are separated by commas or whitespace and must be quoted if they contain
+	either; the list may span lines without the need for backslashes, and each line may
+	end with a comment.  Anything other than a comment after the closing bracket is
+	ignored (and reported by Parse_strict).  Appending (+=) a list, or a single value,
+	to a list adds the elements to the end.  A value may also be given as a heredoc
+	which is taken verbatim, newlines included, up to the line containing only the tag:
+		cert = <<EOF
+		-----BEGIN CERTIFICATE-----
+		...
+		EOF
+
 	If all_str is true, then all values are returned as strings; no attempt is made
 	to convert values that seem to be numeric into actual values as it  might make logic
 	in the user programme a bit easier (no interface dreferences).
+
+	Once the file, and all included files, have been read, references in values of the
+	form ${ENV:name}, ${section.key} and ${key} are expanded (see expand.go). To have a
+	literal ${ in a value write $${; it is replaced with ${ and not expanded:
+		cmd = echo $${HOME}			# the value is: echo ${HOME}
 */
 func Parse( sectmap map[string]map[string]interface{}, fname string, all_str bool ) ( m map[string]map[string]interface{}, err error ) {
+	m, _, err = parse_all( sectmap, fname, all_str )
+	return
+}
+
+/*
+	Parse the file and expand references, returning the parser as well as the map so
+	that the caller has access to the information collected during the parse.
+*/
+func parse_all( sectmap map[string]map[string]interface{}, fname string, all_str bool ) ( m map[string]map[string]interface{}, p *cfg_parser, err error ) {
+	p = mk_parser( all_str )
+	m, err = p.run( sectmap, fname )
+	return
+}
+
+/*
+	Parse the file and expand references. In strict mode any diagnostics are returned
+	as the error if there was no other error.
+*/
+func (p *cfg_parser) run( sectmap map[string]map[string]interface{}, fname string ) ( m map[string]map[string]interface{}, err error ) {
+	m, err = p.parse( sectmap, fname )
+	if err != nil {
+		return
+	}
+
+	if err = p.expand( m ); err != nil {
+		return
+	}
+
+	if len( p.diags ) > 0 {
+		err = p.diags
+	}
+	return
+}
+
+/*
+	Parse_strict parses the file in the same manner as Parse(), but problems which
+	Parse() silently ignores are collected and returned, all together, as a Diagnostics
+	error.  These are:
+		a key without a value (error; the key is ignored)
+		a quote which is not closed (error; the quote is closed at the end of the line)
+		a value which would be converted to a number, but isn't one (error; when
+			all_str is false a value starting with a digit or sign is converted and
+			anything which isn't a number, such as 1.2.3 or -v, becomes 0 or is truncated)
+		a key given more than once in a section in the same file (warning; the last
+			value is used)
+
+	The map is returned even when there are diagnostics and is the same map that
+	Parse() would return.  Other errors (e.g. a missing include file) are returned
+	as they are by Parse() and any diagnostics collected are lost.
+*/
+func Parse_strict( sectmap map[string]map[string]interface{}, fname string, all_str bool ) ( m map[string]map[string]interface{}, err error ) {
+	p := mk_parser( all_str )
+	p.strict = true
+
+	return p.run( sectmap, fname )
+}
+
+/*
+	Does the real work of parsing the file; recurses to handle included files.
+*/
+func (p *cfg_parser) parse( sectmap map[string]map[string]interface{}, fname string ) ( m map[string]map[string]interface{}, err error ) {
 	var (
 		rec		string;							// record read from input file
 		sect	map[string]interface{};			// current section
 		sname	string;							// current section name
-		rerr	error = nil;					// read error
 	)
 
 	if sectmap != nil {
@@ -83,92 +353,182 @@ func Parse( sectmap map[string]map[string]interface{}, fname string, all_str boo
 	sname = "default";
 	sect = m[sname];				// always start in default section
 
+	p.files = append( p.files, fname )		// recorded even if it can't be opened so a watcher can notice when it appears
 	f, err := os.Open( fname );
 	if err != nil {
 		return;
 	}
 	defer f.Close( );
 
-	br := bufio.NewReader( f );
-	for ; rerr == nil ; {
-		rec, rerr = br.ReadString( '\n' );
-		if rerr == nil {
-			rec = strings.Trim( rec, " \t\n" );				// ditch lead/trail whitespace
+	p.stack = append( p.stack, abs_path( fname ) )
+	defer func() { p.stack = p.stack[:len( p.stack )-1] }()
 
-			if len( rec ) == 0 { 					// blank line
-				continue;
-			}
+	rr := mk_rec_reader( f, fname )
+	for {
+		cr, eof, rerr := rr.next( )			// next logical record; continuations, lists and heredocs are gathered
+		if rerr != nil {
+			return m, rerr
+		}
+		if eof {
+			break
+		}
 
-			switch rec[0] {
-				case ':':							// section
-					//sname = rec[1:];
-					_, tokens := token.Tokenise_qpopulated( rec, " \t" )		// easy way to ditch everything after the first token
-					sname = tokens[0][1:]
-					if m[sname]  == nil {
-						sect = make( map[string]interface{} );
-						m[sname] = sect;
-					} else {
-						sect = m[sname];
-					}
+		rec = cr.rec
+		if len( rec ) == 0 { 					// blank line
+			continue;
+		}
 
-				case '#':							// comment
-					// nop
+		if q := unclosed_quote( rec ); q >= 0 && rec[0] != '#' {				// close it; the tokeniser can't cope
+			p.diag( fname, cr.line, col_of( cr.raw, "" ) + q, SEV_ERROR, "quote is not closed" )
+			rec += "\""
+		}
 
-				case '<':
-					m, err = Parse( m, rec[1:], all_str );
-					if err != nil {
-						return;
+		switch rec[0] {
+			case ':':							// section
+				//sname = rec[1:];
+				_, tokens := token.Tokenise_qpopulated( rec, " \t" )		// easy way to ditch everything after the first token
+				sname = tokens[0][1:]
+				if m[sname]  == nil {
+					sect = make( map[string]interface{} );
+					m[sname] = sect;
+				} else {
+					sect = m[sname];
+				}
+
+			case '#':							// comment
+				// nop
+
+			case '<':
+				m, err = p.include( m, rec[1:], fname, cr.line )
+				if err != nil {
+					return;
+				}
+
+			default:							// assume key value pair
+				append := false
+				first_tok := 1					// first token of var is 1, but could be later
+
+				ntokens, tokens := token.Tokenise_qpopulated( rec, " \t=" )
+				if ntokens >= 2 {				// if key = "value" # [comment],  n will be 3 or more
+					tl := len( tokens[0] )
+
+					if tokens[0][tl-1:] == "+" {				//foo+= bar rather than foo = bar or foo += bar
+						tokens[0] = tokens[0][:tl-1]
+						append = true
+					} else {
+						if tokens[1] == "+" {					// += results in lone plus, not to be confused with +9999
+							append = true
+							first_tok++
+						}
 					}
 
-				default:							// assume key value pair
-					append := false
-					first_tok := 1					// first token of var is 1, but could be later
+					if first_tok >= ntokens {					// foo += with nothing after; treat as a lone key
+						p.diag( fname, cr.line, col_of( cr.raw, "" ), SEV_ERROR, "nothing follows += for key %s; ignored", tokens[0] )
+						continue
+					}
 
-					ntokens, tokens := token.Tokenise_qpopulated( rec, " \t=" )
-					if ntokens >= 2 {				// if key = "value" # [comment],  n will be 3 or more
-						tl := len( tokens[0] )
+					key := tokens[0]
+					if prev := p.prov.last( sname, key ); prev != nil && prev.Fname == fname && ! append {
+						p.diag( fname, cr.line, col_of( cr.raw, key ), SEV_WARNING, "duplicate key %s in section %s replaces the value set on line %d", key, sname, prev.Line )
+					}
 
-						if tokens[0][tl-1:] == "+" {				//foo+= bar rather than foo = bar or foo += bar
-							tokens[0] = tokens[0][:tl-1]
-							append = true
-						} else {
-							if tokens[1] == "+" {					// += results in lone plus, not to be confused with +9999
-								append = true
-								first_tok++
-							}
+					op := OP_SET
+					if _, had := sect[key]; had {
+						op = OP_OVERRIDE
+						if append {
+							op = OP_APPEND
 						}
+					}
 
-						key := tokens[0]
-						if tokens[first_tok] == "" {		// key = (missing value) given
-							tokens[first_tok] = " "
-						}
-						fc := tokens[first_tok][0:1]
-						if ! all_str && ((fc >= "0"  && fc <= "9") || fc == "+" || fc == "-") {		// allowed to convert numbers to float
-							sect[key] = clike.Atof( tokens[first_tok] );
-						} else {
-							dup := ""
-							sep := ""
-							for i := first_tok; i < ntokens && tokens[i][0:1] != "#"; i++ {
-								dup += sep + tokens[i]									// snarf tokens up to comment reducing white space to 1 blank
-								sep = " "
-							}
+					rv := raw_value( rec )
+					switch {
+						case cr.heredoc != nil:						// heredoc values are taken verbatim and never converted
+							dup := *cr.heredoc
 							if append && sect[key] != nil {
 								if old_str, ok := sect[key].(*string); ok {
-									dup = *old_str + " " + dup
+									dup = *old_str + "\n" + dup
 								}
 							}
 							sect[key] = &dup
-						}		
-					}								// silently discard token that is just a key, allowing the default to override
-			}
+
+						case strings.HasPrefix( rv, "[" ):			// list value
+							list, rest, lerr := parse_list( rv )
+							if lerr != nil {
+								return m, fmt.Errorf( "%s:%d: %s", fname, cr.line, lerr )
+							}
+							if rest != "" {
+								p.diag( fname, cr.line, col_of( cr.raw, rest ), SEV_ERROR, "text after the list for %s is ignored: %s", key, rest )
+							}
+							if append {
+								list = append_list( sect[key], list )
+							}
+							sect[key] = list
+
+						default:
+							if tokens[first_tok] == "" {		// key = (missing value) given
+								tokens[first_tok] = " "
+							}
+							fc := tokens[first_tok][0:1]
+							if ! p.all_str && ((fc >= "0"  && fc <= "9") || fc == "+" || fc == "-") {		// allowed to convert numbers to float
+								if nerr := num_check( tokens[first_tok] ); nerr != nil {
+									p.diag( fname, cr.line, col_of( cr.raw, tokens[first_tok] ), SEV_ERROR, "value for %s is not a number (%s): %s becomes %v",
+										key, nerr, tokens[first_tok], clike.Atof( tokens[first_tok] ) )
+								} else {
+									if first_tok + 1 < ntokens && ! strings.HasPrefix( tokens[first_tok+1], "#" ) {
+										p.diag( fname, cr.line, col_of( cr.raw, tokens[first_tok+1] ), SEV_ERROR, "text after the number for %s is ignored: %s", key, tokens[first_tok+1] )
+									}
+								}
+								sect[key] = clike.Atof( tokens[first_tok] );
+							} else {
+								dup := ""
+								sep := ""
+								for i := first_tok; i < ntokens && tokens[i][0:1] != "#"; i++ {
+									dup += sep + tokens[i]									// snarf tokens up to comment reducing white space to 1 blank
+									sep = " "
+								}
+								if append && sect[key] != nil {
+									if old_str, ok := sect[key].(*string); ok {
+										dup = *old_str + " " + dup
+									}
+								}
+								if old_list, ok := sect[key].([]string); ok && append {		// adding a single value to a list
+									sect[key] = append_list( old_list, []string{ dup } )
+								} else {
+									sect[key] = &dup
+								}
+							}
+					}
+					p.prov.add( sname, key, &Origin{ Fname: fname, Line: cr.line, Op: op, verbatim: cr.heredoc != nil } )
+				} else {						// silently discard token that is just a key, allowing the default to override
+					p.diag( fname, cr.line, col_of( cr.raw, "" ), SEV_ERROR, "key has no value: %s; ignored", tokens[0] )
+				}
 		}
 	}
 
-	if rerr != io.EOF {
-		err = rerr;
+	return;
+}
+
+/*
+	Append the list to the old value. If the old value is a string it becomes the
+	first element of the new list.
+*/
+func append_list( old interface{}, list []string ) ( []string ) {
+	switch ov := old.( type ) {
+		case []string:
+			nl := make( []string, 0, len( ov ) + len( list ) )
+			nl = append( nl, ov... )
+			return append( nl, list... )
+
+		case *string:
+			if ov != nil {
+				return append( []string{ *ov }, list... )
+			}
+
+		case float64:
+			return append( []string{ strconv.FormatFloat( ov, 'f', -1, 64 ) }, list... )
 	}
 
-	return;
+	return list
 }
 
 /*
@@ -179,6 +539,8 @@ func Parse( sectmap map[string]map[string]interface{}, fname string, all_str boo
 	This may be easier for the caller to use as the returned values can be referenced
 	with this syntax (assuming m is the returned map):
 		m[sect][key]
+
+	List values are returned as a single string with the elements separated by spaces.
 */
 func Parse2strs( sectmap map[string]map[string]*string, fname string ) ( m map[string]map[string]*string, err error ) {
 	var (
@@ -204,7 +566,14 @@ func Parse2strs( sectmap map[string]map[string]*string, fname string ) ( m map[s
 		}
 
 		for k, v := range smap {
-			m[sect][k] = v.(*string);
+			switch tv := v.( type ) {
+				case *string:
+					m[sect][k] = tv
+
+				case []string:								// lists are flattened as if built with +=
+					s := strings.Join( tv, " " )
+					m[sect][k] = &s
+			}
 		}
 	}
 
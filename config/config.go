@@ -0,0 +1,300 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	config
+	Abstract:	A config object which wraps the parsed section maps and provides
+				typed access to the values with defaults.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/att/gopkgs/clike"
+)
+
+/*
+	A parsed configuration. Values are kept as the strings read from the file and are
+	converted when fetched by one of the Get_ functions.
+*/
+type Config struct {
+	sects	map[string]map[string]interface{}
+	prov	provenance
+}
+
+/*
+	Value_error is returned by the Get_ functions when the value in the config cannot be
+	converted to the requested type.
+*/
+type Value_error struct {
+	Sect	string			// section and key of the value
+	Key		string
+	Value	string			// the value as read from the file
+	Want	string			// the type that was requested (int, bool, etc.)
+	Fname	string			// file and line the value was read from; empty/0 if not known
+	Line	int
+	Err		error			// underlying conversion error
+}
+
+func (e *Value_error) Error( ) ( string ) {
+	where := ""
+	if e.Fname != "" {
+		where = fmt.Sprintf( "%s:%d: ", e.Fname, e.Line )
+	}
+
+	return fmt.Sprintf( "%s%s.%s: cannot convert (%s) to %s: %s", where, e.Sect, e.Key, e.Value, e.Want, e.Err )
+}
+
+/*
+	Mk_config parses the named file and returns a config object which can be used to
+	fetch values. Values are not converted at parse time; see Parse() for a description
+	of the file syntax.
+*/
+func Mk_config( fname string ) ( c *Config, err error ) {
+	m, p, err := parse_all( nil, fname, true )
+	if err != nil {
+		return nil, err
+	}
+
+	c = &Config {
+		sects: m,
+		prov: p.prov,
+	}
+
+	return
+}
+
+/*
+	Mk_config_strict parses the named file in strict mode (see Parse_strict()). If there
+	are diagnostics, the config is returned along with the Diagnostics error so that the
+	caller can decide whether to continue using it.
+
+	As with Mk_config(), values are kept as strings (all_str is true) because the type
+	isn't known until a Get_ function is called; a value such as -v or 1.2.3 is a good
+	string.  This means that the numeric diagnostics of Parse_strict() are never given
+	here; a value which can't be converted is reported, with the file and line, as a
+	Value_error by the Get_ function.
+*/
+func Mk_config_strict( fname string ) ( c *Config, err error ) {
+	p := mk_parser( true )
+	p.strict = true
+
+	m, err := p.run( nil, fname )
+	if _, is_diag := err.( Diagnostics ); err != nil && ! is_diag {
+		return nil, err
+	}
+
+	c = &Config {
+		sects: m,
+		prov: p.prov,
+	}
+
+	return c, err
+}
+
+/*
+	Get_sections returns the map of sections. Values are string pointers, or []string
+	for list values.
+*/
+func (c *Config) Get_sections( ) ( map[string]map[string]interface{} ) {
+	if c == nil {
+		return nil
+	}
+
+	return c.sects
+}
+
+/*
+	Look up the raw string for a key. Ok is false if the section or key does not exist.
+*/
+func (c *Config) lookup( sect string, key string ) ( s string, ok bool ) {
+	if c == nil || c.sects[sect] == nil {
+		return "", false
+	}
+
+	v, ok := c.sects[sect][key]
+	if ! ok {
+		return "", false
+	}
+
+	switch tv := v.( type ) {
+		case *string:
+			if tv == nil {
+				return "", false
+			}
+			s = *tv
+
+		case float64:
+			s = strconv.FormatFloat( tv, 'f', -1, 64 )
+
+		case []string:
+			s = strings.Join( tv, " " )
+
+		default:
+			s = fmt.Sprintf( "%v", tv )
+	}
+
+	return s, true
+}
+
+/*
+	Build a conversion error for the key filling in the location if we know it.
+*/
+func (c *Config) mk_verr( sect string, key string, value string, want string, err error ) ( *Value_error ) {
+	ve := &Value_error {
+		Sect: sect,
+		Key: key,
+		Value: value,
+		Want: want,
+		Err: err,
+	}
+
+	if loc := c.prov.last( sect, key ); loc != nil {
+		ve.Fname = loc.Fname
+		ve.Line = loc.Line
+	}
+
+	return ve
+}
+
+/*
+	Get_string returns the value of the key in the section, or def if the key is not
+	defined. The error is always nil, and is returned only to be consistent with the
+	other Get_ functions.
+*/
+func (c *Config) Get_string( sect string, key string, def string ) ( string, error ) {
+	s, ok := c.lookup( sect, key )
+	if ! ok {
+		return def, nil
+	}
+
+	return s, nil
+}
+
+/*
+	Get_list returns the value of the key as a list of strings, or def if the key is not
+	defined.  A list value ([a, b]) is returned as is; any other value is split on
+	whitespace.  The error is always nil.
+*/
+func (c *Config) Get_list( sect string, key string, def []string ) ( []string, error ) {
+	if c != nil && c.sects[sect] != nil {
+		if list, ok := c.sects[sect][key].( []string ); ok {
+			return list, nil
+		}
+	}
+
+	s, ok := c.lookup( sect, key )
+	if ! ok {
+		return def, nil
+	}
+
+	return strings.Fields( s ), nil
+}
+
+/*
+	Get_int returns the value of the key as an integer, or def if the key is not defined.
+	Values may be decimal, or hex/octal using a leading 0x or 0; anything else results in
+	a Value_error.
+*/
+func (c *Config) Get_int( sect string, key string, def int ) ( int, error ) {
+	s, ok := c.lookup( sect, key )
+	if ! ok {
+		return def, nil
+	}
+
+	v, err := strconv.ParseInt( s, 0, 0 )
+	if err != nil {
+		return def, c.mk_verr( sect, key, s, "int", err )
+	}
+
+	return int( v ), nil
+}
+
+/*
+	Get_bool returns the value of the key as a boolean, or def if the key is not defined.
+	True/false, yes/no, on/off and 1/0 are accepted without regard to case.
+*/
+func (c *Config) Get_bool( sect string, key string, def bool ) ( bool, error ) {
+	s, ok := c.lookup( sect, key )
+	if ! ok {
+		return def, nil
+	}
+
+	switch strings.ToLower( s ) {
+		case "true", "yes", "on", "1":
+			return true, nil
+
+		case "false", "no", "off", "0":
+			return false, nil
+	}
+
+	return def, c.mk_verr( sect, key, s, "bool", fmt.Errorf( "not one of true/false, yes/no, on/off, 1/0" ) )
+}
+
+/*
+	Get_duration returns the value of the key as a duration, or def if the key is not
+	defined. The value may be anything time.ParseDuration() accepts (e.g. 5m, 1h30m);
+	a value without a unit is taken to be seconds.
+*/
+func (c *Config) Get_duration( sect string, key string, def time.Duration ) ( time.Duration, error ) {
+	s, ok := c.lookup( sect, key )
+	if ! ok {
+		return def, nil
+	}
+
+	if v, err := strconv.ParseFloat( s, 64 ); err == nil {
+		return time.Duration( v * float64( time.Second ) ), nil
+	}
+
+	d, err := time.ParseDuration( s )
+	if err != nil {
+		return def, c.mk_verr( sect, key, s, "duration", err )
+	}
+
+	return d, nil
+}
+
+/*
+	Get_size returns the value of the key as a byte count, or def if the key is not
+	defined. The value is a number which may be followed by one of the unit suffixes
+	supported by the clike package (K, KB, M, MB, G, GB for powers of ten and k, KiB,
+	m, MiB, g, GiB for powers of two).  Unlike clike.Atoll() the whole value must be
+	valid, so 10GiB converts, but 10Gigs results in an error.
+*/
+func (c *Config) Get_size( sect string, key string, def int64 ) ( int64, error ) {
+	s, ok := c.lookup( sect, key )
+	if ! ok {
+		return def, nil
+	}
+
+	if err := num_check( s ); err != nil {
+		return def, c.mk_verr( sect, key, s, "size", err )
+	}
+
+	return int64( clike.Atof( s ) ), nil
+}
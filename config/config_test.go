@@ -22,10 +22,16 @@ package config_test
 
 import (
 	"testing"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/att/gopkgs/config"
+	"github.com/att/gopkgs/ipc"
 )
 
 func TestConfig( t *testing.T ) {
@@ -99,3 +105,742 @@ fmt.Fprintf( os.Stderr, ">>>>> parsing\n" )
 	fmt.Fprintf( os.Stderr, "ffoo=== (%s)\n", *sects["default"]["ffoo"] );
 }
 
+/*
+	Write a small config file into the test's temp directory and return its name.
+*/
+func mk_cfg( t *testing.T, dir string, name string, contents string ) ( string ) {
+	fname := filepath.Join( dir, name )
+	err := os.WriteFile( fname, []byte( contents ), 0644 )
+	if err != nil {
+		t.Fatalf( "unable to create test config %s: %s", fname, err )
+	}
+
+	return fname
+}
+
+/*
+	Test expansion of environment and key references.
+*/
+func TestExpand( t *testing.T ) {
+	dir := t.TempDir()
+	t.Setenv( "CFG_TEST_HOME", "/home/tegu" )
+
+	fname := mk_cfg( t, dir, "expand.cfg", `
+base = ${ENV:CFG_TEST_HOME}
+log_dir = ${base}/logs
+port = 4000
+<` + filepath.Join( dir, "expand2.cfg" ) + `
+` )
+	mk_cfg( t, dir, "expand2.cfg", `
+:agent
+	ckpt_dir = ${log_dir}/ckpt
+	url = http://localhost:${default.port}/tegu
+	next_port = ${port}
+	port = 4001
+	cmd = echo $${HOME_NOPE} $${base}/${port}
+` )
+
+	sects, err := config.Parse( nil, fname, false )
+	if err != nil {
+		t.Fatalf( "parse failed: %s", err )
+	}
+
+	expect := map[string]string {
+		"default.log_dir": "/home/tegu/logs",
+		"agent.ckpt_dir": "/home/tegu/logs/ckpt",
+		"agent.url": "http://localhost:4000/tegu",
+		"agent.cmd": "echo ${HOME_NOPE} ${base}/4001",				// escaped references are left, and not an error
+	}
+	for k, v := range expect {
+		tokens := strings.SplitN( k, ".", 2 )
+		sv, ok := sects[tokens[0]][tokens[1]].( *string )
+		if !ok || *sv != v {
+			t.Errorf( "%s: expected (%s) got (%v)", k, v, sects[tokens[0]][tokens[1]] )
+		}
+	}
+
+	if fv, ok := sects["agent"]["next_port"].( float64 ); !ok || fv != 4001 {		// same section wins over default, and numbers convert
+		t.Errorf( "agent.next_port: expected 4001 got (%v)", sects["agent"]["next_port"] )
+	}
+}
+
+/*
+	Undefined references and cycles must be errors which name the file and line.
+*/
+func TestExpandErrors( t *testing.T ) {
+	dir := t.TempDir()
+
+	fname := mk_cfg( t, dir, "undef.cfg", "foo = bar\nxx = ${nosuch}/foo\n" )
+	_, err := config.Parse( nil, fname, false )
+	if err == nil || !strings.Contains( err.Error(), "undef.cfg:2:" ) {
+		t.Errorf( "expected undefined reference error at line 2, got: %v", err )
+	}
+
+	fname = mk_cfg( t, dir, "cycle.cfg", "a = ${b}\nb = ${c}\nc = x${a}\n" )
+	_, err = config.Parse2strs( nil, fname )
+	if err == nil || !strings.Contains( err.Error(), "cycle" ) {
+		t.Errorf( "expected reference cycle error, got: %v", err )
+	} else {
+		fmt.Fprintf( os.Stderr, "cycle error (expected): %s\n", err )
+	}
+}
+
+/*
+	Test the typed get functions on the config object.
+*/
+func TestConfigGet( t *testing.T ) {
+	dir := t.TempDir()
+	fname := mk_cfg( t, dir, "get.cfg", `
+:agent
+	workers = "12"
+	mask = 0x1f
+	debug = yes
+	period = 5m
+	timeout = 30
+	max_log = 10GiB
+	buf = 1.5k
+	bad_int = twelve
+	bad_size = 10Gigs
+` )
+
+	cfg, err := config.Mk_config( fname )
+	if err != nil {
+		t.Fatalf( "unable to load config: %s", err )
+	}
+
+	if v, err := cfg.Get_int( "agent", "workers", 1 ); err != nil || v != 12 {
+		t.Errorf( "workers: expected 12 got %d (%v)", v, err )
+	}
+	if v, err := cfg.Get_int( "agent", "mask", 1 ); err != nil || v != 31 {
+		t.Errorf( "mask: expected 31 got %d (%v)", v, err )
+	}
+	if v, err := cfg.Get_int( "agent", "missing", 7 ); err != nil || v != 7 {
+		t.Errorf( "missing: expected default 7 got %d (%v)", v, err )
+	}
+	if v, err := cfg.Get_bool( "agent", "debug", false ); err != nil || !v {
+		t.Errorf( "debug: expected true got %v (%v)", v, err )
+	}
+	if v, err := cfg.Get_duration( "agent", "period", 0 ); err != nil || v != 5 * time.Minute {
+		t.Errorf( "period: expected 5m got %v (%v)", v, err )
+	}
+	if v, err := cfg.Get_duration( "agent", "timeout", 0 ); err != nil || v != 30 * time.Second {
+		t.Errorf( "timeout: expected 30s got %v (%v)", v, err )
+	}
+	if v, err := cfg.Get_size( "agent", "max_log", 0 ); err != nil || v != 10 * 1073741824 {
+		t.Errorf( "max_log: expected 10GiB got %d (%v)", v, err )
+	}
+	if v, err := cfg.Get_size( "agent", "buf", 0 ); err != nil || v != 1536 {
+		t.Errorf( "buf: expected 1536 got %d (%v)", v, err )
+	}
+	if v, err := cfg.Get_string( "nosect", "foo", "dflt" ); err != nil || v != "dflt" {
+		t.Errorf( "nosect.foo: expected default got %s (%v)", v, err )
+	}
+
+	_, err = cfg.Get_int( "agent", "bad_int", 0 )
+	if ve, ok := err.( *config.Value_error ); !ok || ve.Line != 10 || ve.Fname != fname || ve.Sect != "agent" || ve.Key != "bad_int" {
+		t.Errorf( "bad_int: expected value error at line 10, got: %v", err )
+	} else {
+		fmt.Fprintf( os.Stderr, "value error (expected): %s\n", err )
+	}
+
+	_, err = cfg.Get_size( "agent", "bad_size", 0 )
+	if _, ok := err.( *config.Value_error ); !ok {
+		t.Errorf( "bad_size: expected value error, got: %v", err )
+	}
+}
+
+type Log_sect struct {
+	Dir		string		`cfg:"dir,required"`
+	Level	int			`cfg:"level,default=2"`
+}
+
+type Decode_test struct {
+	Name	string		`cfg:"name"`
+	Port	int			`cfg:"agent.port,required"`
+	Hosts	[]string	`cfg:"agent.hosts"`
+	Debug	bool		`cfg:"agent.debug,default=true"`
+	Owner	string		`cfg:"agent.owner,required"`
+	Log		Log_sect	`cfg:"log"`
+	Ckpt	*Log_sect	`cfg:"ckpt"`
+}
+
+/*
+	Test decoding into a tagged struct.
+*/
+func TestDecode( t *testing.T ) {
+	dir := t.TempDir()
+	fname := mk_cfg( t, dir, "decode.cfg", `
+name = tegu
+:agent
+	port = 29444
+	hosts = host1 host2
+	hosts += host3
+	owner = ops
+:log
+	dir = /var/log/tegu
+:ckpt
+	dir = /var/lib/tegu
+	level = 5
+` )
+
+	dt := &Decode_test{}
+	err := config.Decode( fname, dt )
+	if err != nil {
+		t.Fatalf( "decode failed: %s", err )
+	}
+
+	if dt.Name != "tegu" || dt.Port != 29444 || !dt.Debug || dt.Owner != "ops" {
+		t.Errorf( "simple fields not decoded: %+v", dt )
+	}
+	if len( dt.Hosts ) != 3 || dt.Hosts[1] != "host2" || dt.Hosts[2] != "host3" {
+		t.Errorf( "hosts not decoded as expected: %q", dt.Hosts )
+	}
+	if dt.Log.Dir != "/var/log/tegu" || dt.Log.Level != 2 {
+		t.Errorf( "log section not decoded as expected: %+v", dt.Log )
+	}
+	if dt.Ckpt == nil || dt.Ckpt.Dir != "/var/lib/tegu" || dt.Ckpt.Level != 5 {
+		t.Errorf( "ckpt section not decoded as expected: %+v", dt.Ckpt )
+	}
+
+	fname = mk_cfg( t, dir, "decode_bad.cfg", `
+name = tegu
+colour = blue
+:agent
+	hosts = host1
+	speed = 10
+:log
+	level = 3
+` )
+	err = config.Decode( fname, &Decode_test{} )
+	derr, ok := err.( *config.Decode_error )
+	if !ok {
+		t.Fatalf( "expected decode error, got: %v", err )
+	}
+	fmt.Fprintf( os.Stderr, "decode error (expected): %s\n", err )
+
+	if len( derr.Unknown ) != 2 || !strings.Contains( derr.Unknown[0], "decode_bad.cfg:6: agent.speed" ) {
+		t.Errorf( "expected two unknown keys, got: %q", derr.Unknown )
+	}
+	if len( derr.Missing ) != 4 {			// agent.port, agent.owner, log.dir, ckpt.dir (ckpt.level has a default)
+		t.Errorf( "expected four missing keys, got: %q", derr.Missing )
+	}
+}
+
+/*
+	Test the watcher: change an included file, then break it and ensure that the
+	last good config is kept.
+*/
+func TestWatcher( t *testing.T ) {
+	dir := t.TempDir()
+	inc := mk_cfg( t, dir, "watch_inc.cfg", ":agent\n\tport = 4000\n\tworkers = 2\n" )
+	fname := mk_cfg( t, dir, "watch.cfg", "name = tegu\n<" + inc + "\n" )
+
+	ch := make( chan *ipc.Chmsg, 10 )
+	w, err := config.Mk_watcher( fname, true, ch, 42 )
+	if err != nil {
+		t.Fatalf( "unable to create watcher: %s", err )
+	}
+
+	w.Check()								// nothing changed; nothing should be sent
+	if len( ch ) != 0 {
+		t.Fatalf( "watcher sent a message when nothing changed" )
+	}
+
+	mk_cfg( t, dir, "watch_inc.cfg", ":agent\n\tport = 4001\n\tdebug = true\n" )
+	future := time.Now().Add( 10 * time.Second )				// ensure mtime differs even on coarse filesystems
+	os.Chtimes( inc, future, future )
+	w.Check()
+
+	if len( ch ) != 1 {
+		t.Fatalf( "expected one message after change, got %d", len( ch ) )
+	}
+	msg := <-ch
+	changes, ok := msg.Req_data.( []*config.Change )
+	if msg.Msg_type != 42 || msg.State != nil || !ok || len( changes ) != 3 {
+		t.Fatalf( "unexpected change message: type=%d state=%v data=%v", msg.Msg_type, msg.State, msg.Req_data )
+	}
+	if changes[0].Key != "debug" || changes[0].Kind != config.CHG_ADDED ||
+		changes[1].Key != "port" || changes[1].Kind != config.CHG_CHANGED || *(changes[1].New.( *string )) != "4001" ||
+		changes[2].Key != "workers" || changes[2].Kind != config.CHG_REMOVED {
+		t.Errorf( "changes not as expected: %+v %+v %+v", changes[0], changes[1], changes[2] )
+	}
+
+	mk_cfg( t, dir, "watch.cfg", "name = tegu\n<" + inc + "\n<" + filepath.Join( dir, "nosuch.cfg" ) + "\n" )
+	future = future.Add( 10 * time.Second )
+	os.Chtimes( fname, future, future )
+	w.Check()
+
+	if len( ch ) != 1 {
+		t.Fatalf( "expected one message after bad change, got %d", len( ch ) )
+	}
+	msg = <-ch
+	if msg.State == nil || msg.Req_data != nil {
+		t.Errorf( "expected error message for bad config, got: state=%v data=%v", msg.State, msg.Req_data )
+	}
+	if v := w.Get_config()["agent"]["port"]; v == nil || *(v.( *string )) != "4001" {
+		t.Errorf( "last good config was not kept" )
+	}
+
+	w.Check()								// no further change, error must not be repeated
+	if len( ch ) != 0 {
+		t.Errorf( "parse error was reported more than once" )
+	}
+}
+
+/*
+	Test that origins are recorded and that the dump (both formats) shows them.
+*/
+func TestDump( t *testing.T ) {
+	dir := t.TempDir()
+	inc := mk_cfg( t, dir, "dump_inc.cfg", ":agent\n\tport = 4001\n\thosts += host2\n" )
+	fname := mk_cfg( t, dir, "dump.cfg", ":agent\n\tport = 4000\n\thosts = host1\n<" + inc + "\n:agent\n\tname = \"a b\"\n" +
+		":quoting\n\tgreet = \"say \\\"hi\\\"  \"\n\tdir = \"C:\\\\temp\\\\\"\n\tlist = [plain, \"q\\\"uote\", \"back\\\\slant\"]\n" +
+		"\tcmd = echo $${HOME_NOPE} $$${x}\n\trefs = [$${a}, \"$${b} c\"]\n" )
+
+	cfg, err := config.Mk_config( fname )
+	if err != nil {
+		t.Fatalf( "unable to load config: %s", err )
+	}
+
+	ol := cfg.Origins( "agent", "hosts" )
+	if len( ol ) != 2 || ol[0].Op != config.OP_SET || ol[0].Line != 3 || ol[1].Op != config.OP_APPEND || ol[1].Fname != inc {
+		t.Errorf( "hosts origins not as expected: %+v", ol )
+	}
+	ol = cfg.Origins( "agent", "port" )
+	if len( ol ) != 2 || ol[1].Op != config.OP_OVERRIDE || ol[1].Line != 2 {
+		t.Errorf( "port origins not as expected: %+v", ol )
+	}
+
+	sb := &strings.Builder{}
+	cfg.Dump( sb, config.DUMP_NATIVE )
+	fmt.Fprintf( os.Stderr, "%s", sb.String() )
+	if !strings.Contains( sb.String(), "hosts = \"host1 host2\"\t\t# " + fname + ":3 set, " + inc + ":3 append\n" ) ||
+		!strings.Contains( sb.String(), "name = \"a b\"" ) {
+		t.Errorf( "native dump not as expected" )
+	}
+
+	dname := mk_cfg( t, dir, "dumped.cfg", sb.String() )				// dump must parse back to the same values
+	dcfg, err := config.Mk_config( dname )
+	if err != nil {
+		t.Fatalf( "unable to parse dumped config: %s", err )
+	}
+	if len( config.Diff( cfg_map( t, fname ), cfg_map( t, dname ) ) ) != 0 {
+		t.Errorf( "dumped config does not parse to the same values" )
+	}
+	if v, _ := dcfg.Get_string( "agent", "hosts", "" ); v != "host1 host2" {
+		t.Errorf( "dumped hosts did not parse back: %s", v )
+	}
+	if v, _ := dcfg.Get_string( "quoting", "greet", "" ); v != `say "hi"  ` {
+		t.Errorf( "dumped value with quotes did not parse back: (%s)", v )
+	}
+	if v, _ := dcfg.Get_string( "quoting", "dir", "" ); v != `C:\temp\` {
+		t.Errorf( "dumped value with backslants did not parse back: (%s)", v )
+	}
+	if v, _ := dcfg.Get_list( "quoting", "list", nil ); strings.Join( v, "|" ) != `plain|q"uote|back\slant` {
+		t.Errorf( "dumped list with quotes did not parse back: %q", v )
+	}
+	if v, _ := dcfg.Get_string( "quoting", "cmd", "" ); v != "echo ${HOME_NOPE} $${x}" {
+		t.Errorf( "dumped value with a literal ${ did not parse back: (%s)", v )
+	}
+	if v, _ := dcfg.Get_list( "quoting", "refs", nil ); strings.Join( v, "|" ) != "${a}|${b} c" {
+		t.Errorf( "dumped list with a literal ${ did not parse back: %q", v )
+	}
+
+	sb.Reset()
+	cfg.Dump( sb, config.DUMP_JSON )
+	if !strings.Contains( sb.String(), `"op": "append"` ) || !strings.Contains( sb.String(), `"value": "host1 host2"` ) {
+		t.Errorf( "json dump not as expected: %s", sb.String() )
+	}
+}
+
+/*
+	Parse the file as strings for comparison.
+*/
+func cfg_map( t *testing.T, fname string ) ( map[string]map[string]interface{} ) {
+	m, err := config.Parse( nil, fname, true )
+	if err != nil {
+		t.Fatalf( "parse of %s failed: %s", fname, err )
+	}
+
+	return m
+}
+
+/*
+	Test the document: untouched files must be written back exactly, and changes must
+	keep comments and parse to the expected values.
+*/
+func TestDocument( t *testing.T ) {
+	dir := t.TempDir()
+	orig := "# tegu agent config\n\nname = tegu   # the name\n\n:agent\n\tport = 4000\t# listen port\n\thosts = h1\n\thosts += h2\n<" + filepath.Join( dir, "other.cfg" ) + "\n\n# trailing comment\n:log\n    level=2"
+	fname := mk_cfg( t, dir, "doc.cfg", orig )
+
+	doc, err := config.Mk_document( fname )
+	if err != nil {
+		t.Fatalf( "unable to read document: %s", err )
+	}
+
+	sb := &strings.Builder{}
+	doc.Write( sb )
+	if sb.String() != orig {
+		t.Fatalf( "untouched document not written back exactly:\n%s", sb.String() )
+	}
+
+	doc.Set( "agent", "port", "4001" )
+	doc.Set( "agent", "hosts", "h3 h4" )
+	doc.Set( "agent", "workers", "8" )
+	doc.Set( "log", "dir", "/var/log" )
+	doc.Set( "default", "owner", "ops" )
+	doc.Add_section( "new" )
+	doc.Set( "new", "foo", "bar" )
+	if ! doc.Delete( "default", "name" ) || doc.Delete( "agent", "nosuch" ) {
+		t.Errorf( "delete did not report found state correctly" )
+	}
+
+	expect := "# tegu agent config\n\nowner = ops\n\n:agent\n\tport = 4001\t# listen port\n\thosts = h1\n\thosts = \"h3 h4\"\n<" + filepath.Join( dir, "other.cfg" ) + "\n\tworkers = 8\n\n# trailing comment\n:log\n    level=2\n    dir = /var/log\n\n:new\n\tfoo = bar\n"
+	sb.Reset()
+	doc.Write( sb )
+	if sb.String() != expect {
+		t.Errorf( "changed document not as expected:\n%s", sb.String() )
+	}
+
+	doc.Set( "new", "a", "[x]" )									// must not be read back as a list or heredoc
+	doc.Set( "new", "b", "<<EOF" )
+	mk_cfg( t, dir, "other.cfg", ":agent\n\tworkers = 2\n" )
+	if err = doc.Save( "" ); err != nil {
+		t.Fatalf( "save failed: %s", err )
+	}
+	cfg, err := config.Mk_config( fname )
+	if err != nil {
+		t.Fatalf( "saved document does not parse: %s", err )
+	}
+	if v, _ := cfg.Get_string( "agent", "hosts", "" ); v != "h3 h4" {
+		t.Errorf( "saved hosts value not as expected: %s", v )
+	}
+	if v, _ := cfg.Get_int( "agent", "workers", 0 ); v != 8 {				// must be placed after the include to win
+		t.Errorf( "saved workers value not as expected: %d", v )
+	}
+	if v, _ := cfg.Get_string( "new", "a", "" ); v != "[x]" {
+		t.Errorf( "saved value starting with [ not read back as is: %s", v )
+	}
+	if v, _ := cfg.Get_string( "new", "b", "" ); v != "<<EOF" {
+		t.Errorf( "saved value starting with << not read back as is: %s", v )
+	}
+}
+
+/*
+	Test the layered loader: defaults, then a file, then environment, then flags.
+*/
+func TestLoader( t *testing.T ) {
+	dir := t.TempDir()
+	fname := mk_cfg( t, dir, "loader.cfg", ":agent\n\tport = 4001\n\turl = http://${host}:${port}\n:log-file\n\tlevel = 1\n" )
+
+	t.Setenv( "TEGU_AGENT_WORKERS", "16" )
+	t.Setenv( "TEGU_LOG_FILE_LEVEL", "3" )
+	t.Setenv( "TEGU_OWNER", "ops" )
+
+	fs := flag.NewFlagSet( "test", flag.ContinueOnError )
+	l := config.Mk_loader(
+		config.Defaults_source( map[string]map[string]string {
+			"default": { "host": "localhost" },
+			"agent": { "port": "4000", "workers": "2", "debug": "false" },
+		} ),
+		config.File_source( fname ),
+		config.Env_source( "tegu" ),
+	)
+	l.Add( config.Flag_source( fs ) )
+
+	if err := fs.Parse( []string{ "-set", "agent.debug=true", "-set", "owner=root" } ); err != nil {
+		t.Fatalf( "flag parse failed: %s", err )
+	}
+
+	cfg, err := l.Load()
+	if err != nil {
+		t.Fatalf( "load failed: %s", err )
+	}
+
+	expect := map[string]string {
+		"agent.port": "4001",
+		"agent.url": "http://localhost:4001",
+		"agent.workers": "16",
+		"agent.debug": "true",
+		"log-file.level": "3",
+		"default.owner": "root",
+	}
+	for k, v := range expect {
+		tokens := strings.SplitN( k, ".", 2 )
+		if sv, _ := cfg.Get_string( tokens[0], tokens[1], "" ); sv != v {
+			t.Errorf( "%s: expected (%s) got (%s)", k, v, sv )
+		}
+	}
+
+	ol := cfg.Origins( "agent", "debug" )
+	if len( ol ) != 2 || ol[0].Fname != "(defaults)" || ol[1].Fname != "(flag) -set agent.debug" || ol[1].Op != config.OP_OVERRIDE {
+		t.Errorf( "agent.debug origins not as expected: %+v %+v", ol[0], ol[1] )
+	}
+	ol = cfg.Origins( "agent", "port" )
+	if len( ol ) != 2 || ol[1].Fname != fname || ol[1].Line != 2 {
+		t.Errorf( "agent.port origins not as expected: %+v", ol )
+	}
+
+	if err := fs.Parse( []string{ "-set", "novalue" } ); err == nil {
+		t.Errorf( "expected flag error for a set without a value" )
+	}
+
+	cfg.Dump( os.Stderr, config.DUMP_NATIVE )
+}
+
+/*
+	Test include handling: relative to the parent, globs, optional includes, cycles
+	and depth.
+*/
+func TestIncludes( t *testing.T ) {
+	dir := t.TempDir()
+	os.MkdirAll( filepath.Join( dir, "etc", "conf.d" ), 0755 )
+
+	mk_cfg( t, dir, "etc/conf.d/20-b.cfg", "order += b\n" )
+	mk_cfg( t, dir, "etc/conf.d/10-a.cfg", "order += a\n" )
+	mk_cfg( t, dir, "etc/conf.d/30-c.cfg", "order += c\n<../sub.cfg\n" )		// relative to conf.d
+	mk_cfg( t, dir, "etc/sub.cfg", "sub = yes\n" )
+	fname := mk_cfg( t, dir, "etc/main.cfg", "order = start\n<conf.d/*.cfg\n<?nosuch.cfg\n< sub.cfg\n" )
+
+	m, err := config.Parse2strs( nil, fname )
+	if err != nil {
+		t.Fatalf( "parse with includes failed: %s", err )
+	}
+	if v := m["default"]["order"]; v == nil || *v != "start a b c" {
+		t.Errorf( "glob includes not processed in order: %v", v )
+	}
+	if v := m["default"]["sub"]; v == nil || *v != "yes" {
+		t.Errorf( "relative include from glob'd file not processed" )
+	}
+
+	fname = mk_cfg( t, dir, "etc/missing.cfg", "foo = bar\n<nosuch.cfg\n" )
+	_, err = config.Parse( nil, fname, true )
+	if err == nil || !strings.Contains( err.Error(), "missing.cfg:2:" ) {
+		t.Errorf( "expected error for missing include at line 2, got: %v", err )
+	}
+
+	mk_cfg( t, dir, "etc/cycle_b.cfg", "b = 1\n<cycle_a.cfg\n" )
+	fname = mk_cfg( t, dir, "etc/cycle_a.cfg", "a = 1\n<./cycle_b.cfg\n" )
+	_, err = config.Parse( nil, fname, true )
+	if err == nil || !strings.Contains( err.Error(), "include cycle" ) {
+		t.Errorf( "expected include cycle error, got: %v", err )
+	} else {
+		fmt.Fprintf( os.Stderr, "include error (expected): %s\n", err )
+	}
+
+	fname = mk_cfg( t, dir, "etc/self.cfg", "a = 1\n<self.cfg\n" )
+	_, err = config.Parse( nil, fname, true )
+	if err == nil || !strings.Contains( err.Error(), "include cycle" ) {
+		t.Errorf( "expected include cycle error for self include, got: %v", err )
+	}
+
+	for i := 0; i < config.MAX_INCLUDE_DEPTH + 1; i++ {
+		mk_cfg( t, dir, fmt.Sprintf( "etc/deep%d.cfg", i ), fmt.Sprintf( "<deep%d.cfg\n", i+1 ) )
+	}
+	_, err = config.Parse( nil, filepath.Join( dir, "etc", "deep0.cfg" ), true )
+	if err == nil || !strings.Contains( err.Error(), "nested more than" ) {
+		t.Errorf( "expected include depth error, got: %v", err )
+	}
+}
+
+/*
+	Test values spread over several lines: continuations, heredocs and lists, and that
+	they survive the document and dump round trips.
+*/
+func TestMultiline( t *testing.T ) {
+	dir := t.TempDir()
+	contents := "cmd = run --fast \\\n\t--verbose\n" +
+		":tls\n" +
+		"\tcert = <<EOF\n-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\nEOF\n" +
+		"\thosts = [alpha, \"beta gamma\", delta]\n" +
+		"\tports = [\n\t\t80,\n\t\t443\n\t]\n" +
+		"\thosts += [epsilon]\n" +
+		"\tempty = []\n" +
+		"\tnoted = [\t\t# web ports [not 8080]\n\t\t80, \"#1\"\t# plain\n\t\t443 # tls\n\t] # done\n"
+	fname := mk_cfg( t, dir, "multi.cfg", contents )
+
+	c, err := config.Mk_config( fname )
+	if err != nil {
+		t.Fatalf( "unable to parse multi-line config: %s", err )
+	}
+
+	if v, _ := c.Get_string( "default", "cmd", "" ); v != "run --fast --verbose" {
+		t.Errorf( "continuation not joined: (%s)", v )
+	}
+	if v, _ := c.Get_string( "tls", "cert", "" ); v != "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----" {
+		t.Errorf( "heredoc value wrong: (%s)", v )
+	}
+	if v, _ := c.Get_list( "tls", "hosts", nil ); strings.Join( v, "|" ) != "alpha|beta gamma|delta|epsilon" {
+		t.Errorf( "list value wrong: %q", v )
+	}
+	if v, _ := c.Get_list( "tls", "ports", nil ); strings.Join( v, "|" ) != "80|443" {
+		t.Errorf( "multi-line list value wrong: %q", v )
+	}
+	if v, _ := c.Get_list( "tls", "empty", []string{ "x" } ); v == nil || len( v ) != 0 {
+		t.Errorf( "empty list value wrong: %q", v )
+	}
+	if v, _ := c.Get_list( "tls", "noted", nil ); strings.Join( v, "|" ) != "80|#1|443" {
+		t.Errorf( "comments in a multi-line list not dropped: %q", v )
+	}
+	if o := c.Origins( "tls", "ports" ); len( o ) != 1 || o[0].Line != 10 {
+		t.Errorf( "multi-line list origin should be line 10: %v", o )
+	}
+
+	m, err := config.Parse2strs( nil, fname )
+	if err != nil {
+		t.Fatalf( "parse2strs failed: %s", err )
+	}
+	if v := m["tls"]["hosts"]; v == nil || *v != "alpha beta gamma delta epsilon" {
+		t.Errorf( "parse2strs did not flatten list: %v", v )
+	}
+
+	d, err := config.Mk_document( fname )
+	if err != nil {
+		t.Fatalf( "unable to make document: %s", err )
+	}
+	buf := &strings.Builder{}
+	d.Write( buf )
+	if buf.String() != contents {
+		t.Errorf( "document did not round trip:\n%s", buf.String() )
+	}
+	d.Set( "tls", "cert", "new\nEOF\nbody" )
+	d.Set( "tls", "ports", "8080" )
+	if err = d.Save( "" ); err != nil {
+		t.Fatalf( "save failed: %s", err )
+	}
+	c, err = config.Mk_config( fname )
+	if err != nil {
+		t.Fatalf( "unable to parse saved document: %s", err )
+	}
+	if v, _ := c.Get_string( "tls", "cert", "" ); v != "new\nEOF\nbody" {
+		t.Errorf( "heredoc value set through document wrong: (%s)", v )
+	}
+	if v, _ := c.Get_string( "tls", "ports", "" ); v != "8080" {
+		t.Errorf( "multi-line list not replaced by document set: (%s)", v )
+	}
+
+	buf.Reset()
+	c.Dump( buf, config.DUMP_NATIVE )
+	fname = mk_cfg( t, dir, "dumped.cfg", buf.String() )
+	dc, err := config.Mk_config( fname )
+	if err != nil {
+		t.Fatalf( "unable to parse dumped config: %s\n%s", err, buf.String() )
+	}
+	if v, _ := dc.Get_list( "tls", "hosts", nil ); strings.Join( v, "|" ) != "alpha|beta gamma|delta|epsilon" {
+		t.Errorf( "dumped list did not read back: %q", v )
+	}
+
+	script := "echo ${HOME}\necho ${undefined}"
+	fname = mk_cfg( t, dir, "script.cfg", "home = /home/me\nscript = <<END\n" + script + "\nEND\ndir = ${home}/bin\n" )
+	m, err = config.Parse2strs( nil, fname )
+	if err != nil {
+		t.Fatalf( "heredoc with references failed to parse: %s", err )
+	}
+	if v := m["default"]["script"]; v == nil || *v != script {
+		t.Errorf( "heredoc value was not taken verbatim: %v", v )
+	}
+	if v := m["default"]["dir"]; v == nil || *v != "/home/me/bin" {
+		t.Errorf( "reference outside of the heredoc not expanded: %v", v )
+	}
+
+	fname = mk_cfg( t, dir, "bad.cfg", "a = 1\nb = <<END\nnever ends\n" )
+	if _, err = config.Parse( nil, fname, true ); err == nil || !strings.Contains( err.Error(), "bad.cfg:2:" ) {
+		t.Errorf( "expected unterminated heredoc error at line 2, got: %v", err )
+	}
+	fname = mk_cfg( t, dir, "bad2.cfg", "a = [1, 2\n" )
+	if _, err = config.Parse( nil, fname, true ); err == nil || !strings.Contains( err.Error(), "not closed" ) {
+		t.Errorf( "expected unclosed list error, got: %v", err )
+	}
+}
+
+/*
+	Test strict parsing: the diagnostics returned, with their position and severity, for
+	a file with lone keys, bad quotes, bad numbers and duplicate keys.
+*/
+func TestStrict( t *testing.T ) {
+	dir := t.TempDir()
+	fname := mk_cfg( t, dir, "strict.cfg",
+		"port = 4000\n" +
+		"lonely\n" +
+		"name = \"unterminated\n" +
+		"version = 1.2.3\n" +
+		"timeout = 5 seconds\n" +
+		"size = 10GiB\n" +
+		"port = 4001\n" +
+		"port += 4002\n" +
+		"flag = -v\n" +
+		"hosts = [a, b] c\n" )
+
+	m, err := config.Parse( nil, fname, false )
+	if err != nil {
+		t.Fatalf( "non-strict parse failed: %s", err )
+	}
+	if m["default"]["lonely"] != nil {
+		t.Errorf( "lone key was not ignored" )
+	}
+
+	m, err = config.Parse_strict( nil, fname, false )
+	if m == nil {
+		t.Fatalf( "strict parse did not return the map" )
+	}
+	var ds config.Diagnostics
+	if ! errors.As( err, &ds ) {
+		t.Fatalf( "strict parse did not return diagnostics: %v", err )
+	}
+	fmt.Fprintf( os.Stderr, "strict diagnostics (expected):\n%s\n", err )
+
+	expect := []struct {
+		line	int
+		col		int
+		sev		string
+		what	string
+	} {
+		{ 2, 1, config.SEV_ERROR, "no value" },
+		{ 3, 8, config.SEV_ERROR, "quote is not closed" },
+		{ 4, 11, config.SEV_ERROR, "not a number" },
+		{ 5, 13, config.SEV_ERROR, "text after the number" },
+		{ 7, 1, config.SEV_WARNING, "duplicate key port" },
+		{ 9, 8, config.SEV_ERROR, "not a number" },
+		{ 10, 16, config.SEV_ERROR, "text after the list" },
+	}
+	if len( ds ) != len( expect ) {
+		t.Fatalf( "expected %d diagnostics, got %d", len( expect ), len( ds ) )
+	}
+	for i, e := range expect {
+		d := ds[i]
+		if d.Fname != fname || d.Line != e.line || d.Col != e.col || d.Severity != e.sev || !strings.Contains( d.Msg, e.what ) {
+			t.Errorf( "diagnostic %d: expected line %d col %d %s (%s), got: %s", i, e.line, e.col, e.sev, e.what, d )
+		}
+	}
+	if ! ds.Has_errors() {
+		t.Errorf( "has errors should be true" )
+	}
+
+	_, err = config.Parse_strict( nil, fname, true )		// all strings: no numeric checks
+	if err == nil || len( err.(config.Diagnostics) ) != 4 {
+		t.Errorf( "expected 4 diagnostics when parsing all strings, got: %v", err )
+	}
+
+	fname = mk_cfg( t, dir, "clean.cfg", "port = 4000\n:agent\n\tport = 4001\n" )
+	c, err := config.Mk_config_strict( fname )
+	if err != nil || c == nil {
+		t.Errorf( "strict parse of clean file failed: %v", err )
+	}
+
+	fname = mk_cfg( t, dir, "badnum.cfg", "port = 40x0\nflag = -v\n" )		// strings until fetched; bad number found by Get_int()
+	c, err = config.Mk_config_strict( fname )
+	if err != nil || c == nil {
+		t.Fatalf( "strict config should not have numeric diagnostics: %v", err )
+	}
+	if v, _ := c.Get_string( "default", "flag", "" ); v != "-v" {
+		t.Errorf( "strict config did not keep the value as a string: (%s)", v )
+	}
+	_, err = c.Get_int( "default", "port", 0 )
+	var ve *config.Value_error
+	if ! errors.As( err, &ve ) || ve.Fname != fname || ve.Line != 1 {
+		t.Errorf( "expected value error at line 1 for bad number, got: %v", err )
+	} else {
+		fmt.Fprintf( os.Stderr, "strict config value error (expected): %s\n", err )
+	}
+}
@@ -0,0 +1,264 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	decode
+	Abstract:	Populate a tagged struct from a configuration file.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/att/gopkgs/transform"
+)
+
+/*
+	Decode_error is returned by Decode() when the file contains keys which do not map
+	to a field in the struct, or when required keys are missing from the file. All
+	problems are reported at once rather than just the first one encountered.
+*/
+type Decode_error struct {
+	Unknown	[]string		// keys in the file with no field: file:line: section.key
+	Missing	[]string		// required keys not in the file: section.key
+}
+
+func (e *Decode_error) Error( ) ( string ) {
+	msgs := make( []string, 0, len( e.Unknown ) + len( e.Missing ) )
+	for _, u := range e.Unknown {
+		msgs = append( msgs, "unknown key: " + u )
+	}
+	for _, r := range e.Missing {
+		msgs = append( msgs, "missing required key: " + r )
+	}
+
+	return fmt.Sprintf( "config: decode: %d error(s): %s", len( msgs ), strings.Join( msgs, "; " ) )
+}
+
+/*
+	Information collected while walking the user struct.
+*/
+type decoder struct {
+	sects	map[string]map[string]interface{}
+	used	map[string]bool			// section.key entries which mapped to a field
+	tm		map[string]string		// map that is given to transform
+	missing	[]string
+}
+
+/*
+	Decode parses the named file and fills in the struct (ustructp must be a pointer to
+	a struct) from the values. Fields are mapped to the config using tags of the form:
+		cfg:"section.key"
+
+	where section.key names the key in the file. If the section is omitted (no dot) the
+	key is taken from the default section.  A field which is itself a struct (or a pointer
+	to a struct) and tagged with cfg:"section" maps an entire section; fields in the
+	nested struct are tagged with just the key name.  Anonymous (embedded) structs share
+	the namespace of the struct that contains them.
+
+	Options may follow the name, separated by commas:
+		cfg:"log.level,required"
+		cfg:"log.level,default=2"
+
+	A required key which is not in the file is an error.  The default option gives the
+	value used when the key isn't in the file and must be the last option as everything
+	after the equal sign is taken as the value. Fields which are not in the file and
+	have no default are set to their zero value.
+
+	Slice fields are filled from list values ([a, "b c"]), or from space separated
+	values (as built with +=).
+
+	Keys in the file which do not map to any field are errors. When there are unknown
+	or missing keys, the struct is still populated with what was found and a
+	Decode_error which lists every problem is returned.
+*/
+func Decode( fname string, ustructp interface{} ) ( err error ) {
+	v := reflect.ValueOf( ustructp )
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf( "config: decode: target must be a pointer to a struct, got %s", v.Type() )
+	}
+
+	sects, p, err := parse_all( nil, fname, true )
+	if err != nil {
+		return err
+	}
+
+	d := &decoder {
+		sects: sects,
+		used: make( map[string]bool ),
+		tm: make( map[string]string ),
+	}
+
+	err = d.walk( v.Elem().Type(), "", "" )
+	if err != nil {
+		return err
+	}
+
+	transform.Map_to_struct( d.tm, ustructp, "cfg" )
+
+	derr := &Decode_error{ Missing: d.missing }
+	for _, sname := range p.prov.sect_names() {
+		for _, key := range p.prov.key_names( sname ) {
+			if ! d.used[sname + "." + key] {
+				loc := p.prov.last( sname, key )
+				derr.Unknown = append( derr.Unknown, fmt.Sprintf( "%s:%d: %s.%s", loc.Fname, loc.Line, sname, key ) )
+			}
+		}
+	}
+
+	if len( derr.Unknown ) > 0 || len( derr.Missing ) > 0 {
+		return derr
+	}
+
+	return nil
+}
+
+/*
+	Split a cfg tag into the name, required flag and default value.
+*/
+func split_tag( tag string ) ( name string, required bool, def *string ) {
+	tokens := strings.SplitN( tag, ",", 2 )
+	name = tokens[0]
+	if len( tokens ) < 2 {
+		return
+	}
+
+	opts := tokens[1]
+	for opts != "" {
+		if strings.HasPrefix( opts, "default=" ) {
+			dv := opts[8:]
+			def = &dv
+			return
+		}
+
+		tokens = strings.SplitN( opts, ",", 2 )
+		if tokens[0] == "required" {
+			required = true
+		}
+
+		opts = ""
+		if len( tokens ) > 1 {
+			opts = tokens[1]
+		}
+	}
+
+	return
+}
+
+/*
+	Walk the fields in the struct type. Sect is the section that the struct maps to
+	("" at the top level where tags are section.key), and pfx is the prefix that
+	transform will add to the names when it looks them up in the map.
+*/
+func (d *decoder) walk( st reflect.Type, sect string, pfx string ) ( err error ) {
+	for i := 0; i < st.NumField(); i++ {
+		fmeta := st.Field( i )
+		tag := fmeta.Tag.Get( "cfg" )
+
+		ft := fmeta.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if tag == "" {
+			if fmeta.Anonymous && ft.Kind() == reflect.Struct {
+				if err = d.walk( ft, sect, pfx ); err != nil {			// anon structs share our namespace
+					return err
+				}
+			}
+			continue
+		}
+
+		name, required, def := split_tag( tag )
+
+		if ft.Kind() == reflect.Struct {
+			if sect != "" {
+				return fmt.Errorf( "config: decode: field %s: structs may only be nested one level (section)", fmeta.Name )
+			}
+
+			if err = d.walk( ft, name, pfx + fmeta.Name + "/" ); err != nil {
+				return err
+			}
+			continue
+		}
+
+		sname := sect
+		key := name
+		if sname == "" {
+			sname = "default"
+			if j := strings.Index( name, "." ); j > 0 {
+				sname = name[:j]
+				key = name[j+1:]
+			}
+		}
+
+		var value *string
+		var list []string
+		switch v := d.sects[sname][key].( type ) {
+			case *string:
+				value = v
+
+			case []string:
+				list = v
+				s := strings.Join( v, " " )
+				value = &s
+		}
+
+		if value != nil {
+			d.used[sname + "." + key] = true
+		} else {
+			if def != nil {
+				value = def
+			} else {
+				if required {
+					d.missing = append( d.missing, sname + "." + key )
+				}
+			}
+		}
+
+		if value == nil {
+			continue
+		}
+
+		tkey := pfx + tag							// transform looks up the whole tag, options and all
+		if ft.Kind() == reflect.Slice {
+			tokens := list
+			if tokens == nil {
+				tokens = strings.Fields( *value )
+			}
+			ntokens := len( tokens )
+			d.tm[tkey + ".len"] = fmt.Sprintf( "%d", ntokens )
+			d.tm[tkey + ".cap"] = fmt.Sprintf( "%d", ntokens )
+			for j := 0; j < ntokens; j++ {
+				d.tm[fmt.Sprintf( "%s/%d", tkey, j )] = tokens[j]
+			}
+		} else {
+			d.tm[tkey] = *value
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	diag
+	Abstract:	Diagnostics collected while parsing in strict mode. Problems that the
+				parser normally ignores (a lone key, a bad number which becomes 0,
+				a duplicate key) are recorded with their location and returned
+				together as a single error.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	SEV_ERROR	string = "error"		// the value in the map is not what the file intended
+	SEV_WARNING	string = "warning"		// legal, but likely a mistake
+)
+
+/*
+	One problem found in a config file.  Line and column are 1 based; a column of 0
+	means the column is not known.
+*/
+type Diagnostic struct {
+	Fname		string
+	Line		int
+	Col			int
+	Severity	string			// one of the SEV_ constants
+	Msg			string
+}
+
+func (d *Diagnostic) Error( ) ( string ) {
+	if d.Col > 0 {
+		return fmt.Sprintf( "%s:%d:%d: %s: %s", d.Fname, d.Line, d.Col, d.Severity, d.Msg )
+	}
+
+	return fmt.Sprintf( "%s:%d: %s: %s", d.Fname, d.Line, d.Severity, d.Msg )
+}
+
+/*
+	Diagnostics is the list of problems found in strict mode, in the order found. It is
+	returned as the error from a strict parse and can be recovered with errors.As().
+*/
+type Diagnostics []*Diagnostic
+
+/*
+	Error returns all of the diagnostics, one per line.
+*/
+func (ds Diagnostics) Error( ) ( string ) {
+	msgs := make( []string, len( ds ) )
+	for i, d := range ds {
+		msgs[i] = d.Error()
+	}
+
+	return strings.Join( msgs, "\n" )
+}
+
+/*
+	Unwrap allows errors.Is() and errors.As() to examine each diagnostic.
+*/
+func (ds Diagnostics) Unwrap( ) ( []error ) {
+	errs := make( []error, len( ds ) )
+	for i, d := range ds {
+		errs[i] = d
+	}
+
+	return errs
+}
+
+/*
+	Has_errors returns true if any diagnostic has error severity (as opposed to
+	only warnings).
+*/
+func (ds Diagnostics) Has_errors( ) ( bool ) {
+	for _, d := range ds {
+		if d.Severity == SEV_ERROR {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	Record a diagnostic if the parser is in strict mode.
+*/
+func (p *cfg_parser) diag( fname string, line int, col int, sev string, format string, args ...interface{} ) {
+	if ! p.strict {
+		return
+	}
+
+	p.diags = append( p.diags, &Diagnostic{ Fname: fname, Line: line, Col: col, Severity: sev, Msg: fmt.Sprintf( format, args... ) } )
+}
+
+/*
+	Return the 1 based column of what on the first line of the raw record text, or the
+	column of the first non-blank character if what isn't found.
+*/
+func col_of( raw string, what string ) ( int ) {
+	if i := strings.Index( raw, "\n" ); i >= 0 {
+		raw = raw[:i]
+	}
+
+	if what != "" {
+		if i := strings.Index( raw, what ); i >= 0 {
+			return i + 1
+		}
+	}
+
+	return len( indent_of( raw ) ) + 1
+}
+
+/*
+	Return the index of a quote which is not closed, or -1 if all quotes are closed.
+	This follows the tokeniser: a backslash escapes the next character only inside
+	of quotes.
+*/
+func unclosed_quote( rec string ) ( int ) {
+	open := -1
+	for i := 0; i < len( rec ); i++ {
+		switch {
+			case open < 0 && rec[i] == '"':
+				open = i
+
+			case open >= 0 && rec[i] == '\\':
+				i++
+
+			case open >= 0 && rec[i] == '"':
+				open = -1
+		}
+	}
+
+	return open
+}
+
+/*
+	Check that the string is a number, optionally followed by one of the unit suffixes
+	supported by clike.Atof() (K, KB, M, MB, G, GB, k, KiB, m, MiB, g, GiB). Returns nil
+	if it is, and an error describing the problem if it is not.
+*/
+func num_check( s string ) ( error ) {
+	i := 0
+	if len( s ) > 0 && (s[0] == '+' || s[0] == '-') {
+		i++
+	}
+	ndigits := 0
+	for ; i < len( s ) && ((s[i] >= '0' && s[i] <= '9') || s[i] == '.'); i++ {
+		ndigits++
+	}
+
+	if ndigits == 0 {
+		return fmt.Errorf( "no leading number" )
+	}
+
+	switch s[i:] {
+		case "", "K", "KB", "M", "MB", "G", "GB", "k", "KiB", "m", "MiB", "g", "GiB":
+			if _, err := strconv.ParseFloat( s[:i], 64 ); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf( "unknown unit: %s", s[i:] )
+	}
+
+	return nil
+}
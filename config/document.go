@@ -0,0 +1,407 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	document
+	Abstract:	A line based model of a config file which allows keys to be changed
+				programmatically while keeping comments, blank lines, ordering and
+				include directives. Lines which are not changed are written back
+				exactly as they were read.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/att/gopkgs/token"
+)
+
+const (
+	dl_blank	int = iota
+	dl_comment
+	dl_section
+	dl_include
+	dl_kv
+	dl_other					// something the parser ignores (e.g. a lone key)
+)
+
+/*
+	One line in the document. Text is exactly what is written, including the newline
+	(the last line in the file might not have one).  A record which spans several
+	lines (continuation, heredoc or multi-line list) is held as one line.
+*/
+type doc_line struct {
+	text	string
+	kind	int				// dl_* constant
+	sect	string			// section the line is in (the section itself for dl_section)
+	key		string			// key name for dl_kv
+	append	bool			// dl_kv line is a += line
+}
+
+/*
+	A config file held as a list of lines.
+*/
+type Document struct {
+	fname	string
+	lines	[]*doc_line
+}
+
+/*
+	Classify a record in the same manner that the parser does. Text is the raw text of
+	the record and rec is the record as read by the record reader.  Sname is the current
+	section and is returned updated if the line is a section header.
+*/
+func classify( text string, rec string, sname string ) ( dl *doc_line, new_sname string ) {
+	dl = &doc_line{ text: text, sect: sname }
+	if len( rec ) > 0 && rec[0] != '#' && unclosed_quote( rec ) >= 0 {		// as the parser does
+		rec += "\""
+	}
+
+	if len( rec ) == 0 {
+		dl.kind = dl_blank
+		return dl, sname
+	}
+
+	switch rec[0] {
+		case ':':
+			_, tokens := token.Tokenise_qpopulated( rec, " \t" )
+			dl.kind = dl_section
+			dl.sect = tokens[0][1:]
+			return dl, dl.sect
+
+		case '#':
+			dl.kind = dl_comment
+
+		case '<':
+			dl.kind = dl_include
+
+		default:
+			ntokens, tokens := token.Tokenise_qpopulated( rec, " \t=" )
+			dl.kind = dl_other
+			if ntokens >= 2 {
+				dl.kind = dl_kv
+				tl := len( tokens[0] )
+				if tokens[0][tl-1:] == "+" {
+					dl.key = tokens[0][:tl-1]
+					dl.append = true
+				} else {
+					dl.key = tokens[0]
+					dl.append = tokens[1] == "+"
+				}
+			}
+	}
+
+	return dl, sname
+}
+
+/*
+	Mk_document reads the named file and returns a document. Only the named file is
+	read; include directives are kept as lines in the document but the included files
+	are not read or changed.
+*/
+func Mk_document( fname string ) ( d *Document, err error ) {
+	f, err := os.Open( fname )
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d = &Document{ fname: fname }
+
+	sname := "default"
+	rr := mk_rec_reader( f, fname )
+	for {
+		cr, eof, err := rr.next()
+		if err != nil {
+			return nil, err
+		}
+		if eof {
+			break
+		}
+
+		var dl *doc_line
+		dl, sname = classify( cr.raw, cr.rec, sname )
+		d.lines = append( d.lines, dl )
+	}
+
+	return d, nil
+}
+
+/*
+	Write writes the document to the writer.
+*/
+func (d *Document) Write( w io.Writer ) ( err error ) {
+	for _, dl := range d.lines {
+		if _, err = io.WriteString( w, dl.text ); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+	Save writes the document to the named file, or to the file it was read from if
+	fname is empty.  The document is written to a temporary file which is then renamed
+	so that a reader never sees a partial file.
+*/
+func (d *Document) Save( fname string ) ( err error ) {
+	if fname == "" {
+		fname = d.fname
+	}
+
+	f, err := os.CreateTemp( filepath.Dir( fname ), filepath.Base( fname ) + ".*" )
+	if err != nil {
+		return err
+	}
+	tname := f.Name()
+
+	err = d.Write( f )
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		if fi, serr := os.Stat( fname ); serr == nil {
+			os.Chmod( tname, fi.Mode() )					// keep the permissions the operator gave the file
+		}
+		err = os.Rename( tname, fname )
+	}
+
+	if err != nil {
+		os.Remove( tname )
+	}
+	return err
+}
+
+/*
+	Return the comment (with the whitespace before it) that trails the value on the
+	first line of a key/value record, or "" if there isn't one. As with the parser, a
+	comment starts with a # which is outside of quotes and at the start of a token.
+*/
+func trailing_comment( text string ) ( string ) {
+	if i := strings.Index( text, "\n" ); i >= 0 {
+		text = text[:i]
+	}
+	text = strings.TrimRight( text, "\r" )
+
+	inq := false
+	for i := 0; i < len( text ); i++ {
+		switch text[i] {
+			case '\\':
+				i++
+
+			case '"':
+				inq = !inq
+
+			case '#':
+				if ! inq && i > 0 && strings.ContainsRune( " \t=", rune( text[i-1] ) ) {
+					j := i
+					for j > 0 && (text[j-1] == ' ' || text[j-1] == '\t') {
+						j--
+					}
+					return text[j:]
+				}
+		}
+	}
+
+	return ""
+}
+
+/*
+	Return the leading whitespace of the text.
+*/
+func indent_of( text string ) ( string ) {
+	return text[:len( text ) - len( strings.TrimLeft( text, " \t" ) )]
+}
+
+/*
+	Quote a list element if it contains a separator, or anything else which would
+	prevent it from being read back as is.
+*/
+func quote_element( e string ) ( string ) {
+	if e == "" || strings.ContainsAny( e, " \t,#\"[]\\" ) {
+		return quote_str( e )
+	}
+
+	return e
+}
+
+/*
+	Build the text for a key = value line. Value is either a string or a list ([]string).
+	A string containing newlines is written as a heredoc using a tag which does not
+	appear as a line in the value.
+*/
+func mk_kv_text( indent string, key string, value interface{}, comment string ) ( string ) {
+	switch v := value.( type ) {
+		case []string:
+			elements := make( []string, len( v ) )
+			for i, e := range v {
+				elements[i] = quote_element( e )
+			}
+			return fmt.Sprintf( "%s%s = [%s]%s\n", indent, key, strings.Join( elements, ", " ), comment )
+
+		case string:
+			if strings.Contains( v, "\n" ) {
+				tag := "EOF"
+				for i := 1; strings.Contains( "\n" + v + "\n", "\n" + tag + "\n" ); i++ {
+					tag = fmt.Sprintf( "EOF_%d", i )
+				}
+				return fmt.Sprintf( "%s%s = <<%s%s\n%s\n%s\n", indent, key, tag, comment, v, tag )
+			}
+			return fmt.Sprintf( "%s%s = %s%s\n", indent, key, dump_quote( v ), comment )
+	}
+
+	return fmt.Sprintf( "%s%s = %s%s\n", indent, key, dump_quote( fmt.Sprintf( "%v", value ) ), comment )
+}
+
+/*
+	Insert a line at index i.
+*/
+func (d *Document) insert( i int, dl *doc_line ) {
+	if i > 0 && ! strings.HasSuffix( d.lines[i-1].text, "\n" ) {		// previous was the last line without a newline
+		d.lines[i-1].text += "\n"
+	}
+
+	d.lines = append( d.lines, nil )
+	copy( d.lines[i+1:], d.lines[i:] )
+	d.lines[i] = dl
+}
+
+/*
+	Return the index where a new line for the section should be inserted: after the last
+	line of the last block of the section which isn't blank or a comment (comments just
+	before the next section are assumed to belong to it), or -1 if the section isn't in
+	the document.  The default section always exists and starts at the top of the file.
+*/
+func (d *Document) sect_end( sect string ) ( idx int ) {
+	idx = -1
+	if sect == "default" {
+		idx = 0
+	}
+
+	for i, dl := range d.lines {
+		if dl.sect == sect && dl.kind != dl_blank && dl.kind != dl_comment {
+			idx = i + 1
+		}
+	}
+
+	return idx
+}
+
+/*
+	Has_section returns true if the section is in the document.
+*/
+func (d *Document) Has_section( sect string ) ( bool ) {
+	if sect == "default" {
+		return true
+	}
+
+	for _, dl := range d.lines {
+		if dl.kind == dl_section && dl.sect == sect {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	Add_section adds the section header to the end of the document if the section is
+	not already in the document.
+*/
+func (d *Document) Add_section( sect string ) {
+	if d.Has_section( sect ) {
+		return
+	}
+
+	if n := len( d.lines ); n > 0 && d.lines[n-1].kind != dl_blank {
+		d.insert( n, &doc_line{ text: "\n", kind: dl_blank, sect: d.lines[n-1].sect } )
+	}
+	d.insert( len( d.lines ), &doc_line{ text: ":" + sect + "\n", kind: dl_section, sect: sect } )
+}
+
+/*
+	Set causes the key in the section to have the value. If the key exists, the last line
+	which sets or appends to it is replaced with a key = value line keeping its indention
+	and trailing comment; earlier lines are left alone as the new line overrides them.
+	If the key does not exist, a line is added to the end of the section, and the section
+	is added if needed.  A value containing newlines is written as a heredoc.
+*/
+func (d *Document) Set( sect string, key string, value string ) {
+	last := -1
+	for i, dl := range d.lines {
+		if dl.kind == dl_kv && dl.sect == sect && dl.key == key {
+			last = i
+		}
+	}
+
+	if last >= 0 {
+		old := d.lines[last]
+		text := mk_kv_text( indent_of( old.text ), key, value, trailing_comment( old.text ) )
+		if ! strings.HasSuffix( old.text, "\n" ) {
+			text = strings.TrimSuffix( text, "\n" )
+		}
+		d.lines[last] = &doc_line{ text: text, kind: dl_kv, sect: sect, key: key }
+		return
+	}
+
+	d.Add_section( sect )
+
+	indent := ""							// indent like the other keys in the section, or tab if none
+	if sect != "default" {
+		indent = "\t"
+	}
+	for _, dl := range d.lines {
+		if dl.kind == dl_kv && dl.sect == sect {
+			indent = indent_of( dl.text )
+		}
+	}
+
+	d.insert( d.sect_end( sect ), &doc_line{ text: mk_kv_text( indent, key, value, "" ), kind: dl_kv, sect: sect, key: key } )
+}
+
+/*
+	Delete removes every line which sets or appends to the key in the section. The
+	return value is false if the key was not found.
+*/
+func (d *Document) Delete( sect string, key string ) ( found bool ) {
+	nl := d.lines[:0]
+	for _, dl := range d.lines {
+		if dl.kind == dl_kv && dl.sect == sect && dl.key == key {
+			found = true
+			continue
+		}
+		nl = append( nl, dl )
+	}
+	d.lines = nl
+
+	return found
+}
@@ -0,0 +1,217 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	dump
+	Abstract:	Write the effective (merged) config annotated with where each
+				value came from.
+	Date:		18 October 2026
+	Author:		agent
+
+	Mods:		18 Oct 2026 - The native dump escapes ${ so values parse back the same.
+					Values starting with [ or << are quoted.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	DUMP_NATIVE	int = iota		// :section and key = value format which can be parsed
+	DUMP_JSON					// one JSON object
+)
+
+/*
+	What is written for each key in a JSON dump.
+*/
+type dump_value struct {
+	Value	interface{}	`json:"value"`		// string, or []string for a list
+	Origin	[]*Origin	`json:"origin"`
+}
+
+/*
+	Origins returns the history of the key in the section: each place in the file, or
+	included files, where the key was set or appended to, oldest first.  The last
+	element is the origin of the current value.  Nil is returned if the origin of the
+	key is not known (e.g. the value was supplied by the caller in a section map).
+*/
+func (c *Config) Origins( sect string, key string ) ( []*Origin ) {
+	if c == nil {
+		return nil
+	}
+
+	return c.prov[sect][key]
+}
+
+/*
+	Return the section names, default first and the rest sorted.
+*/
+func (c *Config) dump_sects( ) ( names []string ) {
+	names = make( []string, 0, len( c.sects ) )
+	for sname := range c.sects {
+		if sname != "default" {
+			names = append( names, sname )
+		}
+	}
+	sort.Strings( names )
+
+	if c.sects["default"] != nil {
+		names = append( []string{ "default" }, names... )
+	}
+
+	return
+}
+
+/*
+	Return the value of the key for dumping: a list is returned as []string and
+	everything else as a string.
+*/
+func (c *Config) dump_value( sect string, key string ) ( interface{} ) {
+	if list, ok := c.sects[sect][key].( []string ); ok {
+		return list
+	}
+
+	v, _ := c.lookup( sect, key )
+	return v
+}
+
+/*
+	Return the string in quotes with embedded quotes and backslants escaped as the
+	tokeniser expects.
+*/
+func quote_str( s string ) ( string ) {
+	return "\"" + strings.NewReplacer( `\`, `\\`, `"`, `\"` ).Replace( s ) + "\""
+}
+
+/*
+	Quote the value if the parser would not otherwise read it back as is. A trailing
+	backslant would be taken as a continuation, so any value with one is quoted, as is
+	a value starting with [ or << which would be read as a list or heredoc.
+*/
+func dump_quote( s string ) ( string ) {
+	if s == "" || strings.ContainsAny( s, " \t=#\"\\" ) || strings.HasPrefix( s, "[" ) || strings.HasPrefix( s, "<<" ) {
+		return quote_str( s )
+	}
+
+	return s
+}
+
+/*
+	Return the value with each ${ escaped as $${ so that it is not taken as a reference
+	when the dump is parsed. A string with newlines is written as a heredoc, which is
+	not expanded, and so is left alone.
+*/
+func escape_refs( value interface{} ) ( interface{} ) {
+	switch v := value.( type ) {
+		case []string:
+			elements := make( []string, len( v ) )
+			for i, e := range v {
+				elements[i] = strings.Replace( e, "${", "$${", -1 )
+			}
+			return elements
+
+		case string:
+			if ! strings.Contains( v, "\n" ) {
+				return strings.Replace( v, "${", "$${", -1 )
+			}
+	}
+
+	return value
+}
+
+/*
+	Format a key's history as a comment: file:line op, ...
+*/
+func fmt_origins( olist []*Origin ) ( string ) {
+	if len( olist ) == 0 {
+		return "(not from a file)"
+	}
+
+	parts := make( []string, len( olist ) )
+	for i, o := range olist {
+		if o.Line > 0 {
+			parts[i] = fmt.Sprintf( "%s:%d %s", o.Fname, o.Line, o.Op )
+		} else {
+			parts[i] = fmt.Sprintf( "%s %s", o.Fname, o.Op )			// not from a file; name describes the source
+		}
+	}
+
+	return strings.Join( parts, ", " )
+}
+
+/*
+	Dump writes the effective config, after all includes, duplicate sections, +=
+	appends and reference expansion, to the writer.  Format is either DUMP_NATIVE,
+	which writes the config in the :section format with a trailing comment on each
+	key giving its origins, or DUMP_JSON which writes an object of sections, each an
+	object of keys, each with the value and list of origins.
+*/
+func (c *Config) Dump( w io.Writer, format int ) ( err error ) {
+	if format == DUMP_JSON {
+		jm := make( map[string]map[string]*dump_value, len( c.sects ) )
+		for sname, sect := range c.sects {
+			jm[sname] = make( map[string]*dump_value, len( sect ) )
+			for key := range sect {
+				jm[sname][key] = &dump_value{ Value: c.dump_value( sname, key ), Origin: c.Origins( sname, key ) }
+			}
+		}
+
+		b, err := json.MarshalIndent( jm, "", "  " )
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf( w, "%s\n", b )
+		return err
+	}
+
+	for i, sname := range c.dump_sects() {
+		if i > 0 {
+			if _, err = fmt.Fprintf( w, "\n" ); err != nil {
+				return
+			}
+		}
+
+		if _, err = fmt.Fprintf( w, ":%s\n", sname ); err != nil {
+			return
+		}
+
+		keys := make( []string, 0, len( c.sects[sname] ) )
+		for key := range c.sects[sname] {
+			keys = append( keys, key )
+		}
+		sort.Strings( keys )
+
+		for _, key := range keys {
+			_, err = io.WriteString( w, mk_kv_text( "\t", key, escape_refs( c.dump_value( sname, key ) ), "\t\t# " + fmt_origins( c.Origins( sname, key ) ) ) )
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
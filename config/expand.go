@@ -0,0 +1,233 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	expand
+	Abstract:	Expansion of environment and cross-key references in config values.
+	Date:		18 October 2026
+	Author:		agent
+
+	Mods:		18 Oct 2026 - Added the $${ escape for a literal ${.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/att/gopkgs/clike"
+)
+
+const (
+	ex_new	int = iota		// key has not been visited
+	ex_busy					// key is being expanded; seeing it again is a cycle
+	ex_done					// key has been expanded
+)
+
+/*
+	Expands references in every value that was read from a file. References are of
+	the form:
+		${ENV:name}		the value of the environment variable name
+		${section.key}	the value of key in the named section
+		${key}			the value of key in the same section, or in the default section
+
+	Referenced keys are expanded before their value is used, so references may be
+	chained.  A reference which cannot be resolved, or a chain of references which
+	leads back to itself, results in an error which names the file and line of the
+	key being expanded.  Sections and keys are visited in sorted order so that the
+	same error is reported on each run.  A value last set by a heredoc is taken
+	verbatim and is not expanded, so scripts can contain ${...} of their own. Elsewhere
+	$${ is written to give a literal ${.
+*/
+func (p *cfg_parser) expand( m map[string]map[string]interface{} ) ( err error ) {
+	state := make( map[string]int )
+
+	for _, sname := range p.prov.sect_names() {
+		for _, key := range p.prov.key_names( sname ) {
+			_, err = p.expand_key( m, sname, key, state, nil )
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+/*
+	Expand a single key and return its value as a string. Chain is the list of keys
+	(section.key) which are currently being expanded and is used to describe a cycle.
+	Keys which were not read from a file (supplied by the caller in the section map)
+	are returned as is.  Each element of a list is expanded, and when a list is
+	referenced its elements are separated with spaces.
+*/
+func (p *cfg_parser) expand_key( m map[string]map[string]interface{}, sname string, key string, state map[string]int, chain []string ) ( s string, err error ) {
+	var list []string
+
+	id := sname + "." + key
+
+	switch v := m[sname][key].( type ) {
+		case float64:
+			return strconv.FormatFloat( v, 'f', -1, 64 ), nil
+
+		case *string:
+			if v == nil {
+				return "", nil
+			}
+			s = *v
+
+		case []string:
+			list = v
+			s = strings.Join( v, " " )
+
+		default:
+			return fmt.Sprintf( "%v", v ), nil
+	}
+
+	loc := p.prov.last( sname, key )
+	if loc == nil || loc.verbatim {
+		return s, nil
+	}
+
+	switch state[id] {
+		case ex_done:
+			return s, nil
+
+		case ex_busy:
+			return "", fmt.Errorf( "%s:%d: reference cycle: %s -> %s", loc.Fname, loc.Line, strings.Join( chain, " -> " ), id )
+	}
+
+	if ! strings.Contains( s, "${" ) {
+		state[id] = ex_done
+		return s, nil
+	}
+
+	state[id] = ex_busy
+	chain = append( chain, id )
+
+	if list != nil {
+		nl := make( []string, len( list ) )
+		for i, e := range list {
+			if nl[i], err = p.expand_refs( m, sname, id, e, loc, state, chain ); err != nil {
+				return "", err
+			}
+		}
+
+		state[id] = ex_done
+		m[sname][key] = nl
+		return strings.Join( nl, " " ), nil
+	}
+
+	exp, err := p.expand_refs( m, sname, id, s, loc, state, chain )
+	if err != nil {
+		return "", err
+	}
+
+	state[id] = ex_done
+	if ! p.all_str && len( exp ) > 0 && ((exp[0] >= '0' && exp[0] <= '9') || exp[0] == '+' || exp[0] == '-') {		// same conversion rule as the parser
+		m[sname][key] = clike.Atof( exp )
+	} else {
+		m[sname][key] = &exp
+	}
+
+	return exp, nil
+}
+
+/*
+	Replace each reference in s with its value. Id and loc are the key being expanded
+	and its origin, and are used in error messages.
+*/
+func (p *cfg_parser) expand_refs( m map[string]map[string]interface{}, sname string, id string, s string, loc *Origin, state map[string]int, chain []string ) ( exp string, err error ) {
+	rest := s
+	for {
+		i := strings.Index( rest, "${" )
+		if i < 0 {
+			break
+		}
+
+		if i > 0 && rest[i-1] == '$' {				// $${ is an escaped, literal, ${
+			exp += rest[:i-1] + "${"
+			rest = rest[i+2:]
+			continue
+		}
+
+		j := strings.Index( rest[i:], "}" )
+		if j < 0 {
+			return "", fmt.Errorf( "%s:%d: unterminated reference in %s: %s", loc.Fname, loc.Line, id, rest[i:] )
+		}
+		j += i
+
+		exp += rest[:i]
+		ref := rest[i+2:j]
+		rest = rest[j+1:]
+
+		if strings.HasPrefix( ref, "ENV:" ) {
+			ev, ok := os.LookupEnv( ref[4:] )
+			if ! ok {
+				return "", fmt.Errorf( "%s:%d: undefined environment variable referenced by %s: %s", loc.Fname, loc.Line, id, ref[4:] )
+			}
+			exp += ev
+			continue
+		}
+
+		rsname, rkey, ok := find_ref( m, sname, ref )
+		if ! ok {
+			return "", fmt.Errorf( "%s:%d: undefined reference in %s: ${%s}", loc.Fname, loc.Line, id, ref )
+		}
+
+		rv, err := p.expand_key( m, rsname, rkey, state, chain )
+		if err != nil {
+			return "", err
+		}
+		exp += rv
+	}
+
+	return exp + rest, nil
+}
+
+/*
+	Map a reference to the section and key it names. A reference containing a dot is
+	first tried as section.key; if that doesn't exist the whole reference is taken as a
+	key name (keys may contain dots) and looked up in the current section and then in
+	the default section.
+*/
+func find_ref( m map[string]map[string]interface{}, sname string, ref string ) ( rsname string, rkey string, ok bool ) {
+	if i := strings.Index( ref, "." ); i > 0 {
+		if sm := m[ref[:i]]; sm != nil {
+			if _, ok = sm[ref[i+1:]]; ok {
+				return ref[:i], ref[i+1:], true
+			}
+		}
+	}
+
+	if _, ok = m[sname][ref]; ok {
+		return sname, ref, true
+	}
+
+	if _, ok = m["default"][ref]; ok {
+		return "default", ref, true
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,289 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	loader
+	Abstract:	Builds one config from layered sources: built in defaults, config
+				files, environment variables and command line flags.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+	A source of values for a loader. Sources are created with one of the _source
+	functions (e.g. File_source()).
+*/
+type Source interface {
+	load( l *Loader ) ( error )
+}
+
+/*
+	Loader applies a list of sources, in order, to build a single config. Values from
+	a later source override those from an earlier one.
+*/
+type Loader struct {
+	sources	[]Source
+	m		map[string]map[string]interface{}
+	prov	provenance
+}
+
+/*
+	Mk_loader creates a loader with an initial (possibly empty) list of sources.
+*/
+func Mk_loader( sources ...Source ) ( l *Loader ) {
+	l = &Loader {
+		sources: sources,
+	}
+
+	return
+}
+
+/*
+	Add adds a source to the end of the list.
+*/
+func (l *Loader) Add( s Source ) {
+	l.sources = append( l.sources, s )
+}
+
+/*
+	Load applies each source in order and returns the merged config. The origin of each
+	value is recorded; values which don't come from a file have an origin with a line
+	number of 0 and a file name which describes the source (e.g. "(env) APP_AGENT_PORT").
+	Load may be called again to rebuild the config, for instance after a file changes.
+*/
+func (l *Loader) Load( ) ( c *Config, err error ) {
+	l.m = make( map[string]map[string]interface{} )
+	l.m["default"] = make( map[string]interface{} )
+	l.prov = make( provenance )
+
+	for _, s := range l.sources {
+		if err = s.load( l ); err != nil {
+			return nil, err
+		}
+	}
+
+	c = &Config {
+		sects: l.m,
+		prov: l.prov,
+	}
+
+	return c, nil
+}
+
+/*
+	Set a single value and record the origin.
+*/
+func (l *Loader) set( sname string, key string, value string, what string ) {
+	if l.m[sname] == nil {
+		l.m[sname] = make( map[string]interface{} )
+	}
+
+	op := OP_SET
+	if _, had := l.m[sname][key]; had {
+		op = OP_OVERRIDE
+	}
+
+	v := value
+	l.m[sname][key] = &v
+	l.prov.add( sname, key, &Origin{ Fname: what, Line: 0, Op: op } )
+}
+
+// ---- defaults ---------------------------------------------------------------------------
+
+type defaults_source struct {
+	defs	map[string]map[string]string
+}
+
+/*
+	Defaults_source returns a source which supplies built in defaults given as a map
+	of sections, each a map of key/value pairs.
+*/
+func Defaults_source( defs map[string]map[string]string ) ( Source ) {
+	return &defaults_source{ defs: defs }
+}
+
+func (ds *defaults_source) load( l *Loader ) ( error ) {
+	for sname, sect := range ds.defs {
+		for key, value := range sect {
+			l.set( sname, key, value, "(defaults)" )
+		}
+	}
+
+	return nil
+}
+
+// ---- files ------------------------------------------------------------------------------
+
+type file_source struct {
+	fname	string
+}
+
+/*
+	File_source returns a source which parses the named config file on top of the values
+	from earlier sources.  References in the file (${key}) may name values supplied by
+	an earlier source.
+*/
+func File_source( fname string ) ( Source ) {
+	return &file_source{ fname: fname }
+}
+
+func (fs *file_source) load( l *Loader ) ( err error ) {
+	var p *cfg_parser
+
+	l.m, p, err = parse_all( l.m, fs.fname, true )
+	if err != nil {
+		return err
+	}
+
+	for sname, keys := range p.prov {
+		for key, olist := range keys {
+			for _, o := range olist {
+				l.prov.add( sname, key, o )
+			}
+		}
+	}
+
+	return nil
+}
+
+// ---- environment ------------------------------------------------------------------------
+
+type env_source struct {
+	prefix	string
+}
+
+/*
+	Env_source returns a source which takes values from environment variables of the
+	form PREFIX_SECTION_KEY. The section is matched, without regard to case and with
+	dashes treated as underbars, against the sections defined by the earlier sources;
+	the longest matching section name is used.  If no section matches, the variable is
+	PREFIX_KEY and the value is placed into the default section. Key names are
+	converted to lower case.
+*/
+func Env_source( prefix string ) ( Source ) {
+	return &env_source{ prefix: strings.ToUpper( prefix ) + "_" }
+}
+
+func (es *env_source) load( l *Loader ) ( error ) {
+	env := os.Environ()
+	sort.Strings( env )										// consistent order if two variables map to the same key
+
+	snames := make( []string, 0, len( l.m ) )
+	for sname := range l.m {
+		snames = append( snames, sname )
+	}
+	sort.Slice( snames, func( i, j int ) bool { return len( snames[i] ) > len( snames[j] ) } )		// longest first
+
+	for _, ev := range env {
+		eq := strings.Index( ev, "=" )
+		if eq < 0 || ! strings.HasPrefix( ev[:eq], es.prefix ) {
+			continue
+		}
+		name := ev[len( es.prefix ):eq]
+		if name == "" {
+			continue
+		}
+
+		sname := "default"
+		for _, sn := range snames {
+			spfx := strings.ToUpper( strings.Replace( sn, "-", "_", -1 ) ) + "_"
+			if sn != "default" && strings.HasPrefix( name, spfx ) && len( name ) > len( spfx ) {
+				sname = sn
+				name = name[len( spfx ):]
+				break
+			}
+		}
+
+		l.set( sname, strings.ToLower( name ), ev[eq+1:], "(env) " + ev[:eq] )
+	}
+
+	return nil
+}
+
+// ---- flags ------------------------------------------------------------------------------
+
+/*
+	Collects the -set section.key=value flags; implements flag.Value.
+*/
+type flag_source struct {
+	sets	[]string
+}
+
+/*
+	Flag_source registers a -set flag with the flag set and returns a source which
+	applies the values given on the command line.  The flag may be given more than
+	once, each in the form:
+		-set section.key=value
+
+	If the section is omitted (-set key=value) the default section is used.  The flag
+	set must be parsed before the loader's Load() function is invoked.
+*/
+func Flag_source( fs *flag.FlagSet ) ( Source ) {
+	fsrc := &flag_source{}
+	fs.Var( fsrc, "set", "set a config value: section.key=value (may be repeated)" )
+
+	return fsrc
+}
+
+func (fsrc *flag_source) String( ) ( string ) {
+	if fsrc == nil {
+		return ""
+	}
+
+	return strings.Join( fsrc.sets, " " )
+}
+
+func (fsrc *flag_source) Set( s string ) ( error ) {
+	eq := strings.Index( s, "=" )
+	if eq < 1 {
+		return fmt.Errorf( "expected section.key=value: %s", s )
+	}
+
+	fsrc.sets = append( fsrc.sets, s )
+	return nil
+}
+
+func (fsrc *flag_source) load( l *Loader ) ( error ) {
+	for _, s := range fsrc.sets {
+		eq := strings.Index( s, "=" )
+		name := s[:eq]
+
+		sname := "default"
+		if dot := strings.Index( name, "." ); dot > 0 {
+			sname = name[:dot]
+			name = name[dot+1:]
+		}
+
+		l.set( sname, name, s[eq+1:], "(flag) -set " + s[:eq] )
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	records
+	Abstract:	Reads logical records from a config file. A logical record is
+				normally one line, but may span several when a line is continued
+				with a trailing backslash, when a list value ([a, b]) is not closed
+				on the first line, or when the value is a heredoc (key <<EOF).
+				Both the parser and the document use this so that they always agree
+				on what makes up a record.
+	Date:		18 October 2026
+	Author:		agent
+
+	Mods:		18 Oct 2026 - Comments are removed from each line of a list.
+*/
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/att/gopkgs/token"
+)
+
+/*
+	One logical record.
+*/
+type cfg_rec struct {
+	raw		string			// exact text of all physical lines, including newlines
+	rec		string			// the record with continuations joined and lead/trail whitespace removed
+	line	int				// line number of the first physical line
+	heredoc	*string			// the body if the value is a heredoc
+}
+
+/*
+	Reads records from a config file.
+*/
+type rec_reader struct {
+	br		*bufio.Reader
+	fname	string			// for error messages
+	lineno	int				// number of physical lines read
+}
+
+func mk_rec_reader( r io.Reader, fname string ) ( *rec_reader ) {
+	return &rec_reader{ br: bufio.NewReader( r ), fname: fname }
+}
+
+/*
+	Read one physical line. Eof is true when there is nothing left; a last line without a
+	newline is returned with eof false and eof is returned on the next call.
+*/
+func (rr *rec_reader) read_line( ) ( line string, eof bool, err error ) {
+	line, err = rr.br.ReadString( '\n' )
+	if err == io.EOF {
+		if line == "" {
+			return "", true, nil
+		}
+		err = nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	rr.lineno++
+	return line, false, nil
+}
+
+/*
+	Return the value portion of a key/value record: everything after the key, the
+	= or +=, and any whitespace. An empty string is returned if there is nothing
+	after the key.
+*/
+func raw_value( rec string ) ( string ) {
+	i := strings.IndexAny( rec, " \t=" )
+	if i < 0 {
+		return ""
+	}
+
+	v := strings.TrimLeft( rec[i:], " \t" )
+	if strings.HasPrefix( v, "+=" ) {
+		v = v[2:]
+	} else {
+		if strings.HasPrefix( v, "=" ) {
+			v = v[1:]
+		}
+	}
+
+	return strings.TrimLeft( v, " \t" )
+}
+
+/*
+	Return the heredoc tag if the value is <<TAG, or "" if it is not a heredoc.
+*/
+func heredoc_tag( v string ) ( string ) {
+	if ! strings.HasPrefix( v, "<<" ) {
+		return ""
+	}
+
+	fields := strings.Fields( v[2:] )
+	if len( fields ) == 0 || strings.HasPrefix( v[2:], " " ) || strings.HasPrefix( fields[0], "#" ) {
+		return ""
+	}
+
+	return fields[0]
+}
+
+/*
+	Returns the index of the bracket that closes the list starting at v[0], or -1 if
+	the list is not closed. Brackets inside of quotes are ignored.
+*/
+func list_end( v string ) ( int ) {
+	depth := 0
+	inq := false
+	for i := 0; i < len( v ); i++ {
+		switch v[i] {
+			case '\\':
+				i++
+
+			case '"':
+				inq = !inq
+
+			case '[':
+				if ! inq {
+					depth++
+				}
+
+			case ']':
+				if ! inq {
+					depth--
+					if depth == 0 {
+						return i
+					}
+				}
+		}
+	}
+
+	return -1
+}
+
+/*
+	Remove a comment from a line of a list. A comment starts with a # which begins an
+	element (it follows a separator or the opening bracket) and is not in quotes.
+*/
+func strip_comment( s string ) ( string ) {
+	inq := false
+	for i := 0; i < len( s ); i++ {
+		switch s[i] {
+			case '\\':
+				i++
+
+			case '"':
+				inq = !inq
+
+			case '#':
+				if ! inq && (i == 0 || strings.IndexByte( " \t,[", s[i-1] ) >= 0) {
+					return strings.TrimRight( s[:i], " \t" )
+				}
+		}
+	}
+
+	return s
+}
+
+/*
+	Split a list value ([a, b, "c d"]) into its elements. Elements are separated by
+	commas and/or whitespace, so an element containing either must be quoted.  Quotes
+	are handled by the token package in the same way as they are for other values.
+	Anything following the closing bracket is returned as rest.
+*/
+func parse_list( v string ) ( list []string, rest string, err error ) {
+	end := list_end( v )
+	if end < 0 {
+		return nil, "", fmt.Errorf( "list is not closed" )
+	}
+
+	list = make( []string, 0 )
+	inner := strings.Trim( v[1:end], " \t\n" )
+	if inner != "" {
+		_, tokens := token.Tokenise_qpopulated( inner, ", \t\n" )
+		for _, t := range tokens {
+			if t != "" {
+				list = append( list, t )
+			}
+		}
+	}
+
+	return list, strings.TrimLeft( v[end+1:], " \t" ), nil
+}
+
+/*
+	Returns the next logical record. Eof is true when there are no more records.
+*/
+func (rr *rec_reader) next( ) ( cr *cfg_rec, eof bool, err error ) {
+	line, eof, err := rr.read_line()
+	if eof || err != nil {
+		return nil, eof, err
+	}
+
+	cr = &cfg_rec{ raw: line, line: rr.lineno }
+	rec := strings.Trim( line, " \t\r\n" )
+
+	for strings.HasSuffix( rec, "\\" ) {						// continued; join with the next line
+		line, eof, err = rr.read_line()
+		if err != nil {
+			return nil, false, err
+		}
+		if eof {
+			rec = strings.TrimRight( rec[:len( rec )-1], " \t" )
+			break
+		}
+
+		cr.raw += line
+		rec = strings.TrimRight( rec[:len( rec )-1], " \t" ) + " " + strings.Trim( line, " \t\r\n" )
+	}
+	cr.rec = rec
+
+	if rec == "" || strings.ContainsRune( ":#<", rune( rec[0] ) ) {
+		return cr, false, nil
+	}
+
+	v := raw_value( rec )
+	if tag := heredoc_tag( v ); tag != "" {
+		body := make( []string, 0 )
+		for {
+			line, eof, err = rr.read_line()
+			if err != nil {
+				return nil, false, err
+			}
+			if eof {
+				return nil, false, fmt.Errorf( "%s:%d: heredoc is not terminated with %s", rr.fname, cr.line, tag )
+			}
+
+			cr.raw += line
+			if strings.Trim( line, " \t\r\n" ) == tag {
+				break
+			}
+			body = append( body, strings.TrimRight( line, "\r\n" ) )
+		}
+
+		hd := strings.Join( body, "\n" )
+		cr.heredoc = &hd
+		return cr, false, nil
+	}
+
+	if strings.HasPrefix( v, "[" ) {
+		v = strip_comment( v )								// comments may follow the elements on any line
+		cr.rec = strip_comment( cr.rec )
+		for list_end( v ) < 0 {								// list continues onto the next line
+			line, eof, err = rr.read_line()
+			if err != nil {
+				return nil, false, err
+			}
+			if eof {
+				return nil, false, fmt.Errorf( "%s:%d: list is not closed", rr.fname, cr.line )
+			}
+
+			cr.raw += line
+			t := strip_comment( strings.Trim( line, " \t\r\n" ) )
+			cr.rec += " " + t
+			v += " " + t
+		}
+	}
+
+	return cr, false, nil
+}
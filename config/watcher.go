@@ -0,0 +1,302 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2026 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+
+	Mnemonic:	watcher
+	Abstract:	Watches a config file, and the files it includes, reparsing when
+				any of them change and sending the differences to the user on a
+				channel.
+	Date:		18 October 2026
+	Author:		agent
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/att/gopkgs/ipc"
+)
+
+const (
+	CHG_ADDED	int = iota		// key is new
+	CHG_REMOVED					// key no longer exists
+	CHG_CHANGED					// key has a new value
+)
+
+/*
+	Describes a change to one key between two parses of the config.
+*/
+type Change struct {
+	Sect	string
+	Key		string
+	Kind	int					// CHG_* constant
+	Old		interface{}			// nil when added
+	New		interface{}			// nil when removed
+}
+
+/*
+	Size and modification time of a watched file. Exists is false if the file
+	could not be stat'd.
+*/
+type file_state struct {
+	exists	bool
+	mtime	time.Time
+	size	int64
+}
+
+/*
+	Manages the watching of a config file.
+*/
+type Watcher struct {
+	fname	string
+	all_str	bool
+	ch		chan *ipc.Chmsg			// where change messages are written
+	mtype	int						// message type placed on each message
+	cur		map[string]map[string]interface{}		// last good config
+	files	map[string]*file_state	// files included in the last parse and their state
+	stop_ch	chan bool
+	mu		sync.Mutex
+}
+
+/*
+	Get the current state of the file.
+*/
+func stat_file( fname string ) ( *file_state ) {
+	fi, err := os.Stat( fname )
+	if err != nil {
+		return &file_state{ exists: false }
+	}
+
+	return &file_state{ exists: true, mtime: fi.ModTime(), size: fi.Size() }
+}
+
+/*
+	Record the state of each file in the list.
+*/
+func (w *Watcher) snap_files( fnames []string ) {
+	w.files = make( map[string]*file_state, len( fnames ) )
+	for _, fname := range fnames {
+		w.files[fname] = stat_file( fname )
+	}
+}
+
+/*
+	Mk_watcher parses the file and returns a watcher object. The file must parse
+	successfully or an error is returned. All_str is passed to Parse() on each parse.
+	Once started, messages describing changes are written to ch with a message type
+	of mtype.
+*/
+func Mk_watcher( fname string, all_str bool, ch chan *ipc.Chmsg, mtype int ) ( w *Watcher, err error ) {
+	m, p, err := parse_all( nil, fname, all_str )
+	if err != nil {
+		return nil, err
+	}
+
+	w = &Watcher {
+		fname: fname,
+		all_str: all_str,
+		ch: ch,
+		mtype: mtype,
+		cur: m,
+	}
+	w.snap_files( p.files )
+
+	return
+}
+
+/*
+	Get_config returns the last successfully parsed config.
+*/
+func (w *Watcher) Get_config( ) ( map[string]map[string]interface{} ) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.cur
+}
+
+/*
+	Check looks at the main file and the files it included and reparses if any of them
+	have changed since the last check.  When the parse is successful, and the result
+	differs from the previous config, a message is written to the user channel with
+	Req_data set to the list of changes ([]*Change).  If the parse fails, the previous
+	config is kept and a message with a nil Req_data and State set to the parse error
+	is written.  The error is reported only once; the files must change again before
+	another parse is attempted.
+
+	Check is invoked by the goroutine started with Start(), but may be called directly
+	by the user if polling on its own schedule.
+*/
+func (w *Watcher) Check( ) {
+	w.mu.Lock()
+	changed := false
+	for fname, fs := range w.files {
+		ns := stat_file( fname )
+		if ns.exists != fs.exists || ! ns.mtime.Equal( fs.mtime ) || ns.size != fs.size {
+			changed = true
+			break
+		}
+	}
+	w.mu.Unlock()
+
+	if ! changed {
+		return
+	}
+
+	m, p, err := parse_all( nil, w.fname, w.all_str )
+
+	w.mu.Lock()
+	w.snap_files( p.files )						// files from this parse; if it failed we watch what it tried to read
+	if err != nil {
+		w.mu.Unlock()
+
+		msg := ipc.Mk_chmsg()
+		msg.Msg_type = w.mtype
+		msg.State = fmt.Errorf( "config: reload of %s failed, previous config kept: %s", w.fname, err )
+		w.ch <- msg
+		return
+	}
+
+	changes := Diff( w.cur, m )
+	w.cur = m
+	w.mu.Unlock()
+
+	if len( changes ) > 0 {
+		msg := ipc.Mk_chmsg()
+		msg.Send_req( w.ch, nil, w.mtype, changes, nil )
+	}
+}
+
+/*
+	Start causes a goroutine to be started which checks the files every period.
+*/
+func (w *Watcher) Start( period time.Duration ) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop_ch != nil {					// already running
+		return
+	}
+
+	w.stop_ch = make( chan bool, 1 )
+	go w.watch( period, w.stop_ch )
+}
+
+/*
+	Stop halts the goroutine started by Start. The watcher may be restarted.
+*/
+func (w *Watcher) Stop( ) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stop_ch != nil {
+		w.stop_ch <- true
+		w.stop_ch = nil
+	}
+}
+
+/*
+	Loop until stopped, checking for changes each period.
+*/
+func (w *Watcher) watch( period time.Duration, stop_ch chan bool ) {
+	tick := time.NewTicker( period )
+	defer tick.Stop()
+
+	for {
+		select {
+			case <-stop_ch:
+				return
+
+			case <-tick.C:
+				w.Check()
+		}
+	}
+}
+
+/*
+	Compare two values from a section map. String pointers are compared by the
+	string they reference.
+*/
+func same_value( a interface{}, b interface{} ) ( bool ) {
+	as, aok := a.( *string )
+	bs, bok := b.( *string )
+	if aok && bok && as != nil && bs != nil {
+		return *as == *bs
+	}
+
+	return reflect.DeepEqual( a, b )
+}
+
+/*
+	Diff compares two parsed configs and returns a list of changes needed to turn
+	old into new.  The list is ordered by section and then key.
+*/
+func Diff( old map[string]map[string]interface{}, new map[string]map[string]interface{} ) ( changes []*Change ) {
+	snames := make( map[string]bool )
+	for sname := range old {
+		snames[sname] = true
+	}
+	for sname := range new {
+		snames[sname] = true
+	}
+
+	slist := make( []string, 0, len( snames ) )
+	for sname := range snames {
+		slist = append( slist, sname )
+	}
+	sort.Strings( slist )
+
+	for _, sname := range slist {
+		keys := make( []string, 0 )
+		for key := range old[sname] {
+			keys = append( keys, key )
+		}
+		for key := range new[sname] {
+			if _, ok := old[sname][key]; ! ok {
+				keys = append( keys, key )
+			}
+		}
+		sort.Strings( keys )
+
+		for _, key := range keys {
+			ov, in_old := old[sname][key]
+			nv, in_new := new[sname][key]
+
+			switch {
+				case ! in_old:
+					changes = append( changes, &Change{ Sect: sname, Key: key, Kind: CHG_ADDED, New: nv } )
+
+				case ! in_new:
+					changes = append( changes, &Change{ Sect: sname, Key: key, Kind: CHG_REMOVED, Old: ov } )
+
+				case ! same_value( ov, nv ):
+					changes = append( changes, &Change{ Sect: sname, Key: key, Kind: CHG_CHANGED, Old: ov, New: nv } )
+			}
+		}
+	}
+
+	return
+}
@@ -38,6 +38,8 @@ import (
 				30 Nov 2014 - Allows escaped quote.
 				09 Apr 2015 - Corrected problem where index was not being checked and range
 					was being busted causing a panic. Removed the 2k limit.
+				18 Oct 2026 - Escape characters in a quoted portion no longer leave nil bytes
+					at the end of the token.
 ---------------------------------------------------------------------------------------------
 */
 
@@ -136,15 +138,16 @@ func tokenise_all( buf string, sepchrs string, keep_empty bool ) (int,  []string
 			subbuf = subbuf[q+1:];										// skip what we just snarfed, and opening quote
 			ttok := make( []byte, len( subbuf ) )						// work space to strip escape characters in
 			q = 0
-			for ttidx := 0; q < len( subbuf )  && subbuf[q] != '"'; ttidx++ { 		// find end trashing escape characters
-				if subbuf[q] == '\\' {
+			ttidx := 0
+			for ; q < len( subbuf )  && subbuf[q] != '"'; ttidx++ { 		// find end trashing escape characters
+				if subbuf[q] == '\\' && q + 1 < len( subbuf ) {
 					q++
 				}
 				ttok[ttidx] = subbuf[q]
 				q++
 			}
-			if q > 0 {													// could have been ,foo""
-				tokens[idx] += string( ttok[0:q] )
+			if ttidx > 0 {												// could have been ,foo""
+				tokens[idx] += string( ttok[0:ttidx] )
 			}
 			subbuf = subbuf[q+1:]
 			i = strings.IndexAny( subbuf, sepchrs )						// next sep, if there, past quoted stuff
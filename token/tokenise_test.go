@@ -166,6 +166,16 @@ func TestToken_qsep_pop( t *testing.T ) {
 	}
 	fmt.Fprintf( os.Stderr, "expected: '%s' found: '%s got %d tokens'   [OK]\n", expect, tokens[1], ntokens )
 
+	//----------------------------------------------
+	str = `hello "say \"hi\" \\o/" world`						// escaped quote and backslant; no trailing junk
+	expect = `say "hi" \o/`
+	fmt.Fprintf( os.Stderr, "testing: (%s)\n", str )
+	ntokens, tokens = token.Tokenise_qpopulated( str, " " )
+	if ntokens != 3 || tokens[1] != expect {
+		fmt.Fprintf( os.Stderr, "FAIL: expected 3 tokens with token 2 (%s), got %d tokens: %q\n", expect, ntokens, tokens )
+		t.Fail()
+	}
+
 	//----------------------------------------------
 }
 
@@ -632,3 +632,26 @@ func TestPopulate( t *testing.T ) {
 		t.Fail()
 	}
 }
+
+/*
+	A comma in the tag is part of the name; it is not an options separator.
+*/
+func TestTagComma( t *testing.T ) {
+	type Comma_thing struct {
+		Name	string	`Cm:"a,b"`
+		Count	int		`Cm:"count"`
+	}
+
+	ct := &Comma_thing{}
+	transform.Map_to_struct( map[string]string{ "a,b": "fred", "a": "wrong", "count": "42" }, ct, "Cm" )
+	if ct.Name != "fred" || ct.Count != 42 {
+		fmt.Fprintf( os.Stderr, "FAIL: tag with comma not mapped to key a,b: name=(%s) count=%d\n", ct.Name, ct.Count )
+		t.Fail()
+	}
+
+	m := transform.Struct_to_map( ct, "Cm" )
+	if m["a,b"] != "fred" || m["count"] != "42" {
+		fmt.Fprintf( os.Stderr, "FAIL: struct_to_map did not use the whole tag as the key: %v\n", m )
+		t.Fail()
+	}
+}
@@ -45,7 +45,9 @@ import (
 		tag_id:"tagstr"
 	where tagstr is used as the field name in the map to look up.  If tagstr
 	is given as an '_' (underbar) character, then the structure field name is
-	used.   Names are case sensitive.
+	used.   Names are case sensitive.  The whole tag string is the name, so a
+	tag of tag_id:"a,b" looks up the key a,b; callers which put options after
+	a comma must build the map with the complete tag as the key.
 
 	This function supports transferring the simple types (bool, int, float, etc.) and 
 	pointers to those types from the map.  It also supports structures, anonymous